@@ -78,6 +78,10 @@ func init() {
 	router.Handler("GET", "/api/auth", CSRF(auth.PasswordLoginPageHandler()))
 	router.Handler("POST", "/api/auth/userlogin", CSRF(auth.PasswordLoginHandler()))
 
+	// Two-factor authentication
+	router.Handler("GET", "/api/auth/two-factor", CSRF(auth.TwoFactorPageHandler()))
+	router.Handler("POST", "/api/auth/usertwofactor", CSRF(auth.TwoFactorHandler()))
+
 	// Forget password
 	router.Handler("GET", "/api/auth/forget", CSRF(auth.ForgetPasswordPageHandler()))
 	router.Handler("POST", "/api/auth/userforget", CSRF(auth.ForgetPasswordHandler()))
@@ -111,9 +115,22 @@ func init() {
 	router.GET("/api/auth/remove-outlook", auth.RemoveOutlookHandler)
 	router.GET("/api/auth/outlookcallback", auth.OutlookCallbackHandler)
 
+	// Log in with or link any other identity provider
+	router.GET("/api/auth/providers/:provider/:action", auth.IdentityProviderHandler)
+
+	// Single sign-on for agencies
+	router.Handler("GET", "/api/auth/sso", CSRF(auth.SSOLoginHandler()))
+	router.GET("/api/auth/sso/oidc/callback", auth.OIDCCallbackHandler)
+	router.POST("/api/auth/sso/saml/acs", auth.SAMLACSHandler)
+	router.GET("/api/auth/sso/saml/metadata", auth.SAMLMetadataHandler)
+
 	// Logout user
 	router.GET("/api/auth/logout", auth.LogoutHandler)
 
+	// Access and refresh tokens for clients that don't use cookies
+	router.Handler("POST", "/api/auth/token", auth.TokenHandler())
+	router.Handler("POST", "/api/auth/token/revoke", auth.RevokeTokenHandler())
+
 	/*
 	 * Billing Handler
 	 */
@@ -139,6 +156,9 @@ func init() {
 	// Optional checks
 	router.Handler("POST", "/api/billing/check-coupon", auth.CheckCouponValid())
 
+	// Events from Stripe. Verified with the Stripe signature instead of a login.
+	router.POST("/api/billing/stripe-webhook", apiRoutes.StripeWebhookHandler)
+
 	// Main billing page for a user
 	router.Handler("GET", "/api/billing", CSRF(auth.BillingPageHandler()))
 
@@ -155,21 +175,76 @@ func init() {
 	router.PATCH("/api/users/:id", apiRoutes.UserHandler)
 	router.GET("/api/users/:id/:action", apiRoutes.UserActionHandler)
 	router.POST("/api/users/:id/:action", apiRoutes.UserActionHandler)
+	router.PATCH("/api/users/:id/api-keys/:keyid", apiRoutes.UserApiKeyHandler)
+	router.DELETE("/api/users/:id/api-keys/:keyid", apiRoutes.UserApiKeyHandler)
+	router.DELETE("/api/users/:id/sessions/:sessionid", apiRoutes.UserSessionHandler)
 
 	router.GET("/api/agencies", apiRoutes.AgenciesHandler)
+	router.POST("/api/agencies", apiRoutes.AgenciesHandler)
 	router.GET("/api/agencies/:id", apiRoutes.AgencyHandler)
+	router.PATCH("/api/agencies/:id", apiRoutes.AgencyHandler)
+	router.GET("/api/agencies/:id/:action", apiRoutes.AgencyActionHandler)
+	router.POST("/api/agencies/:id/:action", apiRoutes.AgencyActionHandler)
 
 	router.GET("/api/clients", apiRoutes.ClientsHandler)
+	router.POST("/api/clients", apiRoutes.ClientsHandler)
 	router.GET("/api/clients/:id", apiRoutes.ClientHandler)
+	router.PATCH("/api/clients/:id", apiRoutes.ClientHandler)
+	router.DELETE("/api/clients/:id", apiRoutes.ClientHandler)
+	router.GET("/api/clients/:id/:action", apiRoutes.ClientActionHandler)
+
+	router.GET("/api/audit-events", apiRoutes.AuditEventsHandler)
+
+	router.GET("/api/webhooks", apiRoutes.WebhooksHandler)
+	router.POST("/api/webhooks", apiRoutes.WebhooksHandler)
+	router.GET("/api/webhooks/:id", apiRoutes.WebhookHandler)
+	router.PATCH("/api/webhooks/:id", apiRoutes.WebhookHandler)
+	router.DELETE("/api/webhooks/:id", apiRoutes.WebhookHandler)
+	router.GET("/api/webhooks/:id/:action", apiRoutes.WebhookActionHandler)
+	router.POST("/api/webhooks/:id/:action", apiRoutes.WebhookActionHandler)
+
+	router.GET("/api/plans", apiRoutes.PlansHandler)
+	router.POST("/api/plans", apiRoutes.PlansHandler)
+	router.GET("/api/plans/:id", apiRoutes.PlanHandler)
+	router.PATCH("/api/plans/:id", apiRoutes.PlanHandler)
+
+	router.GET("/api/billing/invoices", apiRoutes.InvoicesHandler)
+	router.GET("/api/billing/invoices/:id", apiRoutes.InvoiceHandler)
+	router.GET("/api/billing/invoices/:id/receipt", apiRoutes.InvoiceReceiptHandler)
+
+	router.GET("/api/coupon-rules", apiRoutes.CouponRulesHandler)
+	router.POST("/api/coupon-rules", apiRoutes.CouponRulesHandler)
+	router.GET("/api/coupon-rules/:id", apiRoutes.CouponRuleHandler)
+	router.PATCH("/api/coupon-rules/:id", apiRoutes.CouponRuleHandler)
 
 	router.GET("/api/teams", apiRoutes.TeamsHandler)
 	router.POST("/api/teams", apiRoutes.TeamsHandler)
 	router.GET("/api/teams/:id", apiRoutes.TeamHandler)
 	router.GET("/api/teams/:id/:action", apiRoutes.TeamActionHandler)
+	router.POST("/api/teams/:id/:action", apiRoutes.TeamActionHandler)
 
 	router.GET("/api/invites", apiRoutes.InvitesHandler)
 	router.POST("/api/invites", apiRoutes.InvitesHandler)
 
+	router.GET("/api/login-attempts", apiRoutes.LoginAttemptsHandler)
+
+	/*
+	 * SCIM provisioning
+	 */
+
+	router.GET("/scim/v2/Users", apiRoutes.SCIMUsersHandler)
+	router.POST("/scim/v2/Users", apiRoutes.SCIMUsersHandler)
+	router.GET("/scim/v2/Users/:id", apiRoutes.SCIMUserHandler)
+	router.PUT("/scim/v2/Users/:id", apiRoutes.SCIMUserHandler)
+	router.PATCH("/scim/v2/Users/:id", apiRoutes.SCIMUserHandler)
+	router.DELETE("/scim/v2/Users/:id", apiRoutes.SCIMUserHandler)
+
+	router.GET("/scim/v2/Groups", apiRoutes.SCIMGroupsHandler)
+	router.POST("/scim/v2/Groups", apiRoutes.SCIMGroupsHandler)
+	router.GET("/scim/v2/Groups/:id", apiRoutes.SCIMGroupHandler)
+	router.PATCH("/scim/v2/Groups/:id", apiRoutes.SCIMGroupHandler)
+	router.DELETE("/scim/v2/Groups/:id", apiRoutes.SCIMGroupHandler)
+
 	/*
 	 * Tabulae
 	 */
@@ -267,7 +342,18 @@ func init() {
 	// Tasks needing to not have middleware
 	http.HandleFunc("/.well-known/acme-challenge/ZCLfT3oIOdBK0iUF28viK2IEvmjJ46_8NzBEE0F6jxA", apiTasks.LetsEncryptValidation)
 	http.HandleFunc("/tasks/refreshUserLiveTokens", apiTasks.RefreshUserLiveTokens)
+	http.HandleFunc("/tasks/refreshEmailTokens", apiTasks.RefreshEmailTokens)
 	http.HandleFunc("/tasks/makeUsersInactive", apiTasks.MakeUsersInactive)
+	http.HandleFunc("/tasks/reencryptSecrets", apiTasks.ReencryptSecrets)
+	http.HandleFunc("/tasks/migrateApiKeys", apiTasks.MigrateApiKeys)
+	http.HandleFunc("/tasks/backfillLinkedAccounts", apiTasks.BackfillLinkedAccounts)
+	http.HandleFunc("/tasks/setLegacyPlanLimits", apiTasks.SetLegacyPlanLimits)
+	http.HandleFunc("/tasks/userSweepPage", apiTasks.UserSweepPage)
+	http.HandleFunc("/tasks/userSweepBatch", apiTasks.UserSweepBatch)
+	http.HandleFunc("/tasks/userSweepRuns", apiTasks.UserSweepRuns)
+	http.HandleFunc("/tasks/deliverWebhook", apiTasks.DeliverWebhook)
+	http.HandleFunc("/tasks/processDunning", apiTasks.ProcessDunning)
+	http.HandleFunc("/tasks/syncAgencySeats", apiTasks.SyncAgencySeats)
 	http.HandleFunc("/tasks/removeExpiredSessions", gaeTasks.RemoveExpiredSessionsHandler)
 	http.HandleFunc("/tasks/removeImportedFiles", tabulaeTasks.RemoveImportedFilesHandler)
 
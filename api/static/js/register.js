@@ -1,6 +1,10 @@
 var codeParameter = $.urlParam('code');
 if (codeParameter) {
     document.getElementById("invitation_code").value = codeParameter;
+
+    // Pass the invitation code along when signing up with Google
+    var googleSignup = document.getElementById("google_signup");
+    googleSignup.href = googleSignup.href + "&invitationcode=" + encodeURIComponent(codeParameter);
 }
 
 var emailParameter = $.urlParam('email');
@@ -0,0 +1,112 @@
+// Package audit records security and billing events in the audit log.
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+
+	"github.com/news-ai/api/models"
+)
+
+/*
+* Private methods
+ */
+
+func getIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func toJSON(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func getTarget(target interface{}) (string, int64) {
+	switch v := target.(type) {
+	case models.User:
+		return "User", v.Id
+	case models.Team:
+		return "Team", v.Id
+	case models.Agency:
+		return "Agency", v.Id
+	case models.Billing:
+		return "Billing", v.Id
+	case models.Plan:
+		return "Plan", v.Id
+	case models.CouponRule:
+		return "CouponRule", v.Id
+	}
+	return "", 0
+}
+
+func addUserId(userIds []int64, userId int64) []int64 {
+	if userId == 0 {
+		return userIds
+	}
+
+	for i := 0; i < len(userIds); i++ {
+		if userIds[i] == userId {
+			return userIds
+		}
+	}
+	return append(userIds, userId)
+}
+
+/*
+* Public methods
+ */
+
+// Records an event done by the user that is logged in. Users that the
+// event is about, other than a user target, are passed in userIds.
+func Record(r *http.Request, action string, target interface{}, before interface{}, after interface{}, userIds ...int64) {
+	actor := models.User{}
+	if user, ok := gcontext.GetOk(r, "user"); ok {
+		actor, _ = user.(models.User)
+	}
+	RecordAs(r, actor, action, target, before, after, userIds...)
+}
+
+// Records an event for an actor that is not logged in
+func RecordAs(r *http.Request, actor models.User, action string, target interface{}, before interface{}, after interface{}, userIds ...int64) {
+	c := appengine.NewContext(r)
+
+	auditEvent := models.AuditEvent{}
+	auditEvent.ActorId = actor.Id
+	auditEvent.ActorEmail = actor.Email
+	auditEvent.Action = action
+	auditEvent.TargetType, auditEvent.TargetId = getTarget(target)
+	auditEvent.Before = toJSON(before)
+	auditEvent.After = toJSON(after)
+	auditEvent.IP = getIP(r)
+	auditEvent.UserAgent = r.UserAgent()
+
+	auditEvent.UserIds = addUserId(auditEvent.UserIds, actor.Id)
+	if auditEvent.TargetType == "User" {
+		auditEvent.UserIds = addUserId(auditEvent.UserIds, auditEvent.TargetId)
+	}
+	for i := 0; i < len(userIds); i++ {
+		auditEvent.UserIds = addUserId(auditEvent.UserIds, userIds[i])
+	}
+
+	_, err := auditEvent.Create(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+}
@@ -2,33 +2,60 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 
-	"github.com/news-ai/api/controllers"
+	gcontext "github.com/gorilla/context"
 
-	"github.com/news-ai/web/utilities"
+	"github.com/news-ai/api/controllers"
+	"github.com/news-ai/api/models"
 )
 
+// Logs a user in for a single request with one of their api keys. Api keys
+// don't create a session so they can be revoked at any time.
 func BasicAuthLogin(w http.ResponseWriter, r *http.Request, apiKey string) bool {
-	// Generate a random state that we identify the user with
-	state := utilities.RandToken()
-
-	// Save the session for each of the users
-	session, _ := Store.Get(r, "sess")
-	session.Values["state"] = state
-	session.Save(r, w)
-
-	user, err := controllers.GetUserFromApiKey(r, apiKey)
+	user, key, err := controllers.GetUserFromApiKey(r, apiKey)
 	if err != nil {
 		return false
 	}
 
-	session.Values["id"] = user.Id
-	session.Values["email"] = user.Email
-	session.Save(r, w)
-
+	gcontext.Set(r, "user", user)
+	gcontext.Set(r, "apikey", key)
 	return true
 }
 
+// Checks if the scopes of the api key used for a request allow it
+func ApiKeyAllowsRequest(r *http.Request) bool {
+	value, ok := gcontext.GetOk(r, "apikey")
+	if !ok {
+		return true
+	}
+	key := value.(models.ApiKey)
+
+	if key.HasScope(models.ApiKeyScopeFull) {
+		return true
+	}
+
+	path := r.URL.Path
+	isBilling := strings.HasPrefix(path, "/api/billing") || strings.HasSuffix(path, "/plan-details") || strings.HasSuffix(path, "/add-plan")
+	isMediaDatabase := strings.HasPrefix(path, "/api/database-")
+	isApiKeys := strings.Contains(path, "/api-keys")
+
+	if isBilling && key.HasScope(models.ApiKeyScopeBilling) {
+		return true
+	}
+
+	if isMediaDatabase && key.HasScope(models.ApiKeyScopeMediaDatabase) {
+		return true
+	}
+
+	// Read-only keys can look at anything apart from billing and other keys
+	if key.HasScope(models.ApiKeyScopeReadOnly) && (r.Method == "GET" || r.Method == "HEAD") {
+		return !isBilling && !isApiKeys
+	}
+
+	return false
+}
+
 func BasicAuthLogout(w http.ResponseWriter, r *http.Request) {
 	session, _ := Store.Get(r, "sess")
 	delete(session.Values, "state")
@@ -5,10 +5,13 @@ import (
 	"net/http"
 	"os"
 
+	"google.golang.org/appengine"
+
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/news-ai/gaesessions"
 
+	apiControllers "github.com/news-ai/api/controllers"
 	"github.com/news-ai/api/utils"
 )
 
@@ -30,6 +33,7 @@ var Store = gaesessions.NewMemcacheDatastoreStore("", "",
 
 func SetRedirectURL() {
 	googleOauthConfig.RedirectURL = utils.APIURL + "/auth/googlecallback"
+	linkedinOauthConfig.RedirectURL = utils.APIURL + "/auth/providers/linkedin/callback"
 }
 
 // Gets the email of the current user that is logged in
@@ -61,11 +65,19 @@ func GetCurrentUserId(r *http.Request) (int64, error) {
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
 	session, _ := Store.Get(r, "sess")
-	delete(session.Values, "state")
-	delete(session.Values, "id")
-	delete(session.Values, "email")
-	session.Save(r, w)
+	if session.Values["sessionid"] != nil {
+		user, err := apiControllers.GetCurrentUser(c, r)
+		if err == nil {
+			userSession, err := apiControllers.TouchUserSession(c, r, session.Values["sessionid"].(int64), user)
+			if err == nil {
+				userSession.Revoke(c)
+			}
+		}
+	}
+
+	clearSession(w, r)
 
 	if r.URL.Query().Get("next") != "" {
 		http.Redirect(w, r, r.URL.Query().Get("next"), 302)
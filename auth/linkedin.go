@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/urlfetch"
+
+	apiModels "github.com/news-ai/api/models"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/linkedin"
+)
+
+type LinkedinResponse struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+var (
+	linkedinOauthConfig = &oauth2.Config{
+		RedirectURL:  "https://tabulae.newsai.org/api/auth/providers/linkedin/callback",
+		ClientID:     os.Getenv("LINKEDINAUTHKEY"),
+		ClientSecret: os.Getenv("LINKEDINAUTHSECRET"),
+		Scopes: []string{
+			"openid",
+			"profile",
+			"email",
+		},
+		Endpoint: linkedin.Endpoint,
+	}
+)
+
+// Users link LinkedIn to their account. They can't log in with it.
+type linkedinProvider struct{}
+
+func (p linkedinProvider) Name() string {
+	return apiModels.LinkedAccountLinkedin
+}
+
+func (p linkedinProvider) OauthConfig(link bool) *oauth2.Config {
+	return linkedinOauthConfig
+}
+
+func (p linkedinProvider) GetProfile(c context.Context, token *oauth2.Token) (ProviderProfile, error) {
+	client := urlfetch.Client(c)
+
+	req, _ := http.NewRequest("GET", "https://api.linkedin.com/v2/userinfo", nil)
+	req.Header.Add("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ProviderProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return ProviderProfile{}, errors.New("Could not get the LinkedIn profile")
+	}
+
+	// Decode JSON from LinkedIn
+	decoder := json.NewDecoder(resp.Body)
+	var linkedinUser LinkedinResponse
+	err = decoder.Decode(&linkedinUser)
+	if err != nil {
+		return ProviderProfile{}, err
+	}
+
+	return ProviderProfile{
+		Id:        linkedinUser.Sub,
+		Email:     linkedinUser.Email,
+		FirstName: linkedinUser.GivenName,
+		LastName:  linkedinUser.FamilyName,
+	}, nil
+}
+
+func (p linkedinProvider) Link(user *apiModels.User, profile ProviderProfile) error {
+	user.LinkedinId = profile.Id
+	return nil
+}
+
+func (p linkedinProvider) Unlink(user *apiModels.User) {
+	user.LinkedinId = ""
+	user.LinkedinAuthKey = ""
+}
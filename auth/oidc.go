@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/appengine/urlfetch"
+
+	apiModels "github.com/news-ai/api/models"
+	"github.com/news-ai/api/utils"
+)
+
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcKey struct {
+	KeyId     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type oidcKeys struct {
+	Keys []oidcKey `json:"keys"`
+}
+
+type oidcClaims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      interface{} `json:"aud"`
+	Expires       int64       `json:"exp"`
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	GivenName     string      `json:"given_name"`
+	FamilyName    string      `json:"family_name"`
+}
+
+/*
+* Private methods
+ */
+
+func getOIDCJSON(c context.Context, url string, v interface{}) error {
+	client := urlfetch.Client(c)
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New("Could not reach the identity provider")
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func getOIDCConfiguration(c context.Context, issuer string) (oidcConfiguration, error) {
+	var configuration oidcConfiguration
+	err := getOIDCJSON(c, issuer+"/.well-known/openid-configuration", &configuration)
+	if err != nil {
+		return oidcConfiguration{}, err
+	}
+
+	if strings.TrimRight(configuration.Issuer, "/") != issuer {
+		return oidcConfiguration{}, errors.New("The issuer of the identity provider does not match")
+	}
+
+	return configuration, nil
+}
+
+func oidcOauthConfig(agency apiModels.Agency, configuration oidcConfiguration) *oauth2.Config {
+	return &oauth2.Config{
+		RedirectURL:  utils.APIURL + "/auth/sso/oidc/callback",
+		ClientID:     agency.OIDCClientId,
+		ClientSecret: agency.OIDCClientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  configuration.AuthorizationEndpoint,
+			TokenURL: configuration.TokenEndpoint,
+		},
+	}
+}
+
+func oidcPublicKey(key oidcKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func oidcAudienceContains(audience interface{}, clientId string) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == clientId
+	case []interface{}:
+		for i := 0; i < len(audience); i++ {
+			if value, ok := audience[i].(string); ok && value == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks the signature and claims of an id token from an identity provider
+func verifyOIDCIdToken(c context.Context, configuration oidcConfiguration, clientId string, idToken string, nonce string) (oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyId     string `json:"kid"`
+	}
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil || header.Algorithm != "RS256" {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	var keys oidcKeys
+	err = getOIDCJSON(c, configuration.JWKSURI, &keys)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	var publicKey *rsa.PublicKey
+	for i := 0; i < len(keys.Keys); i++ {
+		if keys.Keys[i].KeyType == "RSA" && (header.KeyId == "" || keys.Keys[i].KeyId == header.KeyId) {
+			publicKey, err = oidcPublicKey(keys.Keys[i])
+			if err != nil {
+				return oidcClaims{}, err
+			}
+			break
+		}
+	}
+
+	if publicKey == nil {
+		return oidcClaims{}, errors.New("No key to verify the id token with")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return oidcClaims{}, errors.New("Invalid id token signature")
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	var claims oidcClaims
+	err = json.Unmarshal(claimsBytes, &claims)
+	if err != nil {
+		return oidcClaims{}, errors.New("Invalid id token")
+	}
+
+	if claims.Issuer != configuration.Issuer {
+		return oidcClaims{}, errors.New("The id token is from a different issuer")
+	}
+
+	if !oidcAudienceContains(claims.Audience, clientId) {
+		return oidcClaims{}, errors.New("The id token is for a different client")
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return oidcClaims{}, errors.New("The id token has expired")
+	}
+
+	if claims.Nonce != nonce {
+		return oidcClaims{}, errors.New("Invalid id token nonce")
+	}
+
+	// Some providers send this as a string
+	if verified, ok := claims.EmailVerified.(bool); ok && !verified {
+		return oidcClaims{}, errors.New("The email of the user has not been verified")
+	}
+	if verified, ok := claims.EmailVerified.(string); ok && verified != "true" {
+		return oidcClaims{}, errors.New("The email of the user has not been verified")
+	}
+
+	return claims, nil
+}
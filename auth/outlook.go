@@ -2,21 +2,18 @@ package auth
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"golang.org/x/net/context"
+
 	"google.golang.org/appengine/urlfetch"
 
 	"github.com/julienschmidt/httprouter"
 
-	apiControllers "github.com/news-ai/api/controllers"
+	apiModels "github.com/news-ai/api/models"
 
 	"github.com/news-ai/oauth2/outlook"
-	"github.com/news-ai/web/utilities"
 
 	"golang.org/x/oauth2"
 )
@@ -47,151 +44,67 @@ var (
 	}
 )
 
-// Handler to redirect user to the Outlook OAuth2 page
-func OutlookLoginHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	c := appengine.NewContext(r)
-
-	// Make sure the user has been logged in when at outlook auth
-	user, err := apiControllers.GetCurrentUser(c, r)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		fmt.Fprintln(w, "user not logged in")
-		return
-	}
-
-	// Generate a random state that we identify the user with
-	state := utilities.RandToken()
-
-	// Save the session for each of the users
-	session, err := Store.Get(r, "sess")
-	if err != nil {
-		log.Errorf(c, "%v", err)
-	}
-
-	session.Values["state"] = state
-	session.Values["outlook"] = "yes"
-	session.Values["outlook_email"] = user.Email
-
-	if r.URL.Query().Get("next") != "" {
-		session.Values["next"] = r.URL.Query().Get("next")
-	}
-
-	err = session.Save(r, w)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-	}
+// Users link Outlook to send emails from it. They can't log in with it.
+type outlookProvider struct{}
 
-	// Redirect the user to the login page
-	url := outlookOauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
-	http.Redirect(w, r, url, 302)
+func (p outlookProvider) Name() string {
+	return apiModels.LinkedAccountOutlook
 }
 
-// Handler to redirect user to the Google OAuth2 page
-func RemoveOutlookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	c := appengine.NewContext(r)
-
-	// Make sure the user has been logged in when at oulook auth
-	user, err := apiControllers.GetCurrentUser(c, r)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		fmt.Fprintln(w, "user not logged in")
-		return
-	}
-
-	user.Outlook = false
-	apiControllers.SaveUser(c, r, &user)
-
-	if r.URL.Query().Get("next") != "" {
-		returnURL := r.URL.Query().Get("next")
-		if err != nil {
-			http.Redirect(w, r, returnURL, 302)
-			return
-		}
-	}
-
-	http.Redirect(w, r, "https://tabulae.newsai.co/settings", 302)
-	return
+func (p outlookProvider) OauthConfig(link bool) *oauth2.Config {
+	return outlookOauthConfig
 }
 
-func OutlookCallbackHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	c := appengine.NewContext(r)
-	session, err := Store.Get(r, "sess")
-	if err != nil {
-		log.Infof(c, "%v", err)
-		fmt.Fprintln(w, "aborted")
-		return
-	}
-
-	if r.URL.Query().Get("state") != session.Values["state"] {
-		log.Errorf(c, "%v", "no state match; possible csrf OR cookies not enabled")
-		fmt.Fprintln(w, "no state match; possible csrf OR cookies not enabled")
-		return
-	}
-
-	tkn, err := outlookOauthConfig.Exchange(c, r.URL.Query().Get("code"))
-	if err != nil {
-		log.Errorf(c, "%v", "there was an issue getting your token")
-		fmt.Fprintln(w, "there was an issue getting your token")
-		return
-	}
-
-	if !tkn.Valid() {
-		log.Errorf(c, "%v", "retreived invalid token")
-		fmt.Fprintln(w, "retreived invalid token")
-		return
-	}
-
-	// Make sure the user has been logged in when at outlook auth
-	user, err := apiControllers.GetCurrentUser(c, r)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		fmt.Fprintln(w, "user not logged in")
-		return
-	}
-
+func (p outlookProvider) GetProfile(c context.Context, token *oauth2.Token) (ProviderProfile, error) {
 	client := urlfetch.Client(c)
 
 	req, _ := http.NewRequest("GET", "https://outlook.office.com/api/v2.0/me", nil)
-	req.Header.Add("Authorization", "Bearer "+tkn.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+token.AccessToken)
 	req.Header.Add("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Errorf(c, "%v", "there was an issue getting your token "+err.Error())
-		fmt.Fprintln(w, "there was an issue getting your token")
-		return
+		return ProviderProfile{}, err
 	}
 	defer resp.Body.Close()
 
-	// Decode JSON from Google
+	// Decode JSON from Outlook
 	decoder := json.NewDecoder(resp.Body)
 	var outlookUser OutlookResponse
 	err = decoder.Decode(&outlookUser)
 	if err != nil {
-		log.Errorf(c, "%v", err)
-		fmt.Fprintln(w, err.Error())
-		return
+		return ProviderProfile{}, err
 	}
 
-	user.OutlookEmail = outlookUser.EmailAddress
-	user.OutlookAccessToken = tkn.AccessToken
-	user.OutlookExpiresIn = tkn.Expiry
-	user.OutlookRefreshToken = tkn.RefreshToken
-	user.OutlookTokenType = tkn.TokenType
+	return ProviderProfile{
+		Id:        outlookUser.ID,
+		Email:     outlookUser.EmailAddress,
+		FirstName: outlookUser.DisplayName,
+	}, nil
+}
 
+func (p outlookProvider) Link(user *apiModels.User, profile ProviderProfile) error {
+	user.OutlookEmail = profile.Email
 	user.Outlook = true
 	user.Gmail = false
 	user.ExternalEmail = false
+	return nil
+}
 
-	apiControllers.SaveUser(c, r, &user)
+func (p outlookProvider) Unlink(user *apiModels.User) {
+	user.Outlook = false
+}
 
-	returnURL := session.Values["next"].(string)
-	u, err := url.Parse(returnURL)
-	if err != nil {
-		http.Redirect(w, r, returnURL, 302)
-		return
-	}
+// Handler to redirect user to the Outlook OAuth2 page
+func OutlookLoginHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	startProviderLogin(w, r, outlookProvider{}, true)
+}
+
+// Handler to remove Outlook from a user
+func RemoveOutlookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	unlinkProvider(w, r, outlookProvider{})
+}
 
-	http.Redirect(w, r, u.String(), 302)
-	return
+func OutlookCallbackHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	providerCallback(w, r, outlookProvider{})
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/mail"
 	"net/url"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -14,8 +15,10 @@ import (
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/urlfetch"
 
+	"github.com/news-ai/api/audit"
 	apiControllers "github.com/news-ai/api/controllers"
 	apiModels "github.com/news-ai/api/models"
+	"github.com/news-ai/api/webhooks"
 
 	"golang.org/x/net/context"
 
@@ -103,26 +106,24 @@ func PasswordLoginHandler() http.HandlerFunc {
 
 		log.Infof(c, "%v", validEmail.Address)
 
-		if password == "" {
-			session.Values["email"] = validEmail.Address
-			session.Save(r, w)
-
-			returnURL := "https://tabulae.newsai.co/"
-			if session.Values["next"] != nil {
-				returnURL = session.Values["next"].(string)
-			}
-			_, err := url.Parse(returnURL)
+		// Agencies that enforce single sign-on don't allow passwords
+		if apiControllers.IsSSOEnforcedForEmail(c, validEmail.Address) {
+			http.Redirect(w, r, "/api/auth/sso?email="+url.QueryEscape(validEmail.Address), 302)
+			return
+		}
 
-			// If there's an error in parsing the return value
-			// then returning it.
-			if err != nil {
-				log.Errorf(c, "%v", err)
-				http.Redirect(w, r, returnURL, 302)
-				return
-			}
+		lockedUntil, isLocked := apiControllers.GetLoginLockout(c, r, apiModels.LoginAttemptPassword, validEmail.Address)
+		if isLocked {
+			apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptPassword, validEmail.Address, false, true)
+			lockedOutMessage := url.QueryEscape(loginLockoutMessage(lockedUntil))
+			http.Redirect(w, r, "/api/auth?success=false&message="+lockedOutMessage, 302)
+			return
 		}
 
 		user, isOk, _ := apiControllers.ValidateUserPassword(r, validEmail.Address, password)
+		if !isOk || user.GoogleId != "" {
+			apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptPassword, validEmail.Address, false, false)
+		}
 		if user.GoogleId != "" {
 			notPassword := url.QueryEscape("You signed up with Google Authentication!")
 			http.Redirect(w, r, "/api/auth?success=false&message="+notPassword, 302)
@@ -136,39 +137,19 @@ func PasswordLoginHandler() http.HandlerFunc {
 				return
 			}
 
-			session.Values["email"] = validEmail.Address
-			session.Save(r, w)
-
-			if user.IsActive {
-				returnURL := "https://tabulae.newsai.co/"
-				if session.Values["next"] != nil {
-					returnURL = session.Values["next"].(string)
-				}
-				u, err := url.Parse(returnURL)
-
-				// If there's an error in parsing the return value
-				// then returning it.
-				if err != nil {
-					log.Errorf(c, "%v", err)
-					http.Redirect(w, r, returnURL, 302)
-					return
-				}
-
-				// This would be a bug since they should not be here if they
-				// are a firstTimeUser. But we'll allow it to help make
-				// experience normal.
-				if user.LastLoggedIn.IsZero() {
-					q := u.Query()
-					q.Set("firstTimeUser", "true")
-					u.RawQuery = q.Encode()
-					user.ConfirmLoggedIn(c)
-				}
-				http.Redirect(w, r, u.String(), 302)
-				return
-			} else {
-				http.Redirect(w, r, "/api/billing/plans/trial", 302)
+			// Users with two-factor authentication have to enter a code
+			// before they are logged in
+			if user.TwoFactorEnabled {
+				session.Values["twofactor_id"] = user.Id
+				session.Values["twofactor_expires"] = time.Now().Add(twoFactorChallengeDuration).Unix()
+				session.Save(r, w)
+				http.Redirect(w, r, "/api/auth/two-factor", 302)
 				return
 			}
+
+			apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptPassword, validEmail.Address, true, false)
+			completeLogin(c, w, r, user)
+			return
 		}
 
 		wrongPasswordMessage := url.QueryEscape("You entered the wrong password!")
@@ -177,6 +158,60 @@ func PasswordLoginHandler() http.HandlerFunc {
 	}
 }
 
+func loginLockoutMessage(lockedUntil time.Time) string {
+	minutes := int(lockedUntil.Sub(time.Now()).Minutes()) + 1
+	if minutes == 1 {
+		return "Too many failed attempts. Please try again in 1 minute."
+	}
+	return "Too many failed attempts. Please try again in " + strconv.Itoa(minutes) + " minutes."
+}
+
+// Saves the user in the session and sends them to where they were going
+func completeLogin(c context.Context, w http.ResponseWriter, r *http.Request, user apiModels.User) {
+	if user.IsLoginDisabled() {
+		disabledMessage := url.QueryEscape("Your account has been disabled.")
+		http.Redirect(w, r, "/api/auth?success=false&message="+disabledMessage, 302)
+		return
+	}
+
+	session, _ := Store.Get(r, "sess")
+	session.Values["email"] = user.Email
+
+	// A new session is tracked for every login
+	delete(session.Values, "sessionid")
+	session.Save(r, w)
+
+	if user.IsActive {
+		returnURL := "https://tabulae.newsai.co/"
+		if session.Values["next"] != nil {
+			returnURL = session.Values["next"].(string)
+		}
+		u, err := url.Parse(returnURL)
+
+		// If there's an error in parsing the return value
+		// then returning it.
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			http.Redirect(w, r, returnURL, 302)
+			return
+		}
+
+		// This would be a bug since they should not be here if they
+		// are a firstTimeUser. But we'll allow it to help make
+		// experience normal.
+		if user.LastLoggedIn.IsZero() {
+			q := u.Query()
+			q.Set("firstTimeUser", "true")
+			u.RawQuery = q.Encode()
+			user.ConfirmLoggedIn(c)
+		}
+		http.Redirect(w, r, u.String(), 302)
+		return
+	}
+
+	http.Redirect(w, r, "/api/billing/plans/trial", 302)
+}
+
 func ChangePasswordHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		c := appengine.NewContext(r)
@@ -191,11 +226,7 @@ func ChangePasswordHandler() http.HandlerFunc {
 		_, err = currentUser.Save(c)
 
 		// Remove session
-		session, _ := Store.Get(r, "sess")
-		delete(session.Values, "state")
-		delete(session.Values, "id")
-		delete(session.Values, "email")
-		session.Save(r, w)
+		clearSession(w, r)
 
 		// If saving the user had an error
 		if err != nil {
@@ -205,6 +236,10 @@ func ChangePasswordHandler() http.HandlerFunc {
 			return
 		}
 
+		// Log the user out everywhere else too
+		apiControllers.RevokeSessionsForUser(c, currentUser.Id)
+		audit.RecordAs(r, currentUser, apiModels.AuditActionPasswordChange, currentUser, nil, nil)
+
 		// If password is changed
 		validChange := "Your password has been changed! Please login with your new password."
 		http.Redirect(w, r, "/api/auth?success=true&message="+validChange, 302)
@@ -227,7 +262,22 @@ func ForgetPasswordHandler() http.HandlerFunc {
 			return
 		}
 
+		if apiControllers.IsSSOEnforcedForEmail(c, email) {
+			ssoMessage := url.QueryEscape("Your agency requires you to log in with single sign-on!")
+			http.Redirect(w, r, "/api/auth/sso?success=false&message="+ssoMessage, 302)
+			return
+		}
+
+		lockedUntil, isLocked := apiControllers.GetLoginLockout(c, r, apiModels.LoginAttemptForgetPassword, email)
+		if isLocked {
+			apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptForgetPassword, email, false, true)
+			lockedOutMessage := url.QueryEscape(loginLockoutMessage(lockedUntil))
+			http.Redirect(w, r, "/api/auth?success=false&message="+lockedOutMessage, 302)
+			return
+		}
+
 		user, err := apiControllers.GetUserByEmail(c, email)
+		apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptForgetPassword, email, err == nil, false)
 		if err != nil {
 			noUserErr := url.QueryEscape("There is no user with this email!")
 			http.Redirect(w, r, "/api/auth?success=false&message="+noUserErr, 302)
@@ -420,19 +470,18 @@ func PasswordRegisterHandler() http.HandlerFunc {
 		}
 
 		invitedBy := int64(0)
+		userInviteCode := apiModels.UserInviteCode{}
 
 		// At some point we can make the invitationCode required
 		if invitationCode != "" {
 			log.Infof(c, "%v", invitationCode)
-			userInviteCode, err := apiControllers.GetInviteFromInvitationCode(c, r, invitationCode)
+			userInviteCode, err = apiControllers.GetInviteForEmail(c, r, invitationCode, validEmail.Address)
 			if err != nil {
 				invalidEmailAlert := url.QueryEscape("Your user invitation code is incorrect!")
 				http.Redirect(w, r, "/api/auth?success=false&message="+invalidEmailAlert, 302)
 				return
 			}
 			invitedBy = userInviteCode.CreatedBy
-			userInviteCode.IsUsed = true
-			userInviteCode.Save(c)
 		}
 
 		// Hash the password and save it into the datastore
@@ -450,7 +499,7 @@ func PasswordRegisterHandler() http.HandlerFunc {
 		user.PromoCode = promoCode
 
 		// Register user
-		_, isOk, err := controllers.RegisterUser(r, user)
+		registeredUser, isOk, err := controllers.RegisterUser(r, user)
 
 		if !isOk && err != nil {
 			// Redirect user back to login page
@@ -458,6 +507,20 @@ func PasswordRegisterHandler() http.HandlerFunc {
 			http.Redirect(w, r, "/api/auth?success=false&message="+emailRegistered, 302)
 			return
 		}
+		webhooks.Trigger(r, apiModels.WebhookEventUserRegistered, registeredUser, map[string]interface{}{
+			"method": "password",
+		})
+
+		// If the user was invited to join a team
+		if userInviteCode.Id != 0 {
+			err = apiControllers.UseInvite(c, &userInviteCode)
+			if err == nil && userInviteCode.TeamId != 0 {
+				err = apiControllers.AddUserToTeamFromInvite(c, r, &registeredUser, userInviteCode)
+			}
+			if err != nil {
+				log.Errorf(c, "%v", err)
+			}
+		}
 
 		// Email could fail to send if there is no singleUser. Create check later.
 		confirmErr := emails.ConfirmUserAccount(c, user, user.ConfirmationCode)
@@ -715,6 +778,10 @@ func ResetPasswordHandler() http.HandlerFunc {
 			return
 		}
 
+		// Anyone that was logged in as the user is logged out
+		apiControllers.RevokeSessionsForUser(c, user.Id)
+		audit.RecordAs(r, user, apiModels.AuditActionPasswordReset, user, nil, nil)
+
 		validReset := "Your password has been changed!"
 		http.Redirect(w, r, "/api/auth?success=true&message="+validReset, 302)
 		return
@@ -815,6 +882,7 @@ func EmailConfirmationHandler() http.HandlerFunc {
 				http.Redirect(w, r, "/api/auth?success=false&message="+invalidConfirmation, 302)
 				return
 			}
+			webhooks.Trigger(r, apiModels.WebhookEventUserEmailConfirmed, user, nil)
 
 			err = emails.AddUserToTabulaeTrialList(c, user)
 			if err != nil {
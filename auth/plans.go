@@ -13,6 +13,7 @@ import (
 	apiControllers "github.com/news-ai/api/controllers"
 
 	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
 
 	"github.com/gorilla/csrf"
 	"github.com/pquerna/ffjson/ffjson"
@@ -275,7 +276,7 @@ func ChoosePlanPageHandler() http.HandlerFunc {
 
 		// If the user has a billing profile
 		if err == nil {
-			userBilling.StripePlanId = billing.BillingIdToPlanName(userBilling.StripePlanId)
+			userBilling.StripePlanId = billing.GetPlan(c, userBilling.StripePlanId).Name
 
 			userNotActiveNonTrialPlan := true
 			if user.IsActive && !userBilling.IsOnTrial {
@@ -334,23 +335,15 @@ func ChooseSwitchPlanHandler() http.HandlerFunc {
 		// If the user has a billing profile
 		if err == nil {
 			originalPlan := plan
-			switch plan {
-			case "personal":
-				plan = "Personal"
-			case "consultant":
-				plan = "Consultant"
-			case "business":
-				plan = "Business"
-			case "growing":
-				plan = "Growing Business"
-			}
+			selectedPlan := billing.GetPlan(c, plan)
+			plan = selectedPlan.Name
 
 			missingCard := true
 			if len(userBilling.CardsOnFile) > 0 {
 				missingCard = false
 			}
 
-			price := billing.PlanAndDurationToPrice(plan, duration)
+			price := billing.PlanAndDurationToPrice(selectedPlan, duration)
 			cost, _ := billing.SwitchUserPlanPreview(r, user, &userBilling, duration, originalPlan)
 
 			data := map[string]interface{}{
@@ -407,23 +400,15 @@ func ChoosePlanHandler() http.HandlerFunc {
 
 		// If the user has a billing profile
 		if err == nil {
-			switch plan {
-			case "personal":
-				plan = "Personal"
-			case "consultant":
-				plan = "Consultant"
-			case "business":
-				plan = "Business"
-			case "growing":
-				plan = "Growing Business"
-			}
+			selectedPlan := billing.GetPlan(c, plan)
+			plan = selectedPlan.Name
 
 			missingCard := true
 			if len(userBilling.CardsOnFile) > 0 {
 				missingCard = false
 			}
 
-			price := billing.PlanAndDurationToPrice(plan, duration)
+			price := billing.PlanAndDurationToPrice(selectedPlan, duration)
 
 			data := map[string]interface{}{
 				"missingCard": missingCard,
@@ -452,6 +437,7 @@ func CheckCouponValid() http.HandlerFunc {
 		c := appengine.NewContext(r)
 		coupon := r.FormValue("coupon")
 		duration := r.FormValue("duration")
+		plan := r.FormValue("plan")
 
 		if coupon == "" {
 			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", "Please enter a coupon")
@@ -460,23 +446,15 @@ func CheckCouponValid() http.HandlerFunc {
 
 		coupon = strings.ToUpper(coupon)
 
-		if coupon == "FAVORITES" && duration == "annually" {
-			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", "Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-			return
-		}
-
-		if coupon == "PRCOUTURE" && duration == "annually" {
-			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", "Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-			return
-		}
-
-		if coupon == "CURIOUS" && duration == "annually" {
-			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", "Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-			return
+		userBilling := models.Billing{}
+		user, err := apiControllers.GetCurrentUser(c, r)
+		if err == nil {
+			userBilling, _ = apiControllers.GetUserBilling(c, r, user)
 		}
 
-		if coupon == "PRCONSULTANTS" && duration == "annually" {
-			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", "Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
+		err = billing.CheckCouponRules(c, coupon, plan, duration, userBilling)
+		if err != nil {
+			nError.ReturnError(w, http.StatusInternalServerError, "Coupon error", err.Error())
 			return
 		}
 
@@ -535,15 +513,9 @@ func ConfirmPlanHandler() http.HandlerFunc {
 		// If the user has a billing profile
 		if err == nil {
 			originalPlan := plan
-			switch plan {
-			case "Personal":
-				plan = "personal"
-			case "Consultant":
-				plan = "consultant"
-			case "Business":
-				plan = "business"
-			case "Growing Business":
-				plan = "growing"
+			selectedPlan, err := billing.FindPlan(c, plan)
+			if err == nil {
+				plan = selectedPlan.PlanId
 			}
 
 			err = billing.AddPlanToUser(r, user, &userBilling, plan, duration, coupon, originalPlan)
@@ -603,16 +575,7 @@ func BillingPageHandler() http.HandlerFunc {
 
 		// If the user has a billing profile
 		if err == nil {
-			switch userBilling.StripePlanId {
-			case "bronze", "personal":
-				userBilling.StripePlanId = "Personal"
-			case "aluminum", "consultant":
-				userBilling.StripePlanId = "Consultant"
-			case "silver-1", "silver", "business":
-				userBilling.StripePlanId = "Business"
-			case "gold-1", "gold", "growing":
-				userBilling.StripePlanId = "Growing Business"
-			}
+			userBilling.StripePlanId = billing.GetPlan(c, userBilling.StripePlanId).Name
 
 			customerBalance, _ := billing.GetCustomerBalance(r, user, &userBilling)
 			userPlanExpires := userBilling.Expires.AddDate(0, 0, -1).Format("2006-01-02")
@@ -0,0 +1,498 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/julienschmidt/httprouter"
+
+	apiControllers "github.com/news-ai/api/controllers"
+	apiModels "github.com/news-ai/api/models"
+	"github.com/news-ai/api/webhooks"
+
+	"github.com/news-ai/tabulae/controllers"
+	"github.com/news-ai/tabulae/emails"
+
+	"github.com/news-ai/web/utilities"
+)
+
+// The account of a user at an identity provider
+type ProviderProfile struct {
+	Id        string
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// An identity provider that users can link to their account. Adding a
+// provider only takes implementing this and registering it.
+type IdentityProvider interface {
+	// Name of the provider. Used for linked accounts and in the paths of
+	// its handlers.
+	Name() string
+
+	// Linking an account can ask for more scopes than logging in
+	OauthConfig(link bool) *oauth2.Config
+
+	GetProfile(c context.Context, token *oauth2.Token) (ProviderProfile, error)
+
+	// Updates the settings of a user when an account is linked to them.
+	// Returns an error if the account can't be linked to the user. Tokens
+	// are stored on the linked account.
+	Link(user *apiModels.User, profile ProviderProfile) error
+	Unlink(user *apiModels.User)
+}
+
+// Providers that users can also sign up and log in with
+type LoginIdentityProvider interface {
+	IdentityProvider
+
+	NewUser(profile ProviderProfile) apiModels.User
+}
+
+var identityProviders = map[string]IdentityProvider{}
+
+func RegisterIdentityProvider(provider IdentityProvider) {
+	identityProviders[provider.Name()] = provider
+}
+
+func init() {
+	RegisterIdentityProvider(googleProvider{})
+	RegisterIdentityProvider(outlookProvider{})
+	RegisterIdentityProvider(linkedinProvider{})
+}
+
+/*
+* Private methods
+ */
+
+func clearProviderSession(w http.ResponseWriter, r *http.Request) {
+	session, _ := Store.Get(r, "sess")
+	delete(session.Values, "provider")
+	delete(session.Values, "provider_link_email")
+	session.Save(r, w)
+}
+
+func linkedAccountFromToken(provider IdentityProvider, profile ProviderProfile, token *oauth2.Token, link bool) apiModels.LinkedAccount {
+	linkedAccount := apiModels.LinkedAccount{}
+	linkedAccount.Provider = provider.Name()
+	linkedAccount.ProviderUserId = profile.Id
+	linkedAccount.Email = profile.Email
+	linkedAccount.Scopes = provider.OauthConfig(link).Scopes
+	linkedAccount.AccessToken = token.AccessToken
+	linkedAccount.RefreshToken = token.RefreshToken
+	linkedAccount.TokenType = token.TokenType
+	linkedAccount.Expires = token.Expiry
+	return linkedAccount
+}
+
+// Sending still reads the tokens from the user, so they are copied there
+// until it reads linked accounts
+func setUserTokens(user *apiModels.User, linkedAccount apiModels.LinkedAccount) {
+	switch linkedAccount.Provider {
+	case googleProvider{}.Name():
+		user.AccessToken = linkedAccount.AccessToken
+		user.TokenType = linkedAccount.TokenType
+		user.GoogleExpiresIn = linkedAccount.Expires
+		if linkedAccount.RefreshToken != "" {
+			user.RefreshToken = linkedAccount.RefreshToken
+		}
+	case outlookProvider{}.Name():
+		user.OutlookAccessToken = linkedAccount.AccessToken
+		user.OutlookTokenType = linkedAccount.TokenType
+		user.OutlookExpiresIn = linkedAccount.Expires
+		if linkedAccount.RefreshToken != "" {
+			user.OutlookRefreshToken = linkedAccount.RefreshToken
+		}
+	case linkedinProvider{}.Name():
+		user.LinkedinAuthKey = linkedAccount.AccessToken
+	}
+}
+
+// Sends the user to the provider. Users that are linking an account have
+// to be logged in already.
+func startProviderLogin(w http.ResponseWriter, r *http.Request, provider IdentityProvider, link bool) {
+	c := appengine.NewContext(r)
+
+	linkEmail := ""
+	if link {
+		// Make sure the user has been logged in when linking an account
+		user, err := apiControllers.GetCurrentUser(c, r)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			fmt.Fprintln(w, "user not logged in")
+			return
+		}
+		linkEmail = user.Email
+	} else if _, ok := provider.(LoginIdentityProvider); !ok {
+		fmt.Fprintln(w, "you can not login with "+provider.Name())
+		return
+	}
+
+	// Generate a random state that we identify the user with
+	state := utilities.RandToken()
+
+	// Save the session for each of the users
+	session, err := Store.Get(r, "sess")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	session.Values["state"] = state
+	session.Values["provider"] = provider.Name()
+	session.Values["provider_link_email"] = linkEmail
+
+	if r.URL.Query().Get("next") != "" {
+		session.Values["next"] = r.URL.Query().Get("next")
+	}
+
+	// If the user is signing up through an invitation
+	if !link && r.URL.Query().Get("invitationcode") != "" {
+		session.Values["invitation_code"] = r.URL.Query().Get("invitationcode")
+	}
+
+	err = session.Save(r, w)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	// Redirect the user to the login page. Linked accounts need a refresh
+	// token so we can keep using them.
+	oauthConfig := provider.OauthConfig(link)
+	if link {
+		http.Redirect(w, r, oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline), 302)
+		return
+	}
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(state), 302)
+}
+
+func unlinkProvider(w http.ResponseWriter, r *http.Request, provider IdentityProvider) {
+	c := appengine.NewContext(r)
+
+	// Make sure the user has been logged in when unlinking an account
+	user, err := apiControllers.GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		fmt.Fprintln(w, "user not logged in")
+		return
+	}
+
+	provider.Unlink(&user)
+	apiControllers.SaveUser(c, r, &user)
+
+	err = apiControllers.RemoveLinkedAccountForUser(c, user.Id, provider.Name())
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	http.Redirect(w, r, "https://tabulae.newsai.co/settings", 302)
+}
+
+// Handles the user coming back from the provider for both logging in and
+// linking an account
+func providerCallback(w http.ResponseWriter, r *http.Request, provider IdentityProvider) {
+	c := appengine.NewContext(r)
+	session, err := Store.Get(r, "sess")
+	if err != nil {
+		log.Infof(c, "%v", err)
+		fmt.Fprintln(w, "aborted")
+		return
+	}
+
+	if r.URL.Query().Get("state") != session.Values["state"] || session.Values["provider"] != provider.Name() {
+		log.Errorf(c, "%v", "no state match; possible csrf OR cookies not enabled")
+		fmt.Fprintln(w, "no state match; possible csrf OR cookies not enabled")
+		return
+	}
+
+	linkEmail, _ := session.Values["provider_link_email"].(string)
+	link := linkEmail != ""
+	clearProviderSession(w, r)
+
+	tkn, err := provider.OauthConfig(link).Exchange(c, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Errorf(c, "%v", "there was an issue getting your token")
+		fmt.Fprintln(w, "there was an issue getting your token")
+		return
+	}
+
+	if !tkn.Valid() {
+		log.Errorf(c, "%v", "retreived invalid token")
+		fmt.Fprintln(w, "retreived invalid token")
+		return
+	}
+
+	profile, err := provider.GetProfile(c, tkn)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+
+	if link {
+		linkProviderAccount(c, w, r, provider, linkEmail, profile, tkn)
+		return
+	}
+
+	loginProvider, ok := provider.(LoginIdentityProvider)
+	if !ok {
+		fmt.Fprintln(w, "you can not login with "+provider.Name())
+		return
+	}
+
+	loginWithProvider(c, w, r, loginProvider, profile, tkn)
+}
+
+func linkProviderAccount(c context.Context, w http.ResponseWriter, r *http.Request, provider IdentityProvider, linkEmail string, profile ProviderProfile, tkn *oauth2.Token) {
+	session, _ := Store.Get(r, "sess")
+	returnURL := "https://tabulae.newsai.co/settings"
+	if session.Values["next"] != nil {
+		returnURL = session.Values["next"].(string)
+	}
+
+	user, err := apiControllers.GetCurrentUser(c, r)
+	if err != nil || user.Email != linkEmail {
+		log.Errorf(c, "%v", "Tried to link "+provider.Name()+" for user "+linkEmail+" from another session")
+		http.Redirect(w, r, "https://tabulae.newsai.co/settings", 302)
+		return
+	}
+
+	err = provider.Link(&user, profile)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		http.Redirect(w, r, "https://tabulae.newsai.co/settings", 302)
+		return
+	}
+
+	linkedAccount := linkedAccountFromToken(provider, profile, tkn, true)
+	setUserTokens(&user, linkedAccount)
+	apiControllers.SaveUser(c, r, &user)
+
+	_, err = apiControllers.SaveLinkedAccountForUser(c, r, user, linkedAccount)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		http.Redirect(w, r, returnURL, 302)
+		return
+	}
+
+	http.Redirect(w, r, u.String(), 302)
+}
+
+func loginWithProvider(c context.Context, w http.ResponseWriter, r *http.Request, provider LoginIdentityProvider, profile ProviderProfile, tkn *oauth2.Token) {
+	session, _ := Store.Get(r, "sess")
+
+	// Agencies that enforce single sign-on don't allow other logins
+	if apiControllers.IsSSOEnforcedForEmail(c, profile.Email) {
+		http.Redirect(w, r, "/api/auth/sso?email="+url.QueryEscape(profile.Email), 302)
+		return
+	}
+
+	_, err := apiControllers.GetUserByEmail(c, profile.Email)
+	isNewUser := err != nil
+
+	newUser := provider.NewUser(profile)
+	user, _, _ := controllers.RegisterUser(r, newUser)
+	if isNewUser && user.Id != 0 {
+		webhooks.Trigger(r, apiModels.WebhookEventUserRegistered, user, map[string]interface{}{
+			"method": provider.Name(),
+		})
+	}
+
+	if user.IsLoginDisabled() {
+		disabledMessage := url.QueryEscape("Your account has been disabled.")
+		http.Redirect(w, r, "/api/auth?success=false&message="+disabledMessage, 302)
+		return
+	}
+
+	// The first login links the account. Logging in asks for fewer scopes
+	// than linking so an account that is already linked is kept.
+	_, err = apiControllers.GetLinkedAccountForUser(c, user.Id, provider.Name())
+	if err != nil {
+		linkedAccount := linkedAccountFromToken(provider, profile, tkn, false)
+		setUserTokens(&user, linkedAccount)
+		apiControllers.SaveUser(c, r, &user)
+
+		_, err = apiControllers.SaveLinkedAccountForUser(c, r, user, linkedAccount)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+
+	// If the user signed up through an invitation then we use it up and
+	// add them to the team they were invited to
+	if session.Values["invitation_code"] != nil {
+		invitationCode := session.Values["invitation_code"].(string)
+		delete(session.Values, "invitation_code")
+
+		// Invitations are only for the email they were sent to
+		userInviteCode, err := apiControllers.GetInviteForEmail(c, r, invitationCode, profile.Email)
+		if err == nil {
+			err = apiControllers.UseInvite(c, &userInviteCode)
+		}
+		if err == nil {
+			if user.InvitedBy == 0 {
+				user.InvitedBy = userInviteCode.CreatedBy
+				user.Save(c)
+			}
+
+			err = apiControllers.AddUserToTeamFromInvite(c, r, &user, userInviteCode)
+			if err != nil {
+				log.Errorf(c, "%v", err)
+			}
+		} else {
+			log.Errorf(c, "%v", "Invalid invitation code "+invitationCode)
+		}
+	}
+
+	session.Values["email"] = profile.Email
+	session.Values["id"] = user.Id
+	session.Save(r, w)
+
+	if user.IsActive {
+		if session.Values["next"] != nil {
+			returnURL := session.Values["next"].(string)
+			u, err := url.Parse(returnURL)
+			if err != nil {
+				http.Redirect(w, r, returnURL, 302)
+				return
+			}
+
+			if user.LastLoggedIn.IsZero() {
+				q := u.Query()
+				q.Set("firstTimeUser", "true")
+				u.RawQuery = q.Encode()
+
+				err = emails.AddUserToTabulaeTrialList(c, user)
+				if err != nil {
+					// Redirect user back to login page
+					log.Errorf(c, "%v", "Welcome email was not sent for "+user.Email)
+					log.Errorf(c, "%v", err)
+				}
+
+				user.ConfirmLoggedIn(c)
+			}
+			http.Redirect(w, r, u.String(), 302)
+			return
+		}
+	} else {
+		if user.LastLoggedIn.IsZero() {
+			err = emails.AddUserToTabulaeTrialList(c, user)
+			if err != nil {
+				// Redirect user back to login page
+				log.Errorf(c, "%v", "Welcome email was not sent for "+user.Email)
+				log.Errorf(c, "%v", err)
+			}
+		}
+		http.Redirect(w, r, "/api/billing/plans/trial", 302)
+		return
+	}
+
+	http.Redirect(w, r, "/", 302)
+}
+
+// Tokens that were stored on a user before linked accounts
+func legacyLinkedAccounts(user apiModels.User) []apiModels.LinkedAccount {
+	linkedAccounts := []apiModels.LinkedAccount{}
+
+	if user.AccessToken != "" || user.RefreshToken != "" {
+		linkedAccount := apiModels.LinkedAccount{}
+		linkedAccount.Provider = googleProvider{}.Name()
+		linkedAccount.ProviderUserId = user.GoogleId
+		linkedAccount.Email = user.Email
+		linkedAccount.Scopes = googleProvider{}.OauthConfig(user.RefreshToken != "").Scopes
+		linkedAccount.AccessToken = user.AccessToken
+		linkedAccount.RefreshToken = user.RefreshToken
+		linkedAccount.TokenType = user.TokenType
+		linkedAccount.Expires = user.GoogleExpiresIn
+		linkedAccounts = append(linkedAccounts, linkedAccount)
+	}
+
+	if user.OutlookAccessToken != "" || user.OutlookRefreshToken != "" {
+		linkedAccount := apiModels.LinkedAccount{}
+		linkedAccount.Provider = outlookProvider{}.Name()
+		linkedAccount.Email = user.OutlookEmail
+		linkedAccount.Scopes = outlookProvider{}.OauthConfig(true).Scopes
+		linkedAccount.AccessToken = user.OutlookAccessToken
+		linkedAccount.RefreshToken = user.OutlookRefreshToken
+		linkedAccount.TokenType = user.OutlookTokenType
+		linkedAccount.Expires = user.OutlookExpiresIn
+		linkedAccounts = append(linkedAccounts, linkedAccount)
+	}
+
+	if user.LinkedinAuthKey != "" {
+		linkedAccount := apiModels.LinkedAccount{}
+		linkedAccount.Provider = linkedinProvider{}.Name()
+		linkedAccount.ProviderUserId = user.LinkedinId
+		linkedAccount.Scopes = linkedinProvider{}.OauthConfig(true).Scopes
+		linkedAccount.AccessToken = user.LinkedinAuthKey
+		linkedAccounts = append(linkedAccounts, linkedAccount)
+	}
+
+	return linkedAccounts
+}
+
+/*
+* Public methods
+ */
+
+// Copies the tokens that are stored on a user onto their linked accounts.
+// Linked accounts that already have a refresh token are newer and are
+// kept. The tokens stay on the user since sending still reads them from
+// there, so the user never changes.
+func BackfillLinkedAccounts(c context.Context, r *http.Request, user *apiModels.User) (bool, error) {
+	linkedAccounts := legacyLinkedAccounts(*user)
+	if len(linkedAccounts) == 0 {
+		return false, nil
+	}
+
+	for i := 0; i < len(linkedAccounts); i++ {
+		linkedAccount, err := apiControllers.GetLinkedAccountForUser(c, user.Id, linkedAccounts[i].Provider)
+		if err == nil && (linkedAccount.RefreshToken != "" || linkedAccounts[i].RefreshToken == "") {
+			continue
+		}
+		if err == nil && linkedAccounts[i].ProviderUserId == "" {
+			linkedAccounts[i].ProviderUserId = linkedAccount.ProviderUserId
+		}
+
+		_, err = apiControllers.SaveLinkedAccountForUser(c, r, *user, linkedAccounts[i])
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// Handles logging in with, linking and unlinking any identity provider:
+// /api/auth/providers/:provider/:action
+func IdentityProviderHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	provider, ok := identityProviders[ps.ByName("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch ps.ByName("action") {
+	case "login":
+		startProviderLogin(w, r, provider, false)
+	case "link":
+		startProviderLogin(w, r, provider, true)
+	case "unlink":
+		unlinkProvider(w, r, provider)
+	case "callback":
+		providerCallback(w, r, provider)
+	default:
+		http.NotFound(w, r)
+	}
+}
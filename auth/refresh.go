@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	apiControllers "github.com/news-ai/api/controllers"
+	apiModels "github.com/news-ai/api/models"
+)
+
+// Tokens that expire within this are refreshed. It has to be longer than
+// the time between runs of /tasks/refreshEmailTokens.
+const emailTokenRefreshWindow = 90 * time.Minute
+
+// An email provider that a user can send from. Its tokens are on the
+// linked account of the user for the provider, and copied onto the user.
+type emailProvider struct {
+	Name     string
+	Provider IdentityProvider
+	Enabled  *bool
+}
+
+/*
+* Private methods
+ */
+
+func getEmailProviders(user *apiModels.User) []emailProvider {
+	return []emailProvider{
+		{
+			Name:     "Gmail",
+			Provider: googleProvider{},
+			Enabled:  &user.Gmail,
+		},
+		{
+			Name:     "Outlook",
+			Provider: outlookProvider{},
+			Enabled:  &user.Outlook,
+		},
+	}
+}
+
+// Google and Microsoft both reject refresh tokens that have been revoked
+// or have expired with invalid_grant. Other errors are worth retrying.
+func isRefreshTokenRejected(err error) bool {
+	return strings.Contains(err.Error(), "invalid_grant")
+}
+
+func refreshOauthToken(c context.Context, config *oauth2.Config, refreshToken string) (*oauth2.Token, error) {
+	// A token without an access token is always refreshed
+	expiredToken := &oauth2.Token{
+		RefreshToken: refreshToken,
+	}
+	return config.TokenSource(c, expiredToken).Token()
+}
+
+/*
+* Public methods
+ */
+
+// Refreshes the Gmail and Outlook tokens of a user that are about to
+// expire. Returns if the user was changed, and the names of the providers
+// that rejected the refresh token for good. Those are turned off and the
+// user has to connect them again.
+func RefreshEmailTokens(c context.Context, r *http.Request, user *apiModels.User) (bool, []string) {
+	updated := false
+	disconnected := []string{}
+
+	// Users whose tokens haven't been copied to linked accounts yet would
+	// look disconnected
+	_, err := BackfillLinkedAccounts(c, r, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return false, disconnected
+	}
+
+	providers := getEmailProviders(user)
+	for i := 0; i < len(providers); i++ {
+		provider := providers[i]
+		if !*provider.Enabled {
+			continue
+		}
+
+		linkedAccount, err := apiControllers.GetLinkedAccountForUser(c, user.Id, provider.Provider.Name())
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			// Tried again on the next run
+			log.Errorf(c, "%v", err)
+			continue
+		}
+		if err == nil && linkedAccount.Expires.After(time.Now().Add(emailTokenRefreshWindow)) {
+			continue
+		}
+
+		// Without a refresh token the user has to give us access again
+		if err == datastore.ErrNoSuchEntity || linkedAccount.RefreshToken == "" {
+			*provider.Enabled = false
+			updated = true
+			disconnected = append(disconnected, provider.Name)
+			continue
+		}
+
+		token, err := refreshOauthToken(c, provider.Provider.OauthConfig(true), linkedAccount.RefreshToken)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			if isRefreshTokenRejected(err) {
+				*provider.Enabled = false
+				updated = true
+				disconnected = append(disconnected, provider.Name)
+			}
+			continue
+		}
+
+		linkedAccount.AccessToken = token.AccessToken
+		linkedAccount.TokenType = token.TokenType
+		linkedAccount.Expires = token.Expiry
+
+		// Providers can rotate the refresh token when they refresh
+		if token.RefreshToken != "" {
+			linkedAccount.RefreshToken = token.RefreshToken
+		}
+
+		_, err = linkedAccount.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			continue
+		}
+
+		setUserTokens(user, linkedAccount)
+		updated = true
+	}
+
+	return updated, disconnected
+}
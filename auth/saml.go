@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/urlfetch"
+
+	"github.com/crewjam/saml"
+
+	apiModels "github.com/news-ai/api/models"
+	"github.com/news-ai/api/utils"
+)
+
+// Attributes identity providers commonly send the email of a user in
+var samlEmailAttributes = []string{
+	"email",
+	"mail",
+	"emailaddress",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+}
+
+/*
+* Private methods
+ */
+
+func getSAMLMetadata(c context.Context, agency apiModels.Agency) (*saml.EntityDescriptor, error) {
+	metadata := []byte(agency.SAMLMetadata)
+	if len(metadata) == 0 {
+		client := urlfetch.Client(c)
+		resp, err := client.Get(agency.SAMLMetadataURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		metadata, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entityDescriptor := &saml.EntityDescriptor{}
+	err := xml.Unmarshal(metadata, entityDescriptor)
+	if err != nil {
+		return nil, err
+	}
+	return entityDescriptor, nil
+}
+
+// The service provider for an agency. Our certificate and key are the
+// same for every agency.
+func samlServiceProvider(c context.Context, agency apiModels.Agency) (*saml.ServiceProvider, error) {
+	keyPair, err := tls.X509KeyPair([]byte(os.Getenv("SAMLCERT")), []byte(os.Getenv("SAMLKEY")))
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The SAML key has to be a RSA key")
+	}
+
+	idpMetadata, err := getSAMLMetadata(c, agency)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, err := url.Parse(utils.APIURL + "/auth/sso/saml/metadata?agency=" + strconv.FormatInt(agency.Id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	acsURL, err := url.Parse(utils.APIURL + "/auth/sso/saml/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		IDPMetadata: idpMetadata,
+	}, nil
+}
+
+func samlAssertionEmail(assertion *saml.Assertion) string {
+	for i := 0; i < len(assertion.AttributeStatements); i++ {
+		attributes := assertion.AttributeStatements[i].Attributes
+		for x := 0; x < len(attributes); x++ {
+			for y := 0; y < len(samlEmailAttributes); y++ {
+				isEmail := attributes[x].Name == samlEmailAttributes[y] || attributes[x].FriendlyName == samlEmailAttributes[y]
+				if isEmail && len(attributes[x].Values) > 0 {
+					return attributes[x].Values[0].Value
+				}
+			}
+		}
+	}
+
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		return assertion.Subject.NameID.Value
+	}
+
+	return ""
+}
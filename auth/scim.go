@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"net/http"
+
+	"google.golang.org/appengine"
+
+	gcontext "github.com/gorilla/context"
+
+	"github.com/news-ai/api/controllers"
+)
+
+// Directories provision users with the SCIM token of their agency instead
+// of logging in as a user
+func SCIMAuthLogin(w http.ResponseWriter, r *http.Request, token string) bool {
+	c := appengine.NewContext(r)
+	agency, err := controllers.GetAgencyFromSCIMToken(c, token)
+	if err != nil {
+		return false
+	}
+
+	gcontext.Set(r, "scimagency", agency)
+	return true
+}
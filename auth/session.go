@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+
+	apiControllers "github.com/news-ai/api/controllers"
+)
+
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	session, _ := Store.Get(r, "sess")
+	delete(session.Values, "state")
+	delete(session.Values, "id")
+	delete(session.Values, "email")
+	delete(session.Values, "sessionid")
+	session.Save(r, w)
+}
+
+// Keeps track of the session of the current user so it can be listed and
+// revoked. Returns false if the session has been revoked.
+func TrackSession(w http.ResponseWriter, r *http.Request) bool {
+	c := appengine.NewContext(r)
+	user, err := apiControllers.GetCurrentUser(c, r)
+	if err != nil || user.Id == 0 {
+		return true
+	}
+
+	session, _ := Store.Get(r, "sess")
+	if session.Values["sessionid"] != nil {
+		_, err := apiControllers.TouchUserSession(c, r, session.Values["sessionid"].(int64), user)
+		if err == apiControllers.ErrSessionRevoked {
+			clearSession(w, r)
+			gcontext.Delete(r, "user")
+			return false
+		}
+
+		if err == nil {
+			return true
+		}
+		log.Errorf(c, "%v", err)
+	}
+
+	// Sessions from before sessions were tracked, or ones that are missing
+	userSession, err := apiControllers.CreateUserSession(c, r, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return true
+	}
+
+	session.Values["sessionid"] = userSession.Id
+	session.Save(r, w)
+	gcontext.Set(r, "sessionid", userSession.Id)
+	return true
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/julienschmidt/httprouter"
+
+	apiControllers "github.com/news-ai/api/controllers"
+	apiModels "github.com/news-ai/api/models"
+	"github.com/news-ai/api/webhooks"
+
+	"github.com/news-ai/tabulae/controllers"
+
+	"github.com/news-ai/web/utilities"
+)
+
+var errNoSSOLogin = errors.New("No single sign-on login was started")
+
+func redirectSSOError(w http.ResponseWriter, r *http.Request, message string) {
+	ssoError := url.QueryEscape(message)
+	http.Redirect(w, r, "/api/auth/sso?success=false&message="+ssoError, 302)
+}
+
+// Gets the agency a single sign-on login was started for
+func getSSOAgency(c context.Context, r *http.Request) (apiModels.Agency, error) {
+	session, _ := Store.Get(r, "sess")
+	if session.Values["sso_agency"] == nil {
+		return apiModels.Agency{}, errNoSSOLogin
+	}
+	return apiControllers.GetAgencySSOById(c, session.Values["sso_agency"].(int64))
+}
+
+// Logs in a user that the identity provider of an agency has vouched for.
+// New users are created and added to the agency. Existing users have to
+// have opted into the single sign-on of the agency.
+func completeSSOLogin(c context.Context, w http.ResponseWriter, r *http.Request, agency apiModels.Agency, email string, firstName string, lastName string) {
+	session, _ := Store.Get(r, "sess")
+	delete(session.Values, "sso_agency")
+	delete(session.Values, "sso_nonce")
+	delete(session.Values, "sso_request_id")
+	session.Save(r, w)
+
+	email = strings.ToLower(email)
+	emailDomain, err := utilities.ExtractEmailExtension(email)
+	if err != nil || !agency.HasEmailDomain(emailDomain) || !agency.IsDomainVerified(emailDomain) {
+		log.Errorf(c, "%v", "Single sign-on for "+agency.Email+" returned the email "+email)
+		redirectSSOError(w, r, "Your identity provider returned an email that is not part of your agency!")
+		return
+	}
+
+	user, err := apiControllers.GetUserByEmail(c, email)
+	isNewUser := err != nil
+	if isNewUser {
+		newUser := apiModels.User{}
+		newUser.Email = email
+		newUser.FirstName = firstName
+		newUser.LastName = lastName
+		newUser.EmailConfirmed = true
+		newUser.IsActive = false
+
+		user, _, err = controllers.RegisterUser(r, newUser)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			redirectSSOError(w, r, "We could not create your user!")
+			return
+		}
+		webhooks.Trigger(r, apiModels.WebhookEventUserRegistered, user, map[string]interface{}{
+			"method": "sso",
+		})
+	}
+
+	if user.IsLoginDisabled() {
+		redirectSSOError(w, r, "Your account has been disabled.")
+		return
+	}
+
+	if !isNewUser && !apiControllers.CanLogInWithSSO(agency, user) {
+		redirectSSOError(w, r, "Please log in with your password and turn on single sign-on for your agency first!")
+		return
+	}
+
+	// Existing users of an agency that enforces single sign-on are moved
+	// onto it the first time they log in through it
+	err = apiControllers.ProvisionSSOUser(c, r, agency, &user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	completeLogin(c, w, r, user)
+}
+
+// Starts logging in through the identity provider of the agency that the
+// email domain of the user belongs to
+func SSOLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		email := strings.ToLower(r.URL.Query().Get("email"))
+
+		if email == "" {
+			t := template.New("sso.html")
+			t, err := t.ParseFiles("auth/sso.html")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			t.Execute(w, nil)
+			return
+		}
+
+		agency, err := apiControllers.GetAgencySSOForEmail(c, email)
+		if err != nil {
+			redirectSSOError(w, r, "Single sign-on is not set up for your email domain!")
+			return
+		}
+
+		state := utilities.RandToken()
+		session, _ := Store.Get(r, "sess")
+		session.Values["state"] = state
+		session.Values["sso_agency"] = agency.Id
+		if r.URL.Query().Get("next") != "" {
+			session.Values["next"] = r.URL.Query().Get("next")
+		}
+
+		switch agency.SSOType {
+		case apiModels.AgencySSOOIDC:
+			configuration, err := getOIDCConfiguration(c, agency.OIDCIssuer)
+			if err != nil {
+				log.Errorf(c, "%v", err)
+				redirectSSOError(w, r, "We could not reach your identity provider!")
+				return
+			}
+
+			nonce := utilities.RandToken()
+			session.Values["sso_nonce"] = nonce
+			session.Save(r, w)
+
+			oauthConfig := oidcOauthConfig(agency, configuration)
+			http.Redirect(w, r, oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce), oauth2.SetAuthURLParam("login_hint", email)), 302)
+			return
+		case apiModels.AgencySSOSAML:
+			serviceProvider, err := samlServiceProvider(c, agency)
+			if err != nil {
+				log.Errorf(c, "%v", err)
+				redirectSSOError(w, r, "We could not reach your identity provider!")
+				return
+			}
+
+			authnRequest, err := serviceProvider.MakeAuthenticationRequest(serviceProvider.GetSSOBindingLocation("urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"))
+			if err != nil {
+				log.Errorf(c, "%v", err)
+				redirectSSOError(w, r, "We could not reach your identity provider!")
+				return
+			}
+
+			session.Values["sso_request_id"] = authnRequest.ID
+			session.Save(r, w)
+
+			http.Redirect(w, r, authnRequest.Redirect(state).String(), 302)
+			return
+		}
+
+		redirectSSOError(w, r, "Single sign-on is not set up for your email domain!")
+	}
+}
+
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
+	session, _ := Store.Get(r, "sess")
+
+	if r.URL.Query().Get("state") == "" || r.URL.Query().Get("state") != session.Values["state"] {
+		log.Errorf(c, "%v", "no state match; possible csrf OR cookies not enabled")
+		redirectSSOError(w, r, "Please try to login again.")
+		return
+	}
+
+	agency, err := getSSOAgency(c, r)
+	if err != nil || agency.SSOType != apiModels.AgencySSOOIDC || session.Values["sso_nonce"] == nil {
+		redirectSSOError(w, r, "Please try to login again.")
+		return
+	}
+
+	configuration, err := getOIDCConfiguration(c, agency.OIDCIssuer)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		redirectSSOError(w, r, "We could not reach your identity provider!")
+		return
+	}
+
+	oauthConfig := oidcOauthConfig(agency, configuration)
+	tkn, err := oauthConfig.Exchange(c, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		redirectSSOError(w, r, "There was an issue logging you in with your identity provider!")
+		return
+	}
+
+	idToken, ok := tkn.Extra("id_token").(string)
+	if !ok {
+		redirectSSOError(w, r, "Your identity provider did not log you in!")
+		return
+	}
+
+	claims, err := verifyOIDCIdToken(c, configuration, agency.OIDCClientId, idToken, session.Values["sso_nonce"].(string))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		redirectSSOError(w, r, "Your identity provider did not log you in!")
+		return
+	}
+
+	completeSSOLogin(c, w, r, agency, claims.Email, claims.GivenName, claims.FamilyName)
+}
+
+// Identity providers post the SAML response here
+func SAMLACSHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
+	session, _ := Store.Get(r, "sess")
+
+	if r.FormValue("RelayState") == "" || r.FormValue("RelayState") != session.Values["state"] {
+		log.Errorf(c, "%v", "no state match; possible csrf OR cookies not enabled")
+		redirectSSOError(w, r, "Please try to login again.")
+		return
+	}
+
+	agency, err := getSSOAgency(c, r)
+	if err != nil || agency.SSOType != apiModels.AgencySSOSAML || session.Values["sso_request_id"] == nil {
+		redirectSSOError(w, r, "Please try to login again.")
+		return
+	}
+
+	serviceProvider, err := samlServiceProvider(c, agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		redirectSSOError(w, r, "We could not reach your identity provider!")
+		return
+	}
+
+	assertion, err := serviceProvider.ParseResponse(r, []string{session.Values["sso_request_id"].(string)})
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		redirectSSOError(w, r, "Your identity provider did not log you in!")
+		return
+	}
+
+	completeSSOLogin(c, w, r, agency, samlAssertionEmail(assertion), "", "")
+}
+
+// The metadata of our service provider for the identity provider of an agency
+func SAMLMetadataHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
+	agencyId, err := strconv.ParseInt(r.URL.Query().Get("agency"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid agency", http.StatusBadRequest)
+		return
+	}
+
+	agency, err := apiControllers.GetAgencySSOById(c, agencyId)
+	if err != nil || agency.SSOType != apiModels.AgencySSOSAML {
+		http.Error(w, "Single sign-on is not set up for this agency", http.StatusNotFound)
+		return
+	}
+
+	serviceProvider, err := samlServiceProvider(c, agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metadata, err := xml.MarshalIndent(serviceProvider.Metadata(), "", "  ")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+	"github.com/pquerna/ffjson/ffjson"
+
+	apiControllers "github.com/news-ai/api/controllers"
+	"github.com/news-ai/api/models"
+
+	nError "github.com/news-ai/web/errors"
+)
+
+var accessTokenDuration = 15 * time.Minute
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+type TokenClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Email    string `json:"email"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+/*
+* Private methods
+ */
+
+func tokenSigningKey() ([]byte, error) {
+	key := os.Getenv("TOKENKEY")
+	if key == "" {
+		return nil, errors.New("No token signing key set")
+	}
+	return []byte(key), nil
+}
+
+func signToken(unsigned string) (string, error) {
+	key, err := tokenSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func encodeTokenPart(part interface{}) (string, error) {
+	b, err := json.Marshal(part)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Creates a signed JWT (HS256) access token for a user
+func createAccessToken(user models.User) (string, error) {
+	now := time.Now()
+	header, err := encodeTokenPart(tokenHeader{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := encodeTokenPart(TokenClaims{
+		Issuer:   "newsai",
+		Subject:  strconv.FormatInt(user.Id, 10),
+		Email:    user.Email,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(accessTokenDuration).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + claims
+	signature, err := signToken(unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + signature, nil
+}
+
+func parseAccessToken(token string) (TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	var header tokenHeader
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil || header.Algorithm != "HS256" {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	signature, err := signToken(parts[0] + "." + parts[1])
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	var claims TokenClaims
+	err = json.Unmarshal(claimsBytes, &claims)
+	if err != nil {
+		return TokenClaims{}, errors.New("Invalid token")
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return TokenClaims{}, errors.New("Token has expired")
+	}
+
+	return claims, nil
+}
+
+func issueTokens(w http.ResponseWriter, r *http.Request, user models.User, refreshToken string) error {
+	accessToken, err := createAccessToken(user)
+	if err != nil {
+		return err
+	}
+
+	val := TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenDuration.Seconds()),
+	}
+	return ffjson.NewEncoder(w).Encode(val)
+}
+
+/*
+* Public methods
+ */
+
+// Logs a user in for a single request with a bearer access token
+func BearerAuthLogin(w http.ResponseWriter, r *http.Request, token string) bool {
+	c := appengine.NewContext(r)
+	claims, err := parseAccessToken(token)
+	if err != nil {
+		log.Infof(c, "%v", err)
+		return false
+	}
+
+	userId, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	user, err := apiControllers.GetUserByIdUnauthorized(c, r, userId)
+	if err != nil || user.Id == 0 || user.IsLoginDisabled() {
+		return false
+	}
+
+	gcontext.Set(r, "user", user)
+	return true
+}
+
+// Gets the bearer token from the Authorization header if there is one
+func GetBearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+// Issues access and refresh tokens. Supports the password and
+// refresh_token grant types.
+func TokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		c := appengine.NewContext(r)
+
+		switch r.FormValue("grant_type") {
+		case "password":
+			email := strings.ToLower(r.FormValue("email"))
+			if apiControllers.IsSSOEnforcedForEmail(c, email) {
+				nError.ReturnError(w, http.StatusForbidden, "Token error", "Your agency requires single sign-on")
+				return
+			}
+
+			lockedUntil, isLocked := apiControllers.GetLoginLockout(c, r, models.LoginAttemptPassword, email)
+			if isLocked {
+				apiControllers.RecordLoginAttempt(c, r, models.LoginAttemptPassword, email, false, true)
+				nError.ReturnError(w, http.StatusTooManyRequests, "Token error", loginLockoutMessage(lockedUntil))
+				return
+			}
+
+			user, isOk, _ := apiControllers.ValidateUserPassword(r, email, r.FormValue("password"))
+			if !isOk {
+				apiControllers.RecordLoginAttempt(c, r, models.LoginAttemptPassword, email, false, false)
+				nError.ReturnError(w, http.StatusUnauthorized, "Token error", "Your email or password is incorrect")
+				return
+			}
+
+			if !user.EmailConfirmed {
+				nError.ReturnError(w, http.StatusUnauthorized, "Token error", "You have not confirmed your email yet! Please check your email.")
+				return
+			}
+
+			if user.IsLoginDisabled() {
+				nError.ReturnError(w, http.StatusForbidden, "Token error", "Forbidden")
+				return
+			}
+
+			if user.TwoFactorEnabled {
+				isValid := apiControllers.ValidateTwoFactorCode(c, &user, r.FormValue("code"))
+				apiControllers.RecordLoginAttempt(c, r, models.LoginAttemptTwoFactor, email, isValid, false)
+				if !isValid {
+					nError.ReturnError(w, http.StatusUnauthorized, "Token error", "Please provide a valid two-factor code")
+					return
+				}
+			} else {
+				apiControllers.RecordLoginAttempt(c, r, models.LoginAttemptPassword, email, true, false)
+			}
+
+			refreshToken, _, err := apiControllers.CreateRefreshTokenForUser(c, r, user)
+			if err == nil {
+				err = issueTokens(w, r, user, refreshToken)
+			}
+
+			if err != nil {
+				log.Errorf(c, "%v", err)
+				nError.ReturnError(w, http.StatusInternalServerError, "Token error", err.Error())
+			}
+		case "refresh_token":
+			user, refreshToken, err := apiControllers.ExchangeRefreshToken(c, r, r.FormValue("refresh_token"))
+			if err != nil {
+				nError.ReturnError(w, http.StatusUnauthorized, "Token error", err.Error())
+				return
+			}
+
+			err = issueTokens(w, r, user, refreshToken)
+			if err != nil {
+				log.Errorf(c, "%v", err)
+				nError.ReturnError(w, http.StatusInternalServerError, "Token error", err.Error())
+			}
+		default:
+			nError.ReturnError(w, http.StatusBadRequest, "Token error", "Unsupported grant_type")
+		}
+	}
+}
+
+// Revokes a refresh token. Access tokens are short lived so they are
+// left to expire.
+func RevokeTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		c := appengine.NewContext(r)
+
+		err := apiControllers.RevokeRefreshToken(c, r.FormValue("refresh_token"))
+		if err == nil {
+			val := struct {
+				Revoked bool `json:"revoked"`
+			}{
+				Revoked: true,
+			}
+			err = ffjson.NewEncoder(w).Encode(val)
+		}
+
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			nError.ReturnError(w, http.StatusBadRequest, "Token error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/gorilla/csrf"
+
+	apiControllers "github.com/news-ai/api/controllers"
+	apiModels "github.com/news-ai/api/models"
+)
+
+// How long a user has to enter their code after entering their password
+var twoFactorChallengeDuration = 5 * time.Minute
+
+func clearTwoFactorChallenge(w http.ResponseWriter, r *http.Request) {
+	session, _ := Store.Get(r, "sess")
+	delete(session.Values, "twofactor_id")
+	delete(session.Values, "twofactor_expires")
+	session.Save(r, w)
+}
+
+// Gets the id of the user that has entered their password but still has
+// to enter their two-factor code
+func getTwoFactorChallengeUserId(r *http.Request) int64 {
+	session, err := Store.Get(r, "sess")
+	if err != nil {
+		return 0
+	}
+
+	if session.Values["twofactor_id"] == nil || session.Values["twofactor_expires"] == nil {
+		return 0
+	}
+
+	if time.Now().Unix() > session.Values["twofactor_expires"].(int64) {
+		return 0
+	}
+
+	return session.Values["twofactor_id"].(int64)
+}
+
+func TwoFactorPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getTwoFactorChallengeUserId(r) == 0 {
+			expiredMessage := url.QueryEscape("Please login again.")
+			http.Redirect(w, r, "/api/auth?success=false&message="+expiredMessage, 302)
+			return
+		}
+
+		t := template.New("two-factor.html")
+		t, err := t.ParseFiles("auth/two-factor.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]interface{}{
+			csrf.TemplateTag: csrf.TemplateField(r),
+		}
+
+		t.Execute(w, data)
+		return
+	}
+}
+
+func TwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		code := r.FormValue("code")
+
+		userId := getTwoFactorChallengeUserId(r)
+		if userId == 0 {
+			expiredMessage := url.QueryEscape("Please login again.")
+			http.Redirect(w, r, "/api/auth?success=false&message="+expiredMessage, 302)
+			return
+		}
+
+		user, err := apiControllers.GetUserByIdUnauthorized(c, r, userId)
+		if err != nil || user.Id == 0 {
+			log.Errorf(c, "%v", err)
+			clearTwoFactorChallenge(w, r)
+			http.Redirect(w, r, "/api/auth", 302)
+			return
+		}
+
+		lockedUntil, isLocked := apiControllers.GetLoginLockout(c, r, apiModels.LoginAttemptTwoFactor, user.Email)
+		if isLocked {
+			apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptTwoFactor, user.Email, false, true)
+			clearTwoFactorChallenge(w, r)
+			lockedOutMessage := url.QueryEscape(loginLockoutMessage(lockedUntil))
+			http.Redirect(w, r, "/api/auth?success=false&message="+lockedOutMessage, 302)
+			return
+		}
+
+		isValid := apiControllers.ValidateTwoFactorCode(c, &user, code)
+		apiControllers.RecordLoginAttempt(c, r, apiModels.LoginAttemptTwoFactor, user.Email, isValid, false)
+		if !isValid {
+			wrongCodeMessage := url.QueryEscape("The code you entered is not valid!")
+			http.Redirect(w, r, "/api/auth/two-factor?success=false&message="+wrongCodeMessage, 302)
+			return
+		}
+
+		clearTwoFactorChallenge(w, r)
+		completeLogin(c, w, r, user)
+	}
+}
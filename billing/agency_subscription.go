@@ -0,0 +1,216 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/client"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/models"
+)
+
+/*
+* Private methods
+ */
+
+func stripeErrorMessage(err error, message string) error {
+	var stripeError StripeError
+	jsonErr := json.Unmarshal([]byte(err.Error()), &stripeError)
+	if jsonErr != nil || stripeError.Message == "" {
+		return errors.New(message)
+	}
+	return errors.New(stripeError.Message)
+}
+
+func agencyBillingAuditValues(agencyBilling models.Billing) map[string]interface{} {
+	values := billingAuditValues(agencyBilling)
+	values["seats"] = agencyBilling.Seats
+	values["minimumseats"] = agencyBilling.MinimumSeats
+	return values
+}
+
+/*
+* Public methods
+ */
+
+// Starts a subscription for an agency with one seat for each of its
+// members. The agency gets its own Stripe customer the first time.
+func AddPlanToAgency(r *http.Request, agency models.Agency, agencyBilling *models.Billing, plan string, duration string, stripeToken string, seats int) error {
+	c := appengine.NewContext(r)
+	httpClient := urlfetch.Client(c)
+	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
+
+	selectedPlan, err := FindPlan(c, plan)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return errors.New("This plan does not exist")
+	}
+
+	if selectedPlan.Retired && !selectedPlan.Matches(agencyBilling.StripePlanId) {
+		return errors.New("This plan is no longer available")
+	}
+
+	if seats < 1 {
+		seats = 1
+	}
+
+	if agencyBilling.StripeId == "" {
+		if stripeToken == "" {
+			return errors.New("Please add a card for the agency")
+		}
+
+		params := &stripe.CustomerParams{
+			Email: agency.Email,
+			Desc:  agency.Name,
+		}
+		params.SetSource(stripeToken)
+
+		customer, err := sc.Customers.New(params)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return stripeErrorMessage(err, "We had an error creating the customer of the agency")
+		}
+		agencyBilling.StripeId = customer.ID
+	} else if stripeToken != "" {
+		params := &stripe.CustomerParams{}
+		params.SetSource(stripeToken)
+
+		_, err := sc.Customers.Update(agencyBilling.StripeId, params)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return stripeErrorMessage(err, "We had an error adding the card of the agency")
+		}
+	}
+
+	customer, err := sc.Customers.Get(agencyBilling.StripeId, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return stripeErrorMessage(err, "We had an error getting the customer of the agency")
+	}
+
+	for i := 0; i < len(customer.Subs.Values); i++ {
+		sc.Subs.Cancel(customer.Subs.Values[i].ID, nil)
+	}
+
+	params := &stripe.SubParams{
+		Customer: customer.ID,
+		Plan:     selectedPlan.PlanId,
+		Quantity: uint64(seats),
+	}
+
+	if duration == "annually" {
+		params.Plan = selectedPlan.PlanId + "-yearly"
+	}
+
+	newSub, err := sc.Subs.New(params)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return stripeErrorMessage(err, "We had an error setting the subscription of the agency")
+	}
+
+	before := agencyBillingAuditValues(*agencyBilling)
+	agencyBilling.IsAgency = true
+	agencyBilling.AgencyId = agency.Id
+	agencyBilling.StripePlanId = selectedPlan.PlanId
+	SetPlanLimits(c, agencyBilling)
+	agencyBilling.Expires = time.Unix(newSub.PeriodEnd, 0)
+	agencyBilling.IsOnTrial = false
+	agencyBilling.IsCancel = false
+	agencyBilling.HasPaid = true
+	agencyBilling.Seats = seats
+	ClearDunning(agencyBilling)
+
+	after := agencyBillingAuditValues(*agencyBilling)
+	after["duration"] = duration
+	audit.Record(r, models.AuditActionPlanAdd, agency, before, after)
+	return nil
+}
+
+// The prorated cost in cents of changing the number of seats of an agency
+// right now
+func PreviewAgencySeats(r *http.Request, agencyBilling *models.Billing, seats int) (int64, error) {
+	c := appengine.NewContext(r)
+	httpClient := urlfetch.Client(c)
+	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
+
+	customer, err := sc.Customers.Get(agencyBilling.StripeId, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, stripeErrorMessage(err, "We had an error getting the customer of the agency")
+	}
+
+	if customer.Subs.Count == 0 {
+		return 0, errors.New("The agency does not have a subscription")
+	}
+
+	prorationDate := time.Now().Unix()
+	invoiceParams := &stripe.InvoiceParams{
+		Customer:         customer.ID,
+		Sub:              customer.Subs.Values[0].ID,
+		SubPlan:          customer.Subs.Values[0].Plan.ID,
+		SubQuantity:      uint64(seats),
+		SubProrationDate: prorationDate,
+	}
+	invoice, err := sc.Invoices.GetNext(invoiceParams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, err
+	}
+
+	var cost int64 = 0
+	for _, invoiceItem := range invoice.Lines.Values {
+		if invoiceItem.Period.Start == prorationDate {
+			cost += invoiceItem.Amount
+		}
+	}
+
+	return cost, nil
+}
+
+// Changes the number of seats of an agency. Stripe prorates the change
+// on the next invoice.
+func UpdateAgencySeats(r *http.Request, agency models.Agency, agencyBilling *models.Billing, seats int) error {
+	c := appengine.NewContext(r)
+	httpClient := urlfetch.Client(c)
+	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
+
+	if seats < 1 {
+		seats = 1
+	}
+
+	customer, err := sc.Customers.Get(agencyBilling.StripeId, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return stripeErrorMessage(err, "We had an error getting the customer of the agency")
+	}
+
+	if customer.Subs.Count == 0 {
+		return errors.New("The agency does not have a subscription")
+	}
+
+	params := &stripe.SubParams{
+		Customer: customer.ID,
+		Plan:     customer.Subs.Values[0].Plan.ID,
+		Quantity: uint64(seats),
+	}
+
+	_, err = sc.Subs.Update(customer.Subs.Values[0].ID, params)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return stripeErrorMessage(err, "We had an error changing the seats of the agency")
+	}
+
+	before := agencyBillingAuditValues(*agencyBilling)
+	agencyBilling.Seats = seats
+	audit.Record(r, models.AuditActionAgencySeatsChange, agency, before, agencyBillingAuditValues(*agencyBilling))
+	return nil
+}
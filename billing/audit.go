@@ -0,0 +1,16 @@
+package billing
+
+import (
+	"github.com/news-ai/api/models"
+)
+
+// The billing fields that are kept in the audit log
+func billingAuditValues(userBilling models.Billing) map[string]interface{} {
+	return map[string]interface{}{
+		"plan":      userBilling.StripePlanId,
+		"expires":   userBilling.Expires,
+		"isontrial": userBilling.IsOnTrial,
+		"iscancel":  userBilling.IsCancel,
+		"ispastdue": userBilling.IsPastDue,
+	}
+}
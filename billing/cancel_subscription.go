@@ -13,7 +13,9 @@ import (
 	"github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/client"
 
+	"github.com/news-ai/api/audit"
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/webhooks"
 )
 
 func CancelPlanOfUser(r *http.Request, user models.User, userBilling *models.Billing) error {
@@ -43,8 +45,14 @@ func CancelPlanOfUser(r *http.Request, user models.User, userBilling *models.Bil
 		sc.Subs.Cancel(customer.Subs.Values[i].ID, nil)
 	}
 
+	before := billingAuditValues(*userBilling)
 	userBilling.IsCancel = true
 	userBilling.Save(c)
+	audit.Record(r, models.AuditActionPlanCancel, user, before, billingAuditValues(*userBilling))
+	webhooks.Trigger(r, models.WebhookEventUserCanceled, user, map[string]interface{}{
+		"plan":    userBilling.StripePlanId,
+		"expires": userBilling.Expires,
+	})
 
 	// Send an email to the user saying that the package will be canceled. Their account will be inactive on
 	// their "Expires" date.
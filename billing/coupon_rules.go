@@ -0,0 +1,219 @@
+package billing
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+)
+
+var errMonthlyOnlyCoupon = errors.New("Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
+
+var errCouponUsedUp = errors.New("Sorry - this coupon has been used up")
+
+var errCouponWrongPlan = errors.New("Sorry - you can't use this coupon code on this plan")
+
+// Rules that are used until a rule for the same coupon is stored
+var defaultCouponRules = []models.CouponRule{
+	{Code: "FAVORITES", Active: true, Durations: []string{"monthly"}},
+	{Code: "PRCOUTURE", Active: true, Durations: []string{"monthly"}},
+	{Code: "CURIOUS", Active: true, Durations: []string{"monthly"}},
+	{Code: "PRCONSULTANTS", Active: true, Durations: []string{"monthly"}},
+}
+
+/*
+* Private methods
+ */
+
+func getStoredCouponRule(c context.Context, coupon string) (models.CouponRule, error) {
+	ks, err := datastore.NewQuery("CouponRule").Filter("Code =", coupon).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, err
+	}
+
+	if len(ks) == 0 {
+		return models.CouponRule{}, datastore.ErrNoSuchEntity
+	}
+
+	var couponRule models.CouponRule
+	err = nds.Get(c, ks[0], &couponRule)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, err
+	}
+
+	couponRule.Format(ks[0], "couponrules")
+	return couponRule, nil
+}
+
+// Changes the number of redemptions of a coupon in a transaction. Coupons
+// that only have a default rule are not counted.
+func updateCouponRedemptions(c context.Context, coupon string, change int) error {
+	couponRule, err := getStoredCouponRule(c, strings.ToUpper(coupon))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	couponRuleKey := datastore.NewKey(c, "CouponRule", "", couponRule.Id, nil)
+	return nds.RunInTransaction(c, func(tc context.Context) error {
+		var storedCouponRule models.CouponRule
+		err := nds.Get(tc, couponRuleKey, &storedCouponRule)
+		if err != nil {
+			return err
+		}
+
+		storedCouponRule.Format(couponRuleKey, "couponrules")
+		if change > 0 && storedCouponRule.MaxRedemptions > 0 && storedCouponRule.Redemptions+change > storedCouponRule.MaxRedemptions {
+			return errCouponUsedUp
+		}
+
+		storedCouponRule.Redemptions += change
+		if storedCouponRule.Redemptions < 0 {
+			storedCouponRule.Redemptions = 0
+		}
+
+		_, err = storedCouponRule.Save(tc)
+		return err
+	}, nil)
+}
+
+// Checks a coupon rule at a time. A plan that is nil hasn't been chosen
+// yet, and is checked when the user subscribes.
+func checkCouponRule(couponRule models.CouponRule, plan *models.Plan, duration string, userBilling models.Billing, now time.Time) error {
+	if !couponRule.Active || (!couponRule.StartsAt.IsZero() && couponRule.StartsAt.After(now)) {
+		return errors.New("Your coupon was invalid")
+	}
+
+	if !couponRule.ExpiresAt.IsZero() && couponRule.ExpiresAt.Before(now) {
+		return errors.New("Your coupon was invalid or has expired")
+	}
+
+	if couponRule.MaxRedemptions > 0 && couponRule.Redemptions >= couponRule.MaxRedemptions {
+		return errCouponUsedUp
+	}
+
+	if !couponRule.AllowsDuration(duration) {
+		if couponRule.AllowsDuration("monthly") {
+			return errMonthlyOnlyCoupon
+		}
+		return errors.New("Sorry - you can't use this coupon code on a monthly plan. Please switch to the yearly one to use this!")
+	}
+
+	if plan != nil && !couponRule.AllowsPlan(*plan) {
+		return errCouponWrongPlan
+	}
+
+	if couponRule.FirstTimeCustomersOnly && !userBilling.IsFirstTimeCustomer() {
+		return errors.New("Sorry - this coupon is only for new customers")
+	}
+
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+// Every coupon rule. Stored rules replace the default rule for the same
+// coupon.
+func GetCouponRules(c context.Context) ([]models.CouponRule, error) {
+	ks, err := datastore.NewQuery("CouponRule").KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.CouponRule{}, err
+	}
+
+	couponRules := make([]models.CouponRule, len(ks))
+	err = nds.GetMulti(c, ks, couponRules)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.CouponRule{}, err
+	}
+
+	for i := 0; i < len(couponRules); i++ {
+		couponRules[i].Format(ks[i], "couponrules")
+	}
+
+	for i := 0; i < len(defaultCouponRules); i++ {
+		isStored := false
+		for x := 0; x < len(couponRules); x++ {
+			if couponRules[x].Code == defaultCouponRules[i].Code {
+				isStored = true
+			}
+		}
+
+		if !isStored {
+			couponRules = append(couponRules, defaultCouponRules[i])
+		}
+	}
+
+	return couponRules, nil
+}
+
+// The rule of a coupon. Coupons that have no rule return false.
+func GetCouponRule(c context.Context, coupon string) (models.CouponRule, bool, error) {
+	coupon = strings.ToUpper(coupon)
+
+	couponRule, err := getStoredCouponRule(c, coupon)
+	if err == nil {
+		return couponRule, true, nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		return models.CouponRule{}, false, err
+	}
+
+	for i := 0; i < len(defaultCouponRules); i++ {
+		if defaultCouponRules[i].Code == coupon {
+			return defaultCouponRules[i], true, nil
+		}
+	}
+	return models.CouponRule{}, false, nil
+}
+
+// Checks if a coupon can be used for a plan. Checking a coupon before a
+// plan is chosen leaves plan out, and the plan is checked when the user
+// subscribes.
+func CheckCouponRules(c context.Context, coupon string, plan string, duration string, userBilling models.Billing) error {
+	couponRule, hasRule, err := GetCouponRule(c, coupon)
+	if err != nil {
+		return errors.New("We had an error checking your coupon")
+	}
+	if !hasRule {
+		return nil
+	}
+
+	var selectedPlan *models.Plan
+	if plan != "" {
+		foundPlan, err := FindPlan(c, plan)
+		if err != nil {
+			return errCouponWrongPlan
+		}
+		selectedPlan = &foundPlan
+	}
+
+	return checkCouponRule(couponRule, selectedPlan, duration, userBilling, time.Now())
+}
+
+// Counts a redemption of a coupon before a subscription is started with
+// it, so two users can't both take the last redemption. Fails when the
+// coupon is used up.
+func ReserveCouponRedemption(c context.Context, coupon string) error {
+	return updateCouponRedemptions(c, coupon, 1)
+}
+
+// Gives back a redemption when the subscription couldn't be started
+func ReleaseCouponRedemption(c context.Context, coupon string) error {
+	return updateCouponRedemptions(c, coupon, -1)
+}
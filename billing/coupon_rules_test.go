@@ -0,0 +1,145 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/news-ai/api/models"
+)
+
+func TestCheckCouponRule(t *testing.T) {
+	now := time.Date(2017, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	growth := models.Plan{PlanId: "growth", Name: "Growth", StripePlanIds: []string{"growth-legacy"}}
+	personal := models.Plan{PlanId: "personal", Name: "Personal"}
+
+	newCustomer := models.Billing{}
+	payingCustomer := models.Billing{HasPaid: true, StripePlanId: "growth"}
+
+	tests := []struct {
+		name     string
+		rule     models.CouponRule
+		plan     *models.Plan
+		duration string
+		billing  models.Billing
+		valid    bool
+		err      error
+	}{
+		{
+			name:     "rule without restrictions",
+			rule:     models.CouponRule{Active: true},
+			plan:     &personal,
+			duration: "annually",
+			billing:  payingCustomer,
+			valid:    true,
+		},
+		{
+			name:     "inactive rule",
+			rule:     models.CouponRule{Active: false},
+			duration: "monthly",
+		},
+		{
+			name:     "rule that hasn't started",
+			rule:     models.CouponRule{Active: true, StartsAt: now.Add(time.Hour)},
+			duration: "monthly",
+		},
+		{
+			name:     "rule that has started",
+			rule:     models.CouponRule{Active: true, StartsAt: now.Add(-time.Hour)},
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "expired rule",
+			rule:     models.CouponRule{Active: true, ExpiresAt: now.Add(-time.Hour)},
+			duration: "monthly",
+		},
+		{
+			name:     "rule that hasn't expired",
+			rule:     models.CouponRule{Active: true, ExpiresAt: now.Add(time.Hour)},
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "used up coupon",
+			rule:     models.CouponRule{Active: true, MaxRedemptions: 5, Redemptions: 5},
+			duration: "monthly",
+			err:      errCouponUsedUp,
+		},
+		{
+			name:     "coupon with redemptions left",
+			rule:     models.CouponRule{Active: true, MaxRedemptions: 5, Redemptions: 4},
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "monthly coupon on a yearly plan",
+			rule:     models.CouponRule{Active: true, Durations: []string{"monthly"}},
+			duration: "annually",
+			err:      errMonthlyOnlyCoupon,
+		},
+		{
+			name:     "yearly coupon on a monthly plan",
+			rule:     models.CouponRule{Active: true, Durations: []string{"annually"}},
+			duration: "monthly",
+		},
+		{
+			name:     "coupon on one of its plans",
+			rule:     models.CouponRule{Active: true, PlanIds: []string{"growth"}},
+			plan:     &growth,
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "coupon on a legacy id of one of its plans",
+			rule:     models.CouponRule{Active: true, PlanIds: []string{"Growth-Legacy"}},
+			plan:     &growth,
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "coupon on another plan",
+			rule:     models.CouponRule{Active: true, PlanIds: []string{"growth"}},
+			plan:     &personal,
+			duration: "monthly",
+			err:      errCouponWrongPlan,
+		},
+		{
+			name:     "coupon for some plans before a plan is chosen",
+			rule:     models.CouponRule{Active: true, PlanIds: []string{"growth"}},
+			duration: "monthly",
+			valid:    true,
+		},
+		{
+			name:     "coupon for new customers used by a new customer",
+			rule:     models.CouponRule{Active: true, FirstTimeCustomersOnly: true},
+			duration: "monthly",
+			billing:  newCustomer,
+			valid:    true,
+		},
+		{
+			name:     "coupon for new customers used by a paying customer",
+			rule:     models.CouponRule{Active: true, FirstTimeCustomersOnly: true},
+			duration: "monthly",
+			billing:  payingCustomer,
+		},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err := checkCouponRule(tests[i].rule, tests[i].plan, tests[i].duration, tests[i].billing, now)
+		if tests[i].valid {
+			if err != nil {
+				t.Errorf("%s: %v", tests[i].name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: should fail", tests[i].name)
+			continue
+		}
+		if tests[i].err != nil && err != tests[i].err {
+			t.Errorf("%s: got %q, want %q", tests[i].name, err, tests[i].err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package billing
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/notifications"
+)
+
+// How long users keep their account after a payment fails
+var DunningGracePeriod = 14 * 24 * time.Hour
+
+// How long after a subscription expires we wait to hear from Stripe that
+// the renewal was paid before we treat it as failed
+var RenewalGracePeriod = 3 * 24 * time.Hour
+
+// When reminders are sent, counted from when the payment first failed
+var dunningReminders = []time.Duration{
+	0,
+	3 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	12 * 24 * time.Hour,
+}
+
+/*
+* Public methods
+ */
+
+// Starts the grace period when a payment fails. Stripe retrying the
+// payment doesn't restart it.
+func StartDunning(userBilling *models.Billing, paymentRetries int) {
+	if !userBilling.IsPastDue {
+		userBilling.IsPastDue = true
+		userBilling.PastDueSince = time.Now()
+		userBilling.GracePeriodEnds = userBilling.PastDueSince.Add(DunningGracePeriod)
+		userBilling.PaymentRetries = 0
+		userBilling.DunningEmailsSent = 0
+		userBilling.LockedAt = time.Time{}
+	}
+
+	if paymentRetries > userBilling.PaymentRetries {
+		userBilling.PaymentRetries = paymentRetries
+	}
+}
+
+func ClearDunning(userBilling *models.Billing) {
+	userBilling.IsPastDue = false
+	userBilling.PastDueSince = time.Time{}
+	userBilling.GracePeriodEnds = time.Time{}
+	userBilling.PaymentRetries = 0
+	userBilling.DunningEmailsSent = 0
+	userBilling.LockedAt = time.Time{}
+}
+
+// Locks the account of a user when their grace period is over. Returns
+// true if the user or the billing was changed.
+func LockPastDueAccount(r *http.Request, user *models.User, userBilling *models.Billing) bool {
+	if !userBilling.IsGracePeriodOver() || !userBilling.LockedAt.IsZero() {
+		return false
+	}
+
+	c := appengine.NewContext(r)
+	before := billingAuditValues(*userBilling)
+
+	user.IsActive = false
+	userBilling.LockedAt = time.Now()
+
+	err := notifications.AccountLocked(c, *user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	audit.RecordAs(r, models.User{}, models.AuditActionAccountLock, *user, before, billingAuditValues(*userBilling))
+	return true
+}
+
+// Sends the next payment reminder if it is due, and locks the account
+// once the grace period is over. Returns true if the user or the billing
+// was changed.
+func ProcessDunning(r *http.Request, user *models.User, userBilling *models.Billing) bool {
+	if !userBilling.IsPastDue {
+		return false
+	}
+
+	if userBilling.IsGracePeriodOver() {
+		return LockPastDueAccount(r, user, userBilling)
+	}
+
+	if userBilling.DunningEmailsSent >= len(dunningReminders) {
+		return false
+	}
+
+	nextReminder := userBilling.PastDueSince.Add(dunningReminders[userBilling.DunningEmailsSent])
+	if nextReminder.After(time.Now()) {
+		return false
+	}
+
+	c := appengine.NewContext(r)
+	err := notifications.PaymentFailed(c, *user, userBilling.GracePeriodEnds)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return false
+	}
+
+	// Only one reminder is sent even if several were missed
+	for userBilling.DunningEmailsSent < len(dunningReminders) && !userBilling.PastDueSince.Add(dunningReminders[userBilling.DunningEmailsSent]).After(time.Now()) {
+		userBilling.DunningEmailsSent++
+	}
+	return true
+}
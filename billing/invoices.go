@@ -0,0 +1,180 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/client"
+
+	"github.com/news-ai/api/models"
+)
+
+type InvoiceLine struct {
+	Description string    `json:"description"`
+	Plan        string    `json:"plan"`
+	Quantity    int64     `json:"quantity"`
+	Amount      float64   `json:"amount"`
+	PeriodStart time.Time `json:"periodstart"`
+	PeriodEnd   time.Time `json:"periodend"`
+	Proration   bool      `json:"proration"`
+}
+
+// Amounts are in the currency of the invoice, not in cents
+type Invoice struct {
+	Id       string    `json:"id"`
+	Date     time.Time `json:"date"`
+	Currency string    `json:"currency"`
+
+	PeriodStart time.Time `json:"periodstart"`
+	PeriodEnd   time.Time `json:"periodend"`
+
+	Lines []InvoiceLine `json:"lines"`
+
+	Subtotal   float64 `json:"subtotal"`
+	Coupon     string  `json:"coupon"`
+	Discount   float64 `json:"discount"`
+	Tax        float64 `json:"tax"`
+	TaxPercent float64 `json:"taxpercent"`
+	Total      float64 `json:"total"`
+	AmountDue  float64 `json:"amountdue"`
+
+	Paid          bool   `json:"paid"`
+	PaymentMethod string `json:"paymentmethod"`
+}
+
+/*
+* Private methods
+ */
+
+func centsToAmount(cents int64) float64 {
+	return toFixed(float64(cents)/float64(100), 2)
+}
+
+// Like "Visa ending in 4242". The charge has to be expanded on the invoice.
+func chargePaymentMethod(charge *stripe.Charge) string {
+	if charge == nil || charge.Source == nil || charge.Source.Card == nil {
+		return ""
+	}
+	return string(charge.Source.Card.Brand) + " ending in " + charge.Source.Card.LastFour
+}
+
+func stripeInvoiceToInvoice(stripeInvoice *stripe.Invoice) Invoice {
+	invoice := Invoice{}
+	invoice.Id = stripeInvoice.ID
+	invoice.Date = time.Unix(stripeInvoice.Date, 0)
+	invoice.Currency = strings.ToUpper(string(stripeInvoice.Currency))
+	invoice.PeriodStart = time.Unix(stripeInvoice.Start, 0)
+	invoice.PeriodEnd = time.Unix(stripeInvoice.End, 0)
+
+	invoice.Subtotal = centsToAmount(stripeInvoice.Subtotal)
+	invoice.Tax = centsToAmount(stripeInvoice.Tax)
+	invoice.TaxPercent = stripeInvoice.TaxPercent
+	invoice.Total = centsToAmount(stripeInvoice.Total)
+	invoice.AmountDue = centsToAmount(stripeInvoice.Amount)
+	invoice.Discount = centsToAmount(stripeInvoice.Subtotal + stripeInvoice.Tax - stripeInvoice.Total)
+
+	if stripeInvoice.Discount != nil && stripeInvoice.Discount.Coupon != nil {
+		invoice.Coupon = stripeInvoice.Discount.Coupon.ID
+	}
+
+	invoice.Paid = stripeInvoice.Paid
+	invoice.PaymentMethod = chargePaymentMethod(stripeInvoice.Charge)
+
+	invoice.Lines = []InvoiceLine{}
+	if stripeInvoice.Lines != nil {
+		for i := 0; i < len(stripeInvoice.Lines.Values); i++ {
+			stripeLine := stripeInvoice.Lines.Values[i]
+
+			line := InvoiceLine{}
+			line.Description = stripeLine.Desc
+			line.Quantity = stripeLine.Quantity
+			line.Amount = centsToAmount(stripeLine.Amount)
+			line.Proration = stripeLine.Proration
+			if stripeLine.Plan != nil {
+				line.Plan = stripeLine.Plan.Name
+				if line.Description == "" {
+					line.Description = stripeLine.Plan.Name
+				}
+			}
+			if stripeLine.Period != nil {
+				line.PeriodStart = time.Unix(stripeLine.Period.Start, 0)
+				line.PeriodEnd = time.Unix(stripeLine.Period.End, 0)
+			}
+
+			invoice.Lines = append(invoice.Lines, line)
+		}
+	}
+
+	return invoice
+}
+
+/*
+* Public methods
+ */
+
+// A page of the invoices of a customer, newest first
+func GetCustomerInvoices(r *http.Request, userBilling *models.Billing, offset int, limit int) ([]Invoice, error) {
+	c := appengine.NewContext(r)
+	httpClient := urlfetch.Client(c)
+	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
+
+	if userBilling.StripeId == "" {
+		return []Invoice{}, nil
+	}
+
+	// Stripe pages with cursors, so skip the invoices before the offset.
+	// Stripe lists at most 100 invoices in a single call.
+	params := &stripe.InvoiceListParams{}
+	params.Customer = userBilling.StripeId
+	params.Limit = offset + limit
+	if params.Limit > 100 {
+		params.Limit = 100
+	}
+	params.Expand("data.charge")
+	i := sc.Invoices.List(params)
+
+	invoices := []Invoice{}
+	for skipped := 0; len(invoices) < limit && i.Next(); {
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		invoices = append(invoices, stripeInvoiceToInvoice(i.Invoice()))
+	}
+
+	if err := i.Err(); err != nil {
+		log.Errorf(c, "%v", err)
+		return []Invoice{}, stripeErrorMessage(err, "We had an error getting your invoices")
+	}
+
+	return invoices, nil
+}
+
+// An invoice of a customer. Invoices of other customers are not found.
+func GetCustomerInvoice(r *http.Request, userBilling *models.Billing, invoiceId string) (Invoice, error) {
+	c := appengine.NewContext(r)
+	httpClient := urlfetch.Client(c)
+	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
+
+	params := &stripe.InvoiceParams{}
+	params.Expand("charge")
+	stripeInvoice, err := sc.Invoices.Get(invoiceId, params)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return Invoice{}, errors.New("No invoice by this id")
+	}
+
+	if stripeInvoice.Customer == nil || stripeInvoice.Customer.ID != userBilling.StripeId {
+		return Invoice{}, errors.New("No invoice by this id")
+	}
+
+	return stripeInvoiceToInvoice(stripeInvoice), nil
+}
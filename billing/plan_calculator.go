@@ -2,6 +2,8 @@ package billing
 
 import (
 	"math"
+
+	"github.com/news-ai/api/models"
 )
 
 func round(num float64) int {
@@ -13,30 +15,10 @@ func toFixed(num float64, precision int) float64 {
 	return float64(round(num*output)) / output
 }
 
-func PlanAndDurationToPrice(plan string, duration string) float64 {
-	price := float64(0.00)
+func PlanAndDurationToPrice(plan models.Plan, duration string) float64 {
+	price := plan.YearlyPrice
 	if duration == "monthly" {
-		switch plan {
-		case "Personal": // now "Personal"
-			price = 18.99 * 1
-		case "Consultant": // now "Consultant"
-			price = 34.99 * 1
-		case "Business": // now "Business"
-			price = 41.99 * 1
-		case "Growing Business": // now "Growing Business"
-			price = 52.99 * 1
-		}
-	} else {
-		switch plan {
-		case "Personal": // now "Personal"
-			price = 15.99 * 12
-		case "Consultant": // now "Consultant"
-			price = 28.99 * 12
-		case "Business": // now "Business"
-			price = 34.99 * 12
-		case "Growing Business": // now "Growing Business"
-			price = 43.99 * 12
-		}
+		price = plan.MonthlyPrice
 	}
 
 	return toFixed(price, 2)
@@ -1,72 +1,208 @@
 package billing
 
-func BillingIdToPlanName(plan string) string {
-	switch plan {
-	case "bronze", "personal": // now "Personal"
-		return "Personal"
-	case "aluminum", "consultant": // now "Consultant"
-		return "Consultant"
-	case "silver", "silver-1", "business": // now "Business"
-		return "Business"
-	case "gold", "gold-1", "growing": // now "Growing Business"
-		return "Growing Business"
-	case "free":
-		return "Trial Member"
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/usage"
+)
+
+// Plans that are used until a plan with the same id is stored
+var defaultPlans = []models.Plan{
+	{
+		PlanId:             "free",
+		Name:               "Trial Member",
+		DailyEmailsAllowed: 100,
+		Retired:            true,
+	},
+	{
+		PlanId:             "personal",
+		Name:               "Personal",
+		StripePlanIds:      []string{"bronze"},
+		MonthlyPrice:       18.99,
+		YearlyPrice:        15.99 * 12,
+		EmailAccounts:      0,
+		DailyEmailsAllowed: 100,
+		SocialAccounts:     100,
+		TeamMembers:        1,
+	},
+	{
+		PlanId:             "consultant",
+		Name:               "Consultant",
+		StripePlanIds:      []string{"aluminum"},
+		MonthlyPrice:       34.99,
+		YearlyPrice:        28.99 * 12,
+		EmailAccounts:      2,
+		DailyEmailsAllowed: 400,
+		SocialAccounts:     250,
+		TeamMembers:        3,
+	},
+	{
+		PlanId:             "business",
+		Name:               "Business",
+		StripePlanIds:      []string{"silver", "silver-1"},
+		MonthlyPrice:       41.99,
+		YearlyPrice:        34.99 * 12,
+		EmailAccounts:      5,
+		DailyEmailsAllowed: 1000,
+		SocialAccounts:     500,
+		TeamMembers:        5,
+	},
+	{
+		PlanId:             "growing",
+		Name:               "Growing Business",
+		StripePlanIds:      []string{"gold", "gold-1"},
+		MonthlyPrice:       52.99,
+		YearlyPrice:        43.99 * 12,
+		EmailAccounts:      10,
+		DailyEmailsAllowed: 2500,
+		SocialAccounts:     100000,
+		TeamMembers:        10,
+	},
+}
+
+// Users on a plan that is not in the catalog get this one
+const defaultPlanId = "personal"
+
+// Every paid plan could send this many emails a day before the limits
+// came from the plan catalog
+const legacyDailyEmailsAllowed = 20000
+
+/*
+* Private methods
+ */
+
+func getStoredPlans(c context.Context) ([]models.Plan, error) {
+	ks, err := datastore.NewQuery("Plan").KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Plan{}, err
 	}
 
-	return "Personal"
+	plans := make([]models.Plan, len(ks))
+	err = nds.GetMulti(c, ks, plans)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Plan{}, err
+	}
+
+	for i := 0; i < len(plans); i++ {
+		plans[i].Format(ks[i], "plans")
+	}
+	return plans, nil
 }
 
-func UserMaximumSocialAccounts(plan string) int {
-	switch plan {
-	case "Personal": // now "Personal"
-		return 100
-	case "Consultant": // now "Consultant"
-		return 250
-	case "Business": // now "Business"
-		return 500
-	case "Growing Business": // now "Growing Business"
-		return 100000
+/*
+* Public methods
+ */
+
+// The plan catalog. Stored plans replace the default plan with the same
+// id, and the rest are added after the defaults.
+func GetPlans(c context.Context) ([]models.Plan, error) {
+	storedPlans, err := getStoredPlans(c)
+	if err != nil {
+		return []models.Plan{}, err
+	}
+
+	plans := []models.Plan{}
+	for i := 0; i < len(defaultPlans); i++ {
+		plan := defaultPlans[i]
+		for x := 0; x < len(storedPlans); x++ {
+			if storedPlans[x].PlanId == plan.PlanId {
+				plan = storedPlans[x]
+			}
+		}
+		plans = append(plans, plan)
 	}
 
-	return 0
+	for i := 0; i < len(storedPlans); i++ {
+		isDefault := false
+		for x := 0; x < len(defaultPlans); x++ {
+			if storedPlans[i].PlanId == defaultPlans[x].PlanId {
+				isDefault = true
+			}
+		}
+
+		if !isDefault {
+			plans = append(plans, storedPlans[i])
+		}
+	}
+
+	return plans, nil
 }
 
-func UserMaximumEmailAccounts(plan string) int {
-	switch plan {
-	case "Personal": // now "Personal"
-		return 0
-	case "Consultant": // now "Consultant"
-		return 2
-	case "Business": // now "Business"
-		return 5
-	case "Growing Business": // now "Growing Business"
-		return 10
+// Finds a plan by its Stripe id or name
+func FindPlan(c context.Context, planId string) (models.Plan, error) {
+	plans, err := GetPlans(c)
+	if err != nil {
+		plans = defaultPlans
 	}
 
-	return 0
+	planId = StripePlanToPlanId(planId)
+	for i := 0; i < len(plans); i++ {
+		if plans[i].Matches(planId) {
+			return plans[i], nil
+		}
+	}
+
+	return models.Plan{}, errors.New("No plan by this id")
+}
+
+// The plan of a user. Users are on the personal plan if we don't know
+// their plan.
+func GetPlan(c context.Context, planId string) models.Plan {
+	plan, err := FindPlan(c, planId)
+	if err != nil {
+		log.Infof(c, "%v: %v", err, planId)
+		plan, _ = FindPlan(c, defaultPlanId)
+	}
+	return plan
 }
 
-func UserMaximumEmailSent(plan string) int {
-	switch plan {
-	case "Personal": // now "Personal"
-		return 100
-	case "Consultant": // now "Consultant"
-		return 400
-	case "Business": // now "Business"
-		return 1000
-	case "Growing Business": // now "Growing Business"
-		return 2500
+// The plan of a billing with the limits that it subscribed with
+func GetBillingPlan(c context.Context, userBilling models.Billing) models.Plan {
+	plan := GetPlan(c, userBilling.StripePlanId)
+	if userBilling.LimitsPlanId != "" && userBilling.LimitsPlanId == plan.PlanId {
+		plan.EmailAccounts = userBilling.EmailAccounts
+		plan.DailyEmailsAllowed = userBilling.DailyEmailsAllowed
+		plan.SocialAccounts = userBilling.SocialAccounts
+		plan.TeamMembers = userBilling.TeamMembers
 	}
+	return plan
+}
 
-	return 0
+// Keeps the limits of the plan of a billing when it subscribes, so they
+// don't change when the plan is changed later
+func SetPlanLimits(c context.Context, userBilling *models.Billing) {
+	plan := GetPlan(c, userBilling.StripePlanId)
+	userBilling.LimitsPlanId = plan.PlanId
+	userBilling.EmailAccounts = plan.EmailAccounts
+	userBilling.DailyEmailsAllowed = plan.DailyEmailsAllowed
+	userBilling.SocialAccounts = plan.SocialAccounts
+	userBilling.TeamMembers = plan.TeamMembers
 }
 
-func StripePlanIdToMaximumEmailSent(stripePlanId string) int {
-	switch stripePlanId {
-	case "free": // now "Personal"
-		return 100
+// Users that paid for a plan before the limits came from the plan catalog
+// keep what they had: 20000 emails a day and no limit on social accounts.
+// Trials get the limits of the plan when they pay for it, and agencies
+// were added with the catalog. Returns if the billing changed.
+func SetLegacyPlanLimits(c context.Context, userBilling *models.Billing) bool {
+	if userBilling.LimitsPlanId != "" || userBilling.IsAgency || userBilling.IsOnTrial {
+		return false
+	}
+	if userBilling.StripePlanId == "" || StripePlanToPlanId(userBilling.StripePlanId) == "free" {
+		return false
 	}
 
-	return 20000
+	SetPlanLimits(c, userBilling)
+	userBilling.DailyEmailsAllowed = legacyDailyEmailsAllowed
+	userBilling.SocialAccounts = usage.Unlimited
+	return true
 }
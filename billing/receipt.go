@@ -0,0 +1,120 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Who the receipt is for. Agencies are shown with their name and address.
+type ReceiptCustomer struct {
+	Name         string
+	Email        string
+	AddressLines []string
+}
+
+/*
+* Private methods
+ */
+
+func formatReceiptAmount(invoice Invoice, amount float64) string {
+	return fmt.Sprintf("%s %.2f", invoice.Currency, amount)
+}
+
+func formatReceiptPeriod(line InvoiceLine) string {
+	if line.PeriodStart.IsZero() || line.PeriodEnd.IsZero() {
+		return ""
+	}
+	return line.PeriodStart.Format("Jan 2, 2006") + " - " + line.PeriodEnd.Format("Jan 2, 2006")
+}
+
+/*
+* Public methods
+ */
+
+// Renders the receipt of an invoice as a PDF
+func RenderReceipt(invoice Invoice, customer ReceiptCustomer) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(20, 20, 20)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(85, 10, "NewsAI", "", 0, "L", false, 0, "")
+	pdf.CellFormat(85, 10, "Receipt", "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(85, 5, "newsai.co", "", 0, "L", false, 0, "")
+	pdf.CellFormat(85, 5, "Invoice "+invoice.Id, "", 1, "R", false, 0, "")
+	pdf.CellFormat(85, 5, "", "", 0, "L", false, 0, "")
+	pdf.CellFormat(85, 5, "Date "+invoice.Date.Format("January 2, 2006"), "", 1, "R", false, 0, "")
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(0, 5, "Billed to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	billedTo := []string{customer.Name}
+	billedTo = append(billedTo, customer.AddressLines...)
+	billedTo = append(billedTo, customer.Email)
+	for i := 0; i < len(billedTo); i++ {
+		if billedTo[i] != "" {
+			pdf.CellFormat(0, 5, tr(billedTo[i]), "", 1, "L", false, 0, "")
+		}
+	}
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(240, 240, 240)
+	pdf.CellFormat(70, 7, "Description", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(50, 7, "Period", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(15, 7, "Qty", "B", 0, "R", true, 0, "")
+	pdf.CellFormat(35, 7, "Amount", "B", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 9)
+	for i := 0; i < len(invoice.Lines); i++ {
+		line := invoice.Lines[i]
+		pdf.CellFormat(70, 6, tr(line.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(50, 6, formatReceiptPeriod(line), "", 0, "L", false, 0, "")
+		pdf.CellFormat(15, 6, fmt.Sprintf("%d", line.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 6, formatReceiptAmount(invoice, line.Amount), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	totals := [][]string{
+		{"Subtotal", formatReceiptAmount(invoice, invoice.Subtotal)},
+	}
+	if invoice.Discount != 0 {
+		totals = append(totals, []string{"Discount (" + invoice.Coupon + ")", "-" + formatReceiptAmount(invoice, invoice.Discount)})
+	}
+	if invoice.Tax != 0 {
+		totals = append(totals, []string{fmt.Sprintf("Tax (%.2f%%)", invoice.TaxPercent), formatReceiptAmount(invoice, invoice.Tax)})
+	}
+	totals = append(totals, []string{"Total", formatReceiptAmount(invoice, invoice.Total)})
+
+	for i := 0; i < len(totals); i++ {
+		if i == len(totals)-1 {
+			pdf.SetFont("Helvetica", "B", 10)
+		}
+		pdf.CellFormat(135, 6, totals[i][0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 6, totals[i][1], "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "", 10)
+	status := "Amount due " + formatReceiptAmount(invoice, invoice.AmountDue)
+	if invoice.Paid {
+		status = "Paid"
+		if invoice.PaymentMethod != "" {
+			status = "Paid with " + invoice.PaymentMethod
+		}
+	}
+	pdf.CellFormat(0, 5, status, "", 1, "L", false, 0, "")
+
+	var receipt bytes.Buffer
+	err := pdf.Output(&receipt)
+	if err != nil {
+		return nil, err
+	}
+	return receipt.Bytes(), nil
+}
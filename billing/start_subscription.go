@@ -16,7 +16,9 @@ import (
 	"github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/client"
 
+	"github.com/news-ai/api/audit"
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/webhooks"
 	"github.com/news-ai/tabulae/emails"
 )
 
@@ -44,6 +46,10 @@ func AddFreeTrialToUser(r *http.Request, user models.User, plan string) (int64,
 		log.Errorf(c, "%v", err)
 		return billingId, err
 	}
+
+	webhooks.Trigger(r, models.WebhookEventUserTrialStarted, user, map[string]interface{}{
+		"plan": plan,
+	})
 	return billingId, nil
 }
 
@@ -52,6 +58,17 @@ func AddPlanToUser(r *http.Request, user models.User, userBilling *models.Billin
 	httpClient := urlfetch.Client(c)
 	sc := client.New(os.Getenv("STRIPE_SECRET_KEY"), stripe.NewBackends(httpClient))
 
+	selectedPlan, err := FindPlan(c, plan)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return errors.New("This plan does not exist")
+	}
+
+	// Users that are on a retired plan can stay on it
+	if selectedPlan.Retired && !selectedPlan.Matches(userBilling.StripePlanId) {
+		return errors.New("This plan is no longer available")
+	}
+
 	customer, err := sc.Customers.Get(userBilling.StripeId, nil)
 	if err != nil {
 		var stripeError StripeError
@@ -65,6 +82,24 @@ func AddPlanToUser(r *http.Request, user models.User, userBilling *models.Billin
 		return errors.New(stripeError.Message)
 	}
 
+	// Coupons are checked and reserved before any subscriptions are
+	// canceled
+	if coupon != "" {
+		err = CheckCouponRules(c, coupon, plan, duration, *userBilling)
+		if err != nil {
+			return err
+		}
+
+		err = ReserveCouponRedemption(c, coupon)
+		if err == errCouponUsedUp {
+			return err
+		}
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return errors.New("We had an error checking your coupon")
+		}
+	}
+
 	// Only considers plans currently that moving from trial. Not changing plans.
 	// Cancel all past subscriptions they had
 	for i := 0; i < len(customer.Subs.Values); i++ {
@@ -86,24 +121,15 @@ func AddPlanToUser(r *http.Request, user models.User, userBilling *models.Billin
 		params.Coupon = coupon
 	}
 
-	if strings.ToLower(coupon) == "favorites" && duration == "annually" {
-		return errors.New("Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-	}
-
-	if strings.ToLower(coupon) == "prcouture" && duration == "annually" {
-		return errors.New("Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-	}
-
-	if strings.ToLower(coupon) == "curious" && duration == "annually" {
-		return errors.New("Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-	}
-
-	if strings.ToLower(coupon) == "prconsultants" && duration == "annually" {
-		return errors.New("Sorry - you can't use this coupon code on a yearly plan. Please switch the monthly one to use this!")
-	}
-
 	newSub, err := sc.Subs.New(params)
 	if err != nil {
+		if coupon != "" {
+			releaseErr := ReleaseCouponRedemption(c, coupon)
+			if releaseErr != nil {
+				log.Errorf(c, "%v", releaseErr)
+			}
+		}
+
 		var stripeError StripeError
 		err = json.Unmarshal([]byte(err.Error()), &stripeError)
 		if err != nil {
@@ -116,17 +142,30 @@ func AddPlanToUser(r *http.Request, user models.User, userBilling *models.Billin
 	}
 
 	// Return if there are any errors
+	before := billingAuditValues(*userBilling)
 	expiresAt := time.Unix(newSub.PeriodEnd, 0)
 	userBilling.Expires = expiresAt
 	userBilling.StripePlanId = plan
+	SetPlanLimits(c, userBilling)
 	userBilling.IsOnTrial = false
+	userBilling.HasPaid = true
 	userBilling.Save(c)
 
 	// Set the user to be an active being on the platform again
 	user.IsActive = true
 	user.Save(c)
 
-	currentPrice := PlanAndDurationToPrice(originalPlan, duration)
+	after := billingAuditValues(*userBilling)
+	after["duration"] = duration
+	after["coupon"] = coupon
+	audit.Record(r, models.AuditActionPlanAdd, user, before, after)
+	webhooks.Trigger(r, models.WebhookEventUserPaid, user, map[string]interface{}{
+		"plan":     plan,
+		"duration": duration,
+		"expires":  expiresAt,
+	})
+
+	currentPrice := PlanAndDurationToPrice(selectedPlan, duration)
 	billAmount := "$" + fmt.Sprintf("%0.2f", currentPrice)
 	paidAmount := "$" + fmt.Sprintf("%0.2f", currentPrice)
 
@@ -0,0 +1,154 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events that are older than this are rejected so they can't be replayed
+const stripeSignatureTolerance = 5 * time.Minute
+
+var ErrInvalidStripeSignature = errors.New("Invalid Stripe signature")
+
+// A Stripe event. Only the parts we use are decoded.
+type StripeEvent struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type StripeInvoice struct {
+	Id                 string `json:"id"`
+	Customer           string `json:"customer"`
+	Subscription       string `json:"subscription"`
+	AmountDue          int64  `json:"amount_due"`
+	AmountPaid         int64  `json:"amount_paid"`
+	AttemptCount       int    `json:"attempt_count"`
+	NextPaymentAttempt int64  `json:"next_payment_attempt"`
+	Lines              struct {
+		Data []struct {
+			Period struct {
+				Start int64 `json:"start"`
+				End   int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+type StripeSubscription struct {
+	Id                string `json:"id"`
+	Customer          string `json:"customer"`
+	Status            string `json:"status"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	TrialEnd          int64  `json:"trial_end"`
+	EndedAt           int64  `json:"ended_at"`
+	Quantity          int    `json:"quantity"`
+	Plan              struct {
+		Id string `json:"id"`
+	} `json:"plan"`
+}
+
+/*
+* Private methods
+ */
+
+// Stripe-Signature is t=<timestamp>,v1=<signature>, with more than one v1
+// while the webhook secret is being rolled
+func verifyStripeSignature(payload []byte, header string, secret string) error {
+	timestamp := ""
+	signatures := []string{}
+
+	parts := strings.Split(header, ",")
+	for i := 0; i < len(parts); i++ {
+		keyValue := strings.SplitN(strings.TrimSpace(parts[i]), "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		switch keyValue[0] {
+		case "t":
+			timestamp = keyValue[1]
+		case "v1":
+			signatures = append(signatures, keyValue[1])
+		}
+	}
+
+	unixTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidStripeSignature
+	}
+
+	signedAt := time.Unix(unixTimestamp, 0)
+	if time.Now().Sub(signedAt) > stripeSignatureTolerance || signedAt.Sub(time.Now()) > stripeSignatureTolerance {
+		return ErrInvalidStripeSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for i := 0; i < len(signatures); i++ {
+		signature, err := hex.DecodeString(signatures[i])
+		if err == nil && hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidStripeSignature
+}
+
+/*
+* Public methods
+ */
+
+// Verifies that a webhook request came from Stripe and decodes its event
+func ConstructStripeEvent(payload []byte, header string) (StripeEvent, error) {
+	secret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	if secret == "" {
+		return StripeEvent{}, errors.New("No Stripe webhook secret has been configured")
+	}
+
+	err := verifyStripeSignature(payload, header, secret)
+	if err != nil {
+		return StripeEvent{}, err
+	}
+
+	var event StripeEvent
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return StripeEvent{}, err
+	}
+	return event, nil
+}
+
+// Our plan ids are the Stripe plan ids without the duration or trial
+func StripePlanToPlanId(stripePlanId string) string {
+	stripePlanId = strings.TrimSuffix(stripePlanId, "-yearly")
+	return strings.TrimSuffix(stripePlanId, "-trial")
+}
+
+// The end of the period that an invoice paid for
+func (si StripeInvoice) PeriodEnd() time.Time {
+	periodEnd := int64(0)
+	for i := 0; i < len(si.Lines.Data); i++ {
+		if si.Lines.Data[i].Period.End > periodEnd {
+			periodEnd = si.Lines.Data[i].Period.End
+		}
+	}
+
+	if periodEnd == 0 {
+		return time.Time{}
+	}
+	return time.Unix(periodEnd, 0)
+}
@@ -0,0 +1,110 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func signStripePayload(payload []byte, timestamp int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"invoice.payment_succeeded"}`)
+	now := time.Now().Unix()
+	timestamp := strconv.FormatInt(now, 10)
+	signature := signStripePayload(payload, now, testWebhookSecret)
+
+	oldTimestamp := time.Now().Add(-stripeSignatureTolerance - time.Minute).Unix()
+	futureTimestamp := time.Now().Add(stripeSignatureTolerance + time.Minute).Unix()
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		valid   bool
+	}{
+		{
+			name:    "valid signature",
+			payload: payload,
+			header:  "t=" + timestamp + ",v1=" + signature,
+			valid:   true,
+		},
+		{
+			name:    "valid signature with spaces and other schemes",
+			payload: payload,
+			header:  "t=" + timestamp + ", v0=abc, v1=" + signature,
+			valid:   true,
+		},
+		{
+			name:    "one of the signatures while the secret is rolled",
+			payload: payload,
+			header:  "t=" + timestamp + ",v1=" + signStripePayload(payload, now, "whsec_old") + ",v1=" + signature,
+			valid:   true,
+		},
+		{
+			name:    "changed payload",
+			payload: []byte(`{"id":"evt_2","type":"invoice.payment_succeeded"}`),
+			header:  "t=" + timestamp + ",v1=" + signature,
+		},
+		{
+			name:    "other secret",
+			payload: payload,
+			header:  "t=" + timestamp + ",v1=" + signStripePayload(payload, now, "whsec_other"),
+		},
+		{
+			name:    "changed timestamp",
+			payload: payload,
+			header:  "t=" + strconv.FormatInt(now-1, 10) + ",v1=" + signature,
+		},
+		{
+			name:    "replayed old event",
+			payload: payload,
+			header:  "t=" + strconv.FormatInt(oldTimestamp, 10) + ",v1=" + signStripePayload(payload, oldTimestamp, testWebhookSecret),
+		},
+		{
+			name:    "timestamp in the future",
+			payload: payload,
+			header:  "t=" + strconv.FormatInt(futureTimestamp, 10) + ",v1=" + signStripePayload(payload, futureTimestamp, testWebhookSecret),
+		},
+		{
+			name:    "no timestamp",
+			payload: payload,
+			header:  "v1=" + signature,
+		},
+		{
+			name:    "no signature",
+			payload: payload,
+			header:  "t=" + timestamp,
+		},
+		{
+			name:    "signature that is not hex",
+			payload: payload,
+			header:  "t=" + timestamp + ",v1=not-hex",
+		},
+		{
+			name:    "blank header",
+			payload: payload,
+			header:  "",
+		},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err := verifyStripeSignature(tests[i].payload, tests[i].header, testWebhookSecret)
+		if tests[i].valid && err != nil {
+			t.Errorf("%s: %v", tests[i].name, err)
+		}
+		if !tests[i].valid && err != ErrInvalidStripeSignature {
+			t.Errorf("%s: got %v, want ErrInvalidStripeSignature", tests[i].name, err)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/client"
 
+	"github.com/news-ai/api/audit"
 	"github.com/news-ai/api/models"
 )
 
@@ -86,5 +87,9 @@ func SwitchUserPlan(r *http.Request, user models.User, userBilling *models.Billi
 		return errors.New(stripeError.Message)
 	}
 
+	after := billingAuditValues(*userBilling)
+	after["plan"] = newPlan
+	audit.Record(r, models.AuditActionPlanSwitch, user, billingAuditValues(*userBilling), after)
+
 	return nil
 }
@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -11,11 +13,14 @@ import (
 	"google.golang.org/appengine/log"
 
 	gcontext "github.com/gorilla/context"
+	"github.com/pquerna/ffjson/ffjson"
 	"github.com/qedus/nds"
 
 	"github.com/news-ai/web/utilities"
 
+	"github.com/news-ai/api/audit"
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
 	"github.com/news-ai/tabulae/search"
 )
 
@@ -49,6 +54,121 @@ func getAgency(c context.Context, id int64) (models.Agency, error) {
 	return models.Agency{}, errors.New("No agency by this id")
 }
 
+/*
+* Action methods
+ */
+
+func int64InSlice(id int64, ids []int64) bool {
+	for i := 0; i < len(ids); i++ {
+		if ids[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func stringInSlice(value string, values []string) bool {
+	for i := 0; i < len(values); i++ {
+		if values[i] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces an agency in a list of employers with another one without
+// adding the same agency twice
+func replaceEmployer(employers []int64, oldId int64, newId int64) []int64 {
+	newEmployers := []int64{}
+	for i := 0; i < len(employers); i++ {
+		employer := employers[i]
+		if employer == oldId {
+			employer = newId
+		}
+
+		if !int64InSlice(employer, newEmployers) {
+			newEmployers = append(newEmployers, employer)
+		}
+	}
+	return newEmployers
+}
+
+// Adds an agency to the employers of a user. They are taken out of the
+// past employers if they are back at the agency.
+func addEmployer(user *models.User, agencyId int64) {
+	if int64InSlice(agencyId, user.Employers) {
+		return
+	}
+
+	user.Employers = append(user.Employers, agencyId)
+
+	pastEmployers := []int64{}
+	for i := 0; i < len(user.PastEmployers); i++ {
+		if user.PastEmployers[i] != agencyId {
+			pastEmployers = append(pastEmployers, user.PastEmployers[i])
+		}
+	}
+	user.PastEmployers = pastEmployers
+}
+
+func getAgencyForAction(c context.Context, r *http.Request, id string) (models.Agency, models.User, error) {
+	currentId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, models.User{}, err
+	}
+
+	agency, err := getAgency(c, currentId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, models.User{}, err
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, models.User{}, err
+	}
+
+	return agency, currentUser, nil
+}
+
+// Gets a team that asked to join an agency for an administrator of the
+// agency to approve or reject
+func getAgencyTeamRequest(c context.Context, r *http.Request, id string) (models.Agency, models.Team, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, models.Team{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyTeam models.AgencyTeam
+	err = decoder.Decode(buf, &agencyTeam)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, models.Team{}, err
+	}
+
+	team, err := getTeam(c, agencyTeam.TeamId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, models.Team{}, err
+	}
+
+	if team.RequestedAgencyId != agency.Id {
+		return agency, models.Team{}, errors.New("This team has not asked to join the agency")
+	}
+
+	return agency, team, nil
+}
+
 /*
 * Filter methods
  */
@@ -107,8 +227,10 @@ func GetAgencies(c context.Context, r *http.Request) ([]models.Agency, interface
 		return []models.Agency{}, nil, 0, 0, err
 	}
 
-	if !user.IsAdmin {
-		return []models.Agency{}, nil, 0, 0, errors.New("Forbidden")
+	err = policy.Authorize(c, user, policy.ActionRead, policy.Collection("Agency"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Agency{}, nil, 0, 0, err
 	}
 
 	query := datastore.NewQuery("Agency")
@@ -135,7 +257,7 @@ func GetAgencies(c context.Context, r *http.Request) ([]models.Agency, interface
 	return agencies, nil, len(agencies), 0, nil
 }
 
-func GetAgency(c context.Context, id string) (models.Agency, interface{}, error) {
+func GetAgency(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
 	// Get the details of the current agency
 	currentId, err := utilities.StringIdToInt(id)
 	if err != nil {
@@ -149,13 +271,163 @@ func GetAgency(c context.Context, id string) (models.Agency, interface{}, error)
 		return models.Agency{}, nil, err
 	}
 
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionRead, agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
 	return agency, nil, nil
 }
 
+func GetAgencyEmployees(c context.Context, r *http.Request, id string) ([]models.User, interface{}, int, int, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return []models.User{}, nil, 0, 0, err
+	}
+
+	err = requireVerifiedDomain(c, currentUser, agency)
+	if err != nil {
+		return []models.User{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("User").Filter("Employers =", agency.Id)
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, nil, 0, 0, err
+	}
+
+	var users []models.User
+	users = make([]models.User, len(ks))
+	err = nds.GetMulti(c, ks, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(users); i++ {
+		users[i].Format(ks[i], "users")
+	}
+
+	return users, nil, len(users), 0, nil
+}
+
+// Teams that have asked to join an agency
+func GetAgencyTeamRequests(c context.Context, r *http.Request, id string) ([]models.Team, interface{}, int, int, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return []models.Team{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("Team").Filter("RequestedAgencyId =", agency.Id)
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Team{}, nil, 0, 0, err
+	}
+
+	var teams []models.Team
+	teams = make([]models.Team, len(ks))
+	err = nds.GetMulti(c, ks, teams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Team{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(teams); i++ {
+		teams[i].Format(ks[i], "teams")
+	}
+
+	return teams, nil, len(teams), 0, nil
+}
+
 /*
 * Create methods
  */
 
+func CreateAgency(c context.Context, r *http.Request) (models.Agency, interface{}, error) {
+	buf, _ := ioutil.ReadAll(r.Body)
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	decoder := ffjson.NewDecoder()
+	var agency models.Agency
+	err = decoder.Decode(buf, &agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	if agency.Name == "" {
+		return models.Agency{}, nil, errors.New("Please provide a name for the agency")
+	}
+
+	currentUserEmail, err := utilities.ExtractEmailExtension(currentUser.Email)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	agency.Email = strings.ToLower(agency.Email)
+	if agency.Email == "" {
+		agency.Email = currentUserEmail
+	}
+
+	// Users that are not admins can only create the agency of their own
+	// email domain
+	isPlatformAdmin := policy.Can(c, currentUser, policy.ActionCreate, policy.Collection("Agency"))
+	if !isPlatformAdmin && agency.Email != currentUserEmail {
+		return models.Agency{}, nil, errors.New("You can only create an agency for your own email domain")
+	}
+
+	_, err = FilterAgencyByEmail(c, agency.Email)
+	if err == nil {
+		return models.Agency{}, nil, errors.New("An agency with this email domain already exists")
+	}
+
+	// Clients are attached and agencies merged through their own actions
+	agency.AlternateEmails = []string{}
+	agency.Clients = []int64{}
+
+	// Domains are verified, and single sign-on set up, through their own
+	// actions too
+	agency.VerifiedDomains = []string{}
+	agency.DomainVerificationToken = ""
+	agency.SSOType = ""
+	agency.SSOEnforced = false
+
+	if !isPlatformAdmin || len(agency.Administrators) == 0 {
+		agency.Administrators = []int64{currentUser.Id}
+	}
+
+	_, err = agency.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	// The person who creates the agency works there
+	if !isPlatformAdmin && !int64InSlice(agency.Id, currentUser.Employers) {
+		currentUser.Employers = append(currentUser.Employers, agency.Id)
+		SaveUser(c, r, &currentUser)
+	}
+
+	return agency, nil, nil
+}
+
 func CreateAgencyFromUser(c context.Context, r *http.Request, u *models.User) (models.Agency, error) {
 	agencyEmail, err := utilities.ExtractEmailExtension(u.Email)
 	if err != nil {
@@ -164,6 +436,11 @@ func CreateAgencyFromUser(c context.Context, r *http.Request, u *models.User) (m
 	}
 
 	agency, err := FilterAgencyByEmail(c, agencyEmail)
+	if err == nil && int64InSlice(agency.Id, u.PastEmployers) {
+		// The administrators of the agency have removed this user
+		return agency, errors.New("User has been removed from this agency")
+	}
+
 	if err != nil {
 		agency = models.Agency{}
 		agency.Name, err = utilities.ExtractNameFromEmail(agencyEmail)
@@ -171,8 +448,11 @@ func CreateAgencyFromUser(c context.Context, r *http.Request, u *models.User) (m
 		agency.Created = time.Now()
 
 		// The person who signs up for the agency at the beginning
-		// becomes the defacto administrator until we change.
-		agency.Administrators = append(agency.Administrators, u.Id)
+		// becomes the defacto administrator until we change. Nobody
+		// administers the agency of a public email domain.
+		if !isPublicEmailDomain(agencyEmail) {
+			agency.Administrators = append(agency.Administrators, u.Id)
+		}
 		currentUser, err := GetCurrentUser(c, r)
 		if err != nil {
 			log.Errorf(c, "%v", err)
@@ -187,6 +467,396 @@ func CreateAgencyFromUser(c context.Context, r *http.Request, u *models.User) (m
 	return agency, nil
 }
 
+/*
+* Update methods
+ */
+
+func UpdateAgency(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var updatedAgency models.Agency
+	err = decoder.Decode(buf, &updatedAgency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	utilities.UpdateIfNotBlank(&agency.Name, updatedAgency.Name)
+	utilities.UpdateIfNotBlank(&agency.AddressLine1, updatedAgency.AddressLine1)
+	utilities.UpdateIfNotBlank(&agency.AddressLine2, updatedAgency.AddressLine2)
+	utilities.UpdateIfNotBlank(&agency.AddressCity, updatedAgency.AddressCity)
+	utilities.UpdateIfNotBlank(&agency.AddressState, updatedAgency.AddressState)
+	utilities.UpdateIfNotBlank(&agency.AddressPostalCode, updatedAgency.AddressPostalCode)
+	utilities.UpdateIfNotBlank(&agency.AddressCountry, updatedAgency.AddressCountry)
+
+	// The email domain is what users are matched to agencies with so only
+	// admins are able to change it
+	updatedAgency.Email = strings.ToLower(updatedAgency.Email)
+	if updatedAgency.Email != "" && updatedAgency.Email != agency.Email {
+		err = policy.Authorize(c, currentUser, policy.ActionUpdate, policy.Collection("Agency"))
+		if err != nil {
+			return agency, nil, err
+		}
+		agency.Email = updatedAgency.Email
+	}
+
+	if len(updatedAgency.Administrators) > 0 {
+		for i := 0; i < len(updatedAgency.Administrators); i++ {
+			user, err := getUserUnauthorized(c, r, updatedAgency.Administrators[i])
+			if err != nil || !int64InSlice(agency.Id, user.Employers) {
+				return agency, nil, errors.New("Administrators have to be employees of the agency")
+			}
+		}
+		agency.Administrators = updatedAgency.Administrators
+	}
+
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	return agency, nil, nil
+}
+
+/*
+* Action methods
+ */
+
+func RemoveEmployeeFromAgency(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	err = requireVerifiedDomain(c, currentUser, agency)
+	if err != nil {
+		return agency, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyEmployee models.AgencyEmployee
+	err = decoder.Decode(buf, &agencyEmployee)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	user, err := getUserUnauthorized(c, r, agencyEmployee.UserId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	if !int64InSlice(agency.Id, user.Employers) {
+		return agency, nil, errors.New("User is not an employee of this agency")
+	}
+
+	if agency.IsAdministrator(user.Id) && len(agency.Administrators) == 1 {
+		return agency, nil, errors.New("An agency needs to have at least one administrator")
+	}
+
+	// Keep track of the agency so the user is not added back to it from
+	// their email domain
+	employers := []int64{}
+	for i := 0; i < len(user.Employers); i++ {
+		if user.Employers[i] != agency.Id {
+			employers = append(employers, user.Employers[i])
+		}
+	}
+	user.Employers = employers
+	if !int64InSlice(agency.Id, user.PastEmployers) {
+		user.PastEmployers = append(user.PastEmployers, agency.Id)
+	}
+	SaveUser(c, r, &user)
+
+	if agency.IsAdministrator(user.Id) {
+		agency.RemoveAdministrator(user.Id)
+		_, err = agency.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return agency, nil, err
+		}
+	}
+
+	return agency, nil, nil
+}
+
+func AddClientToAgency(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyClient models.AgencyClient
+	err = decoder.Decode(buf, &agencyClient)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	client, err := getClient(c, agencyClient.ClientId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	// Agencies can only add clients that the current user can update
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, client)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	if agency.HasClient(client.Id) {
+		return agency, nil, errors.New("Client is already a part of this agency")
+	}
+
+	agency.Clients = append(agency.Clients, client.Id)
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	return agency, nil, nil
+}
+
+// Makes a team that asked to join an agency part of it. Its members take
+// seats on the plan of the agency.
+func ApproveAgencyTeam(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	agency, team, err := getAgencyTeamRequest(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	team.AgencyId = agency.Id
+	team.RequestedAgencyId = 0
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamAgencyApprove, team, nil, map[string]int64{"agency": agency.Id})
+
+	err = syncAgencySeats(c, r, agency)
+	if err != nil {
+		return team, nil, err
+	}
+
+	return team, nil, nil
+}
+
+func RejectAgencyTeam(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	agency, team, err := getAgencyTeamRequest(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	team.RequestedAgencyId = 0
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamAgencyReject, team, map[string]int64{"agency": agency.Id}, nil)
+
+	return team, nil, nil
+}
+
+// Merges a duplicate agency (usually created from a different email domain
+// of the same firm) into this agency. The duplicate agency is deleted.
+func MergeAgencies(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyMerge models.AgencyMerge
+	err = decoder.Decode(buf, &agencyMerge)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	if agencyMerge.AgencyId == agency.Id {
+		return agency, nil, errors.New("Can't merge an agency with itself")
+	}
+
+	duplicateAgency, err := getAgency(c, agencyMerge.AgencyId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, duplicateAgency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	// Merging moves every user of the duplicate agency, so both agencies
+	// have to own their domains
+	err = requireVerifiedDomain(c, currentUser, agency)
+	if err != nil {
+		return agency, nil, err
+	}
+
+	err = requireVerifiedDomain(c, currentUser, duplicateAgency)
+	if err != nil {
+		return agency, nil, err
+	}
+
+	// An agency can only have one plan. The plan of the duplicate agency
+	// has to end before it can be merged into an agency with a plan.
+	duplicateBilling, duplicateBillingErr := getAgencyBilling(c, duplicateAgency)
+	if duplicateBillingErr == nil && agency.BillingId != 0 && duplicateBilling.IsActiveSubscription() {
+		return agency, nil, errors.New("Both agencies have a plan. Please cancel the plan of the agency you are merging first")
+	}
+
+	// Move the employees of the duplicate agency over
+	ks, err := datastore.NewQuery("User").Filter("Employers =", duplicateAgency.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	var users []models.User
+	users = make([]models.User, len(ks))
+	err = nds.GetMulti(c, ks, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	for i := 0; i < len(users); i++ {
+		users[i].Format(ks[i], "users")
+		users[i].Employers = replaceEmployer(users[i].Employers, duplicateAgency.Id, agency.Id)
+		// Users opt into the single sign-on of the agency again
+		if users[i].SSOAgencyId == duplicateAgency.Id {
+			users[i].SSOAgencyId = 0
+		}
+		// The directory of the duplicate agency stops managing them. Users
+		// that it deactivated stay deactivated.
+		if users[i].SCIMAgencyId == duplicateAgency.Id {
+			users[i].SCIMAgencyId = 0
+		}
+		SaveUser(c, r, &users[i])
+	}
+
+	// Move the teams of the duplicate agency over
+	ks, err = datastore.NewQuery("Team").Filter("AgencyId =", duplicateAgency.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	var teams []models.Team
+	teams = make([]models.Team, len(ks))
+	err = nds.GetMulti(c, ks, teams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	for i := 0; i < len(teams); i++ {
+		teams[i].Format(ks[i], "teams")
+		teams[i].AgencyId = agency.Id
+		teams[i].Save(c)
+	}
+
+	// Teams that asked to join the duplicate agency ask this agency instead
+	ks, err = datastore.NewQuery("Team").Filter("RequestedAgencyId =", duplicateAgency.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	teams = make([]models.Team, len(ks))
+	err = nds.GetMulti(c, ks, teams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	for i := 0; i < len(teams); i++ {
+		teams[i].Format(ks[i], "teams")
+		teams[i].RequestedAgencyId = agency.Id
+		teams[i].Save(c)
+	}
+
+	// Keep the email domains of the duplicate agency so new users from
+	// them are added to this agency
+	duplicateEmails := append([]string{duplicateAgency.Email}, duplicateAgency.AlternateEmails...)
+	for i := 0; i < len(duplicateEmails); i++ {
+		if duplicateEmails[i] != agency.Email && !stringInSlice(duplicateEmails[i], agency.AlternateEmails) {
+			agency.AlternateEmails = append(agency.AlternateEmails, duplicateEmails[i])
+		}
+	}
+
+	for i := 0; i < len(duplicateAgency.VerifiedDomains); i++ {
+		if !agency.IsDomainVerified(duplicateAgency.VerifiedDomains[i]) {
+			agency.VerifiedDomains = append(agency.VerifiedDomains, duplicateAgency.VerifiedDomains[i])
+		}
+	}
+
+	for i := 0; i < len(duplicateAgency.Administrators); i++ {
+		if !agency.IsAdministrator(duplicateAgency.Administrators[i]) {
+			agency.Administrators = append(agency.Administrators, duplicateAgency.Administrators[i])
+		}
+	}
+
+	for i := 0; i < len(duplicateAgency.Clients); i++ {
+		if !agency.HasClient(duplicateAgency.Clients[i]) {
+			agency.Clients = append(agency.Clients, duplicateAgency.Clients[i])
+		}
+	}
+
+	// The plan of the duplicate agency is moved over when this agency has
+	// none
+	if agency.BillingId == 0 && duplicateBillingErr == nil {
+		duplicateBilling.AgencyId = agency.Id
+		_, err = duplicateBilling.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return agency, nil, err
+		}
+		agency.BillingId = duplicateBilling.Id
+	}
+
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	_, err = duplicateAgency.Delete(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return agency, nil, err
+	}
+
+	// The teams of the duplicate agency take seats on the plan
+	err = syncAgencySeats(c, r, agency)
+	if err != nil {
+		return agency, nil, err
+	}
+
+	return agency, nil, nil
+}
+
 /*
 * Filter methods
  */
@@ -195,8 +865,12 @@ func FilterAgencyByEmail(c context.Context, email string) (models.Agency, error)
 	// Get the id of the current agency
 	agency, err := filterAgency(c, "Email", email)
 	if err != nil {
-		log.Errorf(c, "%v", err)
-		return models.Agency{}, err
+		// The email domain could belong to an agency that has been merged
+		agency, err = filterAgency(c, "AlternateEmails", email)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.Agency{}, err
+		}
 	}
 
 	return agency, nil
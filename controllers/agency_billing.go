@@ -0,0 +1,584 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/usage"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getAgencyBilling(c context.Context, agency models.Agency) (models.Billing, error) {
+	if agency.BillingId == 0 {
+		return models.Billing{}, errors.New("No billing for this agency")
+	}
+
+	var agencyBilling models.Billing
+	billingId := datastore.NewKey(c, "Billing", "", agency.BillingId, nil)
+	err := nds.Get(c, billingId, &agencyBilling)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Billing{}, err
+	}
+
+	agencyBilling.Format(billingId, "billings")
+
+	if !agencyBilling.IsAgency {
+		return models.Billing{}, errors.New("No billing for this agency")
+	}
+
+	return agencyBilling, nil
+}
+
+// The agency billing that a user has a seat on
+func getAgencyBillingForUser(c context.Context, user models.User) (models.Agency, models.Billing, error) {
+	for i := 0; i < len(user.Employers); i++ {
+		agency, err := getAgency(c, user.Employers[i])
+		if err != nil || agency.BillingId == 0 {
+			continue
+		}
+
+		agencyBilling, err := getAgencyBilling(c, agency)
+		if err == nil && agencyBilling.HasSeat(user.Id) && agencyBilling.IsActiveSubscription() {
+			return agency, agencyBilling, nil
+		}
+	}
+
+	return models.Agency{}, models.Billing{}, errors.New("User does not have a seat on an agency plan")
+}
+
+// Members of every team of an agency, and the users that its directory
+// manages. Teams are only part of an agency when their creator is a
+// verified employee of it, an administrator of the agency approved them
+// or its directory provisioned them.
+func getAgencyMembers(c context.Context, agency models.Agency) ([]models.User, error) {
+	ks, err := datastore.NewQuery("Team").Filter("AgencyId =", agency.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	teams := make([]models.Team, len(ks))
+	err = nds.GetMulti(c, ks, teams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	userKeys := []*datastore.Key{}
+	memberIds := []int64{}
+	for i := 0; i < len(teams); i++ {
+		for x := 0; x < len(teams[i].Members); x++ {
+			memberIds = append(memberIds, teams[i].Members[x])
+		}
+		for x := 0; x < len(teams[i].ReadOnlyMembers); x++ {
+			memberIds = append(memberIds, teams[i].ReadOnlyMembers[x])
+		}
+	}
+
+	directoryKeys, err := datastore.NewQuery("User").Filter("SCIMAgencyId =", agency.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+	for i := 0; i < len(directoryKeys); i++ {
+		memberIds = append(memberIds, directoryKeys[i].IntID())
+	}
+
+	addedIds := []int64{}
+	for i := 0; i < len(memberIds); i++ {
+		if !int64InSlice(memberIds[i], addedIds) {
+			addedIds = append(addedIds, memberIds[i])
+			userKeys = append(userKeys, datastore.NewKey(c, "User", "", memberIds[i], nil))
+		}
+	}
+
+	users := make([]models.User, len(userKeys))
+	err = nds.GetMulti(c, userKeys, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	for i := 0; i < len(users); i++ {
+		users[i].Format(userKeys[i], "users")
+	}
+
+	return users, nil
+}
+
+// Every member of an agency takes a seat, unless they are banned, were
+// deactivated by the directory of the agency or pay for a plan of their
+// own
+func getAgencySeatUserIds(c context.Context, r *http.Request, agency models.Agency) ([]int64, error) {
+	members, err := getAgencyMembers(c, agency)
+	if err != nil {
+		return []int64{}, err
+	}
+
+	seatUserIds := []int64{}
+	for i := 0; i < len(members); i++ {
+		if !members[i].IsBanned && !members[i].SCIMDeactivated && !hasOwnPaidPlan(c, r, members[i]) {
+			seatUserIds = append(seatUserIds, members[i].Id)
+		}
+	}
+
+	return seatUserIds, nil
+}
+
+func getAgencyPlan(c context.Context, r *http.Request, agencyBilling models.Billing) models.AgencyPlan {
+	plan := billing.GetBillingPlan(c, agencyBilling)
+
+	agencyPlan := models.AgencyPlan{}
+	agencyPlan.PlanId = plan.PlanId
+	agencyPlan.PlanName = plan.Name
+	agencyPlan.Seats = agencyBilling.Seats
+	agencyPlan.MinimumSeats = agencyBilling.MinimumSeats
+	agencyPlan.Expires = agencyBilling.Expires
+	agencyPlan.IsCancel = agencyBilling.IsCancel
+	agencyPlan.IsPastDue = agencyBilling.IsPastDue
+	agencyPlan.DailyEmailsAllowed = plan.DailyEmailsAllowed * agencyBilling.Seats
+	agencyPlan.EmailsSentToday = getAgencyDailyEmail(c, r, agencyBilling)
+	return agencyPlan
+}
+
+// Emails sent today by everyone with a seat, from the counter of the
+// agency
+func getAgencyDailyEmail(c context.Context, r *http.Request, agencyBilling models.Billing) int {
+	emailsSent, _, err := usage.Get(c, usage.Agency(agencyBilling), models.UsageEmailsSent)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0
+	}
+	return emailsSent
+}
+
+// Users with a plan or a trial of their own keep their access when they
+// lose their seat
+func hasOwnActiveBilling(c context.Context, r *http.Request, user models.User) bool {
+	userBilling, err := GetUserBilling(c, r, user)
+	if err != nil {
+		return false
+	}
+	return !userBilling.IsAgency && userBilling.IsActiveSubscription()
+}
+
+// Users that pay for a plan of their own don't take a seat
+func hasOwnPaidPlan(c context.Context, r *http.Request, user models.User) bool {
+	userBilling, err := GetUserBilling(c, r, user)
+	if err != nil {
+		return false
+	}
+	return !userBilling.IsAgency && !userBilling.IsOnTrial && !userBilling.IsCancel && userBilling.StripePlanId != "" && userBilling.StripePlanId != "free" && userBilling.IsActiveSubscription()
+}
+
+/*
+* Update methods
+ */
+
+// Gives a user access through the plan of their agency when the agency
+// pays for seats. Returns if the user changed. The caller saves the user
+// and syncs the seats.
+func grantAgencySeat(c context.Context, agency models.Agency, user *models.User) bool {
+	if user.IsActive || user.IsBanned || user.SCIMDeactivated {
+		return false
+	}
+
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil || !agencyBilling.IsActiveSubscription() || agencyBilling.IsCancel {
+		return false
+	}
+
+	user.IsActive = true
+	return true
+}
+
+// Takes away the access that a user had through the plan of their agency,
+// unless they have a plan of their own or a seat at another agency.
+// Returns if the user changed. The caller saves the user and syncs the
+// seats.
+func releaseAgencySeat(c context.Context, r *http.Request, user *models.User) bool {
+	if !user.IsActive || hasOwnActiveBilling(c, r, *user) {
+		return false
+	}
+
+	_, _, err := getAgencyBillingForUser(c, *user)
+	if err == nil {
+		return false
+	}
+
+	user.IsActive = false
+	return true
+}
+
+// Grants the seats of users, or takes them away
+func updateAgencySeatAccessForUsers(c context.Context, r *http.Request, agency models.Agency, userIds []int64, hasAccess bool) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	userKeys := []*datastore.Key{}
+	for i := 0; i < len(userIds); i++ {
+		userKeys = append(userKeys, datastore.NewKey(c, "User", "", userIds[i], nil))
+	}
+
+	users := make([]models.User, len(userKeys))
+	err := nds.GetMulti(c, userKeys, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	for i := 0; i < len(users); i++ {
+		users[i].Format(userKeys[i], "users")
+
+		userChanged := false
+		if hasAccess {
+			userChanged = grantAgencySeat(c, agency, &users[i])
+		} else {
+			userChanged = releaseAgencySeat(c, r, &users[i])
+		}
+
+		if userChanged {
+			SaveUser(c, r, &users[i])
+		}
+	}
+
+	return nil
+}
+
+// Members that had a seat keep it, and new members take the seats that
+// are left, when there are more members than seats that are paid for
+func getPaidSeatUserIds(previousSeatUserIds []int64, seatUserIds []int64, paidSeats int) []int64 {
+	paidSeatUserIds := []int64{}
+	for i := 0; i < len(seatUserIds); i++ {
+		if len(paidSeatUserIds) < paidSeats && int64InSlice(seatUserIds[i], previousSeatUserIds) {
+			paidSeatUserIds = append(paidSeatUserIds, seatUserIds[i])
+		}
+	}
+	for i := 0; i < len(seatUserIds); i++ {
+		if len(paidSeatUserIds) < paidSeats && !int64InSlice(seatUserIds[i], paidSeatUserIds) {
+			paidSeatUserIds = append(paidSeatUserIds, seatUserIds[i])
+		}
+	}
+	return paidSeatUserIds
+}
+
+// Updates who has a seat, and the number of seats in Stripe when the
+// number of members changes. When Stripe does not accept the new number
+// of seats, only the seats that are paid for are given out and the error
+// is returned. Members that lose their seat lose the access it gave them.
+func syncAgencySeats(c context.Context, r *http.Request, agency models.Agency) error {
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil {
+		return nil
+	}
+
+	seatUserIds, err := getAgencySeatUserIds(c, r, agency)
+	if err != nil {
+		return err
+	}
+
+	seats := len(seatUserIds)
+	if seats < agencyBilling.MinimumSeats {
+		seats = agencyBilling.MinimumSeats
+	}
+	if seats < 1 {
+		seats = 1
+	}
+
+	var seatsErr error
+	if seats != agencyBilling.Seats && agencyBilling.IsActiveSubscription() && !agencyBilling.IsCancel {
+		seatsErr = billing.UpdateAgencySeats(r, agency, &agencyBilling, seats)
+		if seatsErr != nil {
+			log.Errorf(c, "%v", seatsErr)
+			seatUserIds = getPaidSeatUserIds(agencyBilling.SeatUserIds, seatUserIds, agencyBilling.Seats)
+		}
+	}
+
+	removedUserIds := []int64{}
+	for i := 0; i < len(agencyBilling.SeatUserIds); i++ {
+		if !int64InSlice(agencyBilling.SeatUserIds[i], seatUserIds) {
+			removedUserIds = append(removedUserIds, agencyBilling.SeatUserIds[i])
+		}
+	}
+
+	agencyBilling.SeatUserIds = seatUserIds
+	_, err = agencyBilling.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	err = UpdateAgencySeatAccess(c, r, agencyBilling)
+	if err != nil {
+		return err
+	}
+
+	err = updateAgencySeatAccessForUsers(c, r, agency, removedUserIds, false)
+	if err != nil {
+		return err
+	}
+
+	return seatsErr
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetAgencyPlan(c context.Context, r *http.Request, id string) (models.AgencyPlan, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	return getAgencyPlan(c, r, agencyBilling), nil, nil
+}
+
+// Previews the prorated cost of changing the number of seats
+func PreviewAgencySeats(c context.Context, r *http.Request, id string) (models.AgencySeats, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencySeats{}, nil, err
+	}
+
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil {
+		return models.AgencySeats{}, nil, err
+	}
+
+	seats, err := strconv.Atoi(r.URL.Query().Get("seats"))
+	if err != nil || seats < 1 {
+		return models.AgencySeats{}, nil, errors.New("Please provide the number of seats")
+	}
+
+	// Agencies pay for at least one seat for each member
+	if seats < len(agencyBilling.SeatUserIds) {
+		seats = len(agencyBilling.SeatUserIds)
+	}
+
+	cost, err := billing.PreviewAgencySeats(r, &agencyBilling, seats)
+	if err != nil {
+		return models.AgencySeats{}, nil, err
+	}
+
+	return models.AgencySeats{
+		Seats:         seats,
+		ProrationCost: cost,
+	}, nil, nil
+}
+
+/*
+* Create methods
+ */
+
+func AddPlanToAgency(c context.Context, r *http.Request, id string) (models.AgencyPlan, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyNewPlan models.AgencyNewPlan
+	err = decoder.Decode(buf, &agencyNewPlan)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencyPlan{}, nil, err
+	}
+
+	if agencyNewPlan.Duration != "monthly" && agencyNewPlan.Duration != "annually" {
+		return models.AgencyPlan{}, nil, errors.New("Duration is invalid")
+	}
+
+	if agencyNewPlan.MinimumSeats < 0 {
+		return models.AgencyPlan{}, nil, errors.New("Seats can not be negative")
+	}
+
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil {
+		agencyBilling = models.Billing{}
+	}
+
+	seatUserIds, err := getAgencySeatUserIds(c, r, agency)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	seats := len(seatUserIds)
+	if seats < agencyNewPlan.MinimumSeats {
+		seats = agencyNewPlan.MinimumSeats
+	}
+
+	err = billing.AddPlanToAgency(r, agency, &agencyBilling, strings.ToLower(agencyNewPlan.Plan), agencyNewPlan.Duration, agencyNewPlan.Token, seats)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	agencyBilling.MinimumSeats = agencyNewPlan.MinimumSeats
+	agencyBilling.SeatUserIds = seatUserIds
+	_, err = agencyBilling.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencyPlan{}, nil, err
+	}
+
+	if agency.BillingId != agencyBilling.Id {
+		agency.BillingId = agencyBilling.Id
+		_, err = agency.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.AgencyPlan{}, nil, err
+		}
+	}
+
+	// Members whose own plan ran out get access through the agency
+	err = UpdateAgencySeatAccess(c, r, agencyBilling)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	return getAgencyPlan(c, r, agencyBilling), nil, nil
+}
+
+/*
+* Update methods
+ */
+
+// Admins can buy seats before members join. The number of seats never
+// goes below the number of members.
+func UpdateAgencySeats(c context.Context, r *http.Request, id string) (models.AgencyPlan, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	agencyBilling, err := getAgencyBilling(c, agency)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencySeats models.AgencySeats
+	err = decoder.Decode(buf, &agencySeats)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencyPlan{}, nil, err
+	}
+
+	if agencySeats.Seats < 0 {
+		return models.AgencyPlan{}, nil, errors.New("Seats can not be negative")
+	}
+
+	agencyBilling.MinimumSeats = agencySeats.Seats
+	_, err = agencyBilling.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencyPlan{}, nil, err
+	}
+
+	err = syncAgencySeats(c, r, agency)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	agencyBilling, err = getAgencyBilling(c, agency)
+	if err != nil {
+		return models.AgencyPlan{}, nil, err
+	}
+
+	return getAgencyPlan(c, r, agencyBilling), nil, nil
+}
+
+// Everyone with a seat has access while the plan of the agency is paid
+// for. When the plan ends, is deleted or is locked, members without a plan
+// of their own lose their access.
+func UpdateAgencySeatAccess(c context.Context, r *http.Request, agencyBilling models.Billing) error {
+	if len(agencyBilling.SeatUserIds) == 0 {
+		return nil
+	}
+
+	agency, err := getAgency(c, agencyBilling.AgencyId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	return updateAgencySeatAccessForUsers(c, r, agency, agencyBilling.SeatUserIds, agencyBilling.IsActiveSubscription())
+}
+
+// Called when the members of a team change. New members only get access
+// once Stripe has accepted their seats.
+func SyncAgencySeatsForTeam(c context.Context, r *http.Request, team models.Team) error {
+	if team.AgencyId == 0 {
+		return nil
+	}
+
+	agency, err := getAgency(c, team.AgencyId)
+	if err != nil {
+		return nil
+	}
+
+	return syncAgencySeats(c, r, agency)
+}
+
+// Agencies that pay for seats, for the syncAgencySeats task
+func SyncAllAgencySeats(c context.Context, r *http.Request) error {
+	ks, err := datastore.NewQuery("Billing").Filter("IsAgency =", true).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	billings := make([]models.Billing, len(ks))
+	err = nds.GetMulti(c, ks, billings)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	for i := 0; i < len(billings); i++ {
+		agency, err := getAgency(c, billings[i].AgencyId)
+		if err != nil {
+			continue
+		}
+
+		err = syncAgencySeats(c, r, agency)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+
+	return nil
+}
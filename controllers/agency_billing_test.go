@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPaidSeatUserIds(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []int64
+		current  []int64
+		seats    int
+		paid     []int64
+	}{
+		{name: "enough seats", previous: []int64{1, 2}, current: []int64{1, 2, 3}, seats: 3, paid: []int64{1, 2, 3}},
+		{name: "members that had a seat keep it", previous: []int64{2, 3}, current: []int64{1, 2, 3}, seats: 2, paid: []int64{2, 3}},
+		{name: "new members take the seats that are left", previous: []int64{2}, current: []int64{1, 2, 3}, seats: 2, paid: []int64{2, 1}},
+		{name: "members that left don't keep a seat", previous: []int64{4, 5}, current: []int64{1, 2}, seats: 1, paid: []int64{1}},
+		{name: "no seats", previous: []int64{1}, current: []int64{1}, seats: 0, paid: []int64{}},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		paid := getPaidSeatUserIds(tests[i].previous, tests[i].current, tests[i].seats)
+		if !reflect.DeepEqual(paid, tests[i].paid) {
+			t.Errorf("%s: got %v, want %v", tests[i].name, paid, tests[i].paid)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+
+	"github.com/news-ai/web/utilities"
+)
+
+const domainVerificationPrefix = "newsai-domain-verification="
+
+// Anyone can sign up with these, so no agency can own them
+var publicEmailDomains = []string{
+	"gmail.com",
+	"googlemail.com",
+	"yahoo.com",
+	"yahoo.co.uk",
+	"ymail.com",
+	"hotmail.com",
+	"hotmail.co.uk",
+	"outlook.com",
+	"live.com",
+	"msn.com",
+	"aol.com",
+	"icloud.com",
+	"me.com",
+	"mac.com",
+	"protonmail.com",
+	"proton.me",
+	"gmx.com",
+	"gmx.net",
+	"mail.com",
+	"yandex.com",
+	"zoho.com",
+	"fastmail.com",
+	"hey.com",
+	"qq.com",
+	"163.com",
+}
+
+type dnsTXTResponse struct {
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+/*
+* Private methods
+ */
+
+func isPublicEmailDomain(domain string) bool {
+	return stringInSlice(strings.ToLower(domain), publicEmailDomains)
+}
+
+func domainVerificationRecord(agency models.Agency) string {
+	return domainVerificationPrefix + agency.DomainVerificationToken
+}
+
+// App Engine can't make DNS queries itself so they go through DNS over
+// HTTPS
+func lookupTXTRecords(c context.Context, domain string) ([]string, error) {
+	client := urlfetch.Client(c)
+	resp, err := client.Get("https://dns.google/resolve?type=TXT&name=" + url.QueryEscape(domain))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New("Could not look up the DNS records of " + domain)
+	}
+
+	var dnsResponse dnsTXTResponse
+	err = json.NewDecoder(resp.Body).Decode(&dnsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []string{}
+	for i := 0; i < len(dnsResponse.Answer); i++ {
+		// TXT records have type 16
+		if dnsResponse.Answer[i].Type == 16 {
+			records = append(records, strings.Trim(dnsResponse.Answer[i].Data, "\""))
+		}
+	}
+	return records, nil
+}
+
+func isPlatformAdmin(c context.Context, user models.User) bool {
+	return policy.Can(c, user, policy.ActionManage, policy.Collection("Agency"))
+}
+
+// Single sign-on, SCIM and managing the employees of an agency take over
+// the users of an email domain, so they need the agency to have proven
+// that it owns the domain, or a platform admin to set them up. Public
+// email domains are never allowed.
+func requireVerifiedDomain(c context.Context, currentUser models.User, agency models.Agency) error {
+	if isPublicEmailDomain(agency.Email) {
+		return errors.New("Agencies with a public email domain can't manage their users")
+	}
+
+	if agency.IsDomainVerified(agency.Email) || isPlatformAdmin(c, currentUser) {
+		return nil
+	}
+	return errors.New("Please verify that your agency owns " + agency.Email + " first")
+}
+
+// If single sign-on and SCIM apply to a user of an agency
+func isUserInVerifiedDomain(agency models.Agency, user models.User) bool {
+	emailDomain, err := utilities.ExtractEmailExtension(strings.ToLower(user.Email))
+	if err != nil {
+		return false
+	}
+	return !isPublicEmailDomain(emailDomain) && agency.IsDomainVerified(emailDomain)
+}
+
+// If a user works for an agency that has verified the email domain of
+// the user
+func isVerifiedEmployee(c context.Context, agencyId int64, user models.User) bool {
+	if agencyId == 0 || !int64InSlice(agencyId, user.Employers) {
+		return false
+	}
+
+	agency, err := getAgency(c, agencyId)
+	if err != nil {
+		return false
+	}
+	return isUserInVerifiedDomain(agency, user)
+}
+
+// The first agency that a user works for that has verified the email
+// domain of the user
+func getVerifiedEmployer(c context.Context, user models.User) (models.Agency, error) {
+	for i := 0; i < len(user.Employers); i++ {
+		agency, err := getAgency(c, user.Employers[i])
+		if err == nil && isUserInVerifiedDomain(agency, user) {
+			return agency, nil
+		}
+	}
+	return models.Agency{}, errors.New("User does not work for an agency with a verified domain")
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// The DNS TXT record that proves the agency owns its email domain
+func GetAgencyDomainVerification(c context.Context, r *http.Request, id string) (models.AgencyDomainVerification, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencyDomainVerification{}, nil, err
+	}
+
+	if isPublicEmailDomain(agency.Email) {
+		return models.AgencyDomainVerification{}, nil, errors.New("Public email domains can't be verified")
+	}
+
+	if agency.DomainVerificationToken == "" {
+		agency.DomainVerificationToken = utilities.RandToken()
+		_, err = agency.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.AgencyDomainVerification{}, nil, err
+		}
+	}
+
+	return models.AgencyDomainVerification{
+		Domain:   agency.Email,
+		Record:   domainVerificationRecord(agency),
+		Verified: agency.IsDomainVerified(agency.Email),
+	}, nil, nil
+}
+
+/*
+* Action methods
+ */
+
+// Verifies one of the email domains of an agency by its DNS TXT record.
+// Platform admins can verify domains without the record.
+func VerifyAgencyDomain(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencyDomain models.AgencyDomainVerification
+	err = decoder.Decode(buf, &agencyDomain)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	domain := strings.ToLower(agencyDomain.Domain)
+	if domain == "" {
+		domain = agency.Email
+	}
+
+	if !agency.HasEmailDomain(domain) {
+		return agency, nil, errors.New("This email domain is not part of the agency")
+	}
+
+	if isPublicEmailDomain(domain) {
+		return agency, nil, errors.New("Public email domains can't be verified")
+	}
+
+	if agency.IsDomainVerified(domain) {
+		return agency, nil, nil
+	}
+
+	if !isPlatformAdmin(c, currentUser) {
+		if agency.DomainVerificationToken == "" {
+			return agency, nil, errors.New("Please get the DNS record for your domain first")
+		}
+
+		records, err := lookupTXTRecords(c, domain)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return agency, nil, err
+		}
+
+		if !stringInSlice(domainVerificationRecord(agency), records) {
+			return agency, nil, errors.New("We could not find the DNS TXT record " + domainVerificationRecord(agency) + " on " + domain)
+		}
+	}
+
+	agency.VerifiedDomains = append(agency.VerifiedDomains, domain)
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	return agency, nil, nil
+}
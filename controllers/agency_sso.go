@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/web/utilities"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// Gets an agency without checking the current user. Only used while
+// logging in through single sign-on.
+func GetAgencySSOById(c context.Context, id int64) (models.Agency, error) {
+	agency, err := getAgency(c, id)
+	if err != nil {
+		return models.Agency{}, err
+	}
+
+	if !agency.HasSSO() {
+		return models.Agency{}, errors.New("Single sign-on is not set up for this agency")
+	}
+
+	return agency, nil
+}
+
+// Finds the agency with single sign-on for the email domain of a user
+func GetAgencySSOForEmail(c context.Context, email string) (models.Agency, error) {
+	agencyEmail, err := utilities.ExtractEmailExtension(strings.ToLower(email))
+	if err != nil {
+		return models.Agency{}, err
+	}
+
+	agency, err := FilterAgencyByEmail(c, agencyEmail)
+	if err != nil {
+		return models.Agency{}, err
+	}
+
+	if !agency.HasSSO() || isPublicEmailDomain(agencyEmail) || !agency.IsDomainVerified(agencyEmail) {
+		return models.Agency{}, errors.New("Single sign-on is not set up for this email domain")
+	}
+
+	return agency, nil
+}
+
+// Nobody in the verified domain of an agency that enforces single sign-on
+// can log in any other way, whether or not they have opted into it.
+func IsSSOEnforcedForEmail(c context.Context, email string) bool {
+	agency, err := GetAgencySSOForEmail(c, email)
+	if err != nil {
+		return false
+	}
+	return agency.SSOEnforced
+}
+
+// Existing users can only log in through the single sign-on of an agency
+// if their email is in one of its verified domains, and either the agency
+// enforces it or they work at the agency and have opted into it. Otherwise
+// anyone who controls the identity provider could log in as them.
+func CanLogInWithSSO(agency models.Agency, user models.User) bool {
+	if !isUserInVerifiedDomain(agency, user) {
+		return false
+	}
+	if agency.SSOEnforced {
+		return true
+	}
+	return int64InSlice(agency.Id, user.Employers) && user.SSOAgencyId == agency.Id
+}
+
+/*
+* Update methods
+ */
+
+func UpdateAgencySSO(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var agencySSO models.AgencySSO
+	err = decoder.Decode(buf, &agencySSO)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	// Single sign-on can always be turned off
+	if agencySSO.Type != "" {
+		err = requireVerifiedDomain(c, currentUser, agency)
+		if err != nil {
+			return agency, nil, err
+		}
+	}
+
+	switch agencySSO.Type {
+	case models.AgencySSOSAML:
+		if agencySSO.SAMLMetadataURL == "" && agencySSO.SAMLMetadata == "" {
+			return agency, nil, errors.New("Please provide the SAML metadata of your identity provider")
+		}
+
+		if agencySSO.SAMLMetadataURL != "" && !validHTTPSURL(agencySSO.SAMLMetadataURL) {
+			return agency, nil, errors.New("The SAML metadata URL has to be a https URL")
+		}
+	case models.AgencySSOOIDC:
+		if !validHTTPSURL(agencySSO.OIDCIssuer) || agencySSO.OIDCClientId == "" {
+			return agency, nil, errors.New("Please provide a https issuer and client id for your identity provider")
+		}
+
+		// Keep the secret that is already there if a new one is not sent
+		if agencySSO.OIDCClientSecret == "" && agency.OIDCClientSecret == "" {
+			return agency, nil, errors.New("Please provide the client secret for your identity provider")
+		}
+	case "":
+		// Turns single sign-on off
+		agencySSO.Enforced = false
+	default:
+		return agency, nil, errors.New("Single sign-on has to be either saml or oidc")
+	}
+
+	agency.SSOType = agencySSO.Type
+	agency.SSOEnforced = agencySSO.Enforced
+	agency.SAMLMetadataURL = agencySSO.SAMLMetadataURL
+	agency.SAMLMetadata = agencySSO.SAMLMetadata
+	agency.OIDCIssuer = strings.TrimRight(agencySSO.OIDCIssuer, "/")
+	agency.OIDCClientId = agencySSO.OIDCClientId
+	utilities.UpdateIfNotBlank(&agency.OIDCClientSecret, agencySSO.OIDCClientSecret)
+
+	if agency.SSOType != models.AgencySSOOIDC {
+		agency.OIDCClientSecret = ""
+	}
+
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	return agency, nil, nil
+}
+
+/*
+* Action methods
+ */
+
+// Lets the current user log in through the single sign-on of an agency
+// they work at. Logging in with their password, Google or Outlook first is
+// what proves that the account is theirs.
+func OptIntoAgencySSO(c context.Context, r *http.Request, id string) (models.User, interface{}, error) {
+	agencyId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	agency, err := GetAgencySSOById(c, agencyId)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	if !int64InSlice(agency.Id, currentUser.Employers) || !isUserInVerifiedDomain(agency, currentUser) {
+		return models.User{}, nil, errors.New("You can only use the single sign-on of your own agency")
+	}
+
+	currentUser.SSOAgencyId = agency.Id
+	_, err = currentUser.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	return currentUser, nil, nil
+}
+
+// Makes sure a new user that logged in through the single sign-on of an
+// agency works at the agency and keeps using its single sign-on
+func ProvisionSSOUser(c context.Context, r *http.Request, agency models.Agency, user *models.User) error {
+	if int64InSlice(agency.Id, user.Employers) && user.SSOAgencyId == agency.Id {
+		return nil
+	}
+
+	if !int64InSlice(agency.Id, user.Employers) {
+		addEmployer(user, agency.Id)
+	}
+	user.SSOAgencyId = agency.Id
+
+	_, err := user.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
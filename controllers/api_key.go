@@ -0,0 +1,409 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/web/utilities"
+)
+
+/*
+* Private methods
+ */
+
+func validApiKeyScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(scopes); i++ {
+		if !stringInSlice(scopes[i], models.ApiKeyScopes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys from before users could have several keys are stored in plain
+// text on the user. They are moved to a hashed key with the full scope so
+// they can be listed and revoked like any other key.
+func migrateLegacyApiKey(c context.Context, r *http.Request, user *models.User) (models.ApiKey, error) {
+	legacyKey := user.ApiKey
+
+	// The key could have been moved before without the user being saved
+	ks, err := datastore.NewQuery("ApiKey").Filter("KeyHash =", hashSecret(legacyKey)).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, err
+	}
+	if len(ks) > 0 {
+		user.ApiKey = ""
+		return getApiKey(c, ks[0].IntID())
+	}
+
+	apiKey := models.ApiKey{}
+	apiKey.UserId = user.Id
+	apiKey.Name = "Api key"
+	apiKey.Scopes = []string{models.ApiKeyScopeFull}
+	apiKey.KeyHash = hashSecret(legacyKey)
+	if len(legacyKey) > 8 {
+		apiKey.Prefix = legacyKey[:8]
+	}
+
+	_, err = apiKey.Create(c, r, *user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, err
+	}
+
+	user.ApiKey = ""
+	return apiKey, nil
+}
+
+/*
+* Get methods
+ */
+
+func getApiKey(c context.Context, id int64) (models.ApiKey, error) {
+	if id == 0 {
+		return models.ApiKey{}, errors.New("datastore: no such entity")
+	}
+
+	var apiKey models.ApiKey
+	apiKeyId := datastore.NewKey(c, "ApiKey", "", id, nil)
+
+	err := nds.Get(c, apiKeyId, &apiKey)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, err
+	}
+
+	if !apiKey.Created.IsZero() {
+		apiKey.Format(apiKeyId, "apikeys")
+		return apiKey, nil
+	}
+	return models.ApiKey{}, errors.New("No api key by this id")
+}
+
+func getApiKeyForUser(c context.Context, r *http.Request, id string, keyId string) (models.ApiKey, models.User, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.ApiKey{}, models.User{}, err
+	}
+
+	currentKeyId, err := utilities.StringIdToInt(keyId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, models.User{}, err
+	}
+
+	apiKey, err := getApiKey(c, currentKeyId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, models.User{}, err
+	}
+
+	if apiKey.UserId != user.Id {
+		return models.ApiKey{}, models.User{}, errors.New("No api key by this id")
+	}
+
+	return apiKey, currentUser, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetApiKeysForUser(c context.Context, r *http.Request, id string) ([]models.ApiKey, interface{}, int, int, error) {
+	user, _, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return []models.ApiKey{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("ApiKey").Filter("UserId =", user.Id)
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.ApiKey{}, nil, 0, 0, err
+	}
+
+	var apiKeys []models.ApiKey
+	apiKeys = make([]models.ApiKey, len(ks))
+	err = nds.GetMulti(c, ks, apiKeys)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.ApiKey{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(apiKeys); i++ {
+		apiKeys[i].Format(ks[i], "apikeys")
+	}
+
+	return apiKeys, nil, len(apiKeys), 0, nil
+}
+
+// Finds the user that an api key belongs to. Keys are looked up by their
+// hash since the key itself is never stored.
+func GetUserFromApiKey(r *http.Request, key string) (models.User, models.ApiKey, error) {
+	c := appengine.NewContext(r)
+
+	ks, err := datastore.NewQuery("ApiKey").Filter("KeyHash =", hashSecret(key)).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, models.ApiKey{}, err
+	}
+
+	if len(ks) == 0 {
+		// Keys that were made before users could have several keys
+		user, err := GetUserByApiKey(c, key)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, models.ApiKey{}, err
+		}
+
+		if user.IsLoginDisabled() {
+			return models.User{}, models.ApiKey{}, errors.New("This user has been disabled")
+		}
+
+		apiKey, err := migrateLegacyApiKey(c, r, &user)
+		if err != nil {
+			return models.User{}, models.ApiKey{}, err
+		}
+
+		_, err = user.Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, models.ApiKey{}, err
+		}
+
+		if !apiKey.IsValid() {
+			return models.User{}, models.ApiKey{}, errors.New("This api key has been revoked or has expired")
+		}
+
+		return user, apiKey, nil
+	}
+
+	var apiKey models.ApiKey
+	err = nds.Get(c, ks[0], &apiKey)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, models.ApiKey{}, err
+	}
+	apiKey.Format(ks[0], "apikeys")
+
+	if !apiKey.IsValid() {
+		return models.User{}, models.ApiKey{}, errors.New("This api key has been revoked or has expired")
+	}
+
+	user, err := getUserUnauthorized(c, r, apiKey.UserId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, models.ApiKey{}, err
+	}
+
+	if user.IsLoginDisabled() {
+		return models.User{}, models.ApiKey{}, errors.New("This user has been disabled")
+	}
+
+	// Only write the last time the key was used every few minutes
+	if time.Since(apiKey.LastUsed) > 5*time.Minute {
+		apiKey.LastUsed = time.Now()
+		apiKey.Save(c)
+	}
+
+	return user, apiKey, nil
+}
+
+/*
+* Create methods
+ */
+
+// Moves the legacy api key of a user to a hashed key. Returns if the user
+// has to be saved.
+func MigrateLegacyApiKeyUnauthorized(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	if user.ApiKey == "" {
+		return false, nil
+	}
+
+	_, err := migrateLegacyApiKey(c, r, user)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func CreateApiKeyForUser(c context.Context, r *http.Request, id string) (models.ApiKey, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.ApiKey{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var apiKey models.ApiKey
+	err = decoder.Decode(buf, &apiKey)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	if apiKey.Name == "" {
+		return models.ApiKey{}, nil, errors.New("Please provide a name for the api key")
+	}
+
+	if len(apiKey.Scopes) == 0 {
+		apiKey.Scopes = []string{models.ApiKeyScopeReadOnly}
+	}
+
+	if !validApiKeyScopes(apiKey.Scopes) {
+		return models.ApiKey{}, nil, errors.New("Invalid api key scope")
+	}
+
+	if !apiKey.Expires.IsZero() && apiKey.Expires.Before(time.Now()) {
+		return models.ApiKey{}, nil, errors.New("The expiry of the api key has to be in the future")
+	}
+
+	key, err := generateSecret("nai_")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	apiKey.UserId = user.Id
+	apiKey.KeyHash = hashSecret(key)
+	apiKey.Prefix = key[:12]
+	apiKey.LastUsed = time.Time{}
+	apiKey.RevokedAt = time.Time{}
+	apiKey.RevokedBy = 0
+
+	_, err = apiKey.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	// This is the only time the key is shown to the user
+	apiKey.Key = key
+	return apiKey, nil, nil
+}
+
+/*
+* Update methods
+ */
+
+func UpdateApiKeyForUser(c context.Context, r *http.Request, id string, keyId string) (models.ApiKey, interface{}, error) {
+	apiKey, _, err := getApiKeyForUser(c, r, id, keyId)
+	if err != nil {
+		return models.ApiKey{}, nil, err
+	}
+
+	if apiKey.Revoked {
+		return apiKey, nil, errors.New("This api key has been revoked")
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var updatedApiKey models.ApiKey
+	err = decoder.Decode(buf, &updatedApiKey)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	utilities.UpdateIfNotBlank(&apiKey.Name, updatedApiKey.Name)
+
+	if len(updatedApiKey.Scopes) > 0 {
+		if !validApiKeyScopes(updatedApiKey.Scopes) {
+			return apiKey, nil, errors.New("Invalid api key scope")
+		}
+		apiKey.Scopes = updatedApiKey.Scopes
+	}
+
+	if !updatedApiKey.Expires.IsZero() {
+		apiKey.Expires = updatedApiKey.Expires
+	}
+
+	_, err = apiKey.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	return apiKey, nil, nil
+}
+
+/*
+* Delete methods
+ */
+
+// Revokes every key of a user, and the key from before users could have
+// several keys. The caller saves the user.
+func RevokeApiKeysForUser(c context.Context, user *models.User) error {
+	user.ApiKey = ""
+
+	ks, err := datastore.NewQuery("ApiKey").Filter("UserId =", user.Id).Filter("Revoked =", false).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	var apiKeys []models.ApiKey
+	apiKeys = make([]models.ApiKey, len(ks))
+	err = nds.GetMulti(c, ks, apiKeys)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	for i := 0; i < len(apiKeys); i++ {
+		apiKeys[i].Revoked = true
+		apiKeys[i].RevokedAt = time.Now()
+		apiKeys[i].Updated = time.Now()
+	}
+
+	_, err = nds.PutMulti(c, ks, apiKeys)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Keys are revoked instead of deleted so there is a record of them
+func RevokeApiKeyForUser(c context.Context, r *http.Request, id string, keyId string) (models.ApiKey, interface{}, error) {
+	apiKey, currentUser, err := getApiKeyForUser(c, r, id, keyId)
+	if err != nil {
+		return models.ApiKey{}, nil, err
+	}
+
+	if apiKey.Revoked {
+		return apiKey, nil, nil
+	}
+
+	_, err = apiKey.Revoke(c, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.ApiKey{}, nil, err
+	}
+
+	return apiKey, nil, nil
+}
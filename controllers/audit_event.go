@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+
+	"github.com/news-ai/web/utilities"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getAuditEvents(c context.Context, r *http.Request, query *datastore.Query) ([]models.AuditEvent, error) {
+	query = ConstructQuery(query.Order("-Created"), r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.AuditEvent{}, err
+	}
+
+	auditEvents := make([]models.AuditEvent, len(ks))
+	err = nds.GetMulti(c, ks, auditEvents)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.AuditEvent{}, err
+	}
+
+	for i := 0; i < len(auditEvents); i++ {
+		auditEvents[i].Format(ks[i], "auditevents")
+	}
+	return auditEvents, nil
+}
+
+// Filters from the query string of the admin endpoint. Every filter on
+// its own, with a date range, is in index.yaml.
+func filterAuditEvents(r *http.Request, query *datastore.Query) (*datastore.Query, error) {
+	idFilters := []struct {
+		Param    string
+		Property string
+	}{
+		{"actor", "ActorId"},
+		{"user", "UserIds"},
+		{"target", "TargetId"},
+	}
+
+	for i := 0; i < len(idFilters); i++ {
+		value := r.URL.Query().Get(idFilters[i].Param)
+		if value == "" {
+			continue
+		}
+
+		id, err := utilities.StringIdToInt(value)
+		if err != nil {
+			return nil, errors.New("Invalid " + idFilters[i].Param)
+		}
+		query = query.Filter(idFilters[i].Property+" =", id)
+	}
+
+	if r.URL.Query().Get("action") != "" {
+		query = query.Filter("Action =", r.URL.Query().Get("action"))
+	}
+
+	if r.URL.Query().Get("targettype") != "" {
+		query = query.Filter("TargetType =", r.URL.Query().Get("targettype"))
+	}
+
+	if r.URL.Query().Get("since") != "" {
+		since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+		if err != nil {
+			return nil, errors.New("Invalid since. Use RFC 3339")
+		}
+		query = query.Filter("Created >=", since)
+	}
+
+	if r.URL.Query().Get("until") != "" {
+		until, err := time.Parse(time.RFC3339, r.URL.Query().Get("until"))
+		if err != nil {
+			return nil, errors.New("Invalid until. Use RFC 3339")
+		}
+		query = query.Filter("Created <", until)
+	}
+
+	return query, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// The whole audit log for platform admins. Can be filtered by actor,
+// user, target, targettype, action, since and until.
+func GetAuditEvents(c context.Context, r *http.Request) ([]models.AuditEvent, interface{}, int, int, error) {
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionRead, policy.Collection("AuditEvent"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	query, err := filterAuditEvents(r, datastore.NewQuery("AuditEvent"))
+	if err != nil {
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	auditEvents, err := getAuditEvents(c, r, query)
+	if err != nil {
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	return auditEvents, nil, len(auditEvents), 0, nil
+}
+
+// The account activity of a user: everything they did and everything
+// that was done to their account
+func GetAccountActivityForUser(c context.Context, r *http.Request, id string) ([]models.AuditEvent, interface{}, int, int, error) {
+	user, _, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	auditEvents, err := getAuditEvents(c, r, datastore.NewQuery("AuditEvent").Filter("UserIds =", user.Id))
+	if err != nil {
+		return []models.AuditEvent{}, nil, 0, 0, err
+	}
+
+	return auditEvents, nil, len(auditEvents), 0, nil
+}
@@ -1,7 +1,11 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"google.golang.org/appengine/datastore"
@@ -37,6 +41,21 @@ var normalized = map[string]string{
 	"apikey":             "ApiKey",
 	"emailconfirmed":     "EmailConfirmed",
 	"sendat":             "SendAt",
+	"teamid":             "TeamId",
+	"url":                "URL",
+	"linkedin":           "LinkedIn",
+}
+
+func normalizeField(field string) string {
+	field = strings.ToLower(field)
+
+	// If it is inside the abnormal cases above
+	if normalizedField, ok := normalized[field]; ok {
+		return normalizedField
+	}
+
+	// Else return the titled version of it
+	return strings.Title(field)
 }
 
 func normalizeOrderQuery(order string) string {
@@ -46,16 +65,7 @@ func normalizeOrderQuery(order string) string {
 		order = order[1:]
 	}
 
-	order = strings.ToLower(order)
-
-	// If it is inside the abnormal cases above
-	if normalizedOrder, ok := normalized[order]; ok {
-		return operator + normalizedOrder
-	}
-
-	// Else return the titled version of it
-	order = strings.Title(order)
-	return operator + order
+	return operator + normalizeField(order)
 }
 
 func ConstructQuery(query *datastore.Query, r *http.Request) *datastore.Query {
@@ -81,3 +91,24 @@ func ConstructQuery(query *datastore.Query, r *http.Request) *datastore.Query {
 
 	return query
 }
+
+// Secrets like api keys and refresh tokens are only stored as a hash
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateSecret(prefix string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// URLs that we send users or requests to have to be https
+func validHTTPSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/qedus/nds"
 
+	"github.com/news-ai/api/billing"
 	"github.com/news-ai/api/models"
 )
 
@@ -31,3 +32,54 @@ func GetUserBilling(c context.Context, r *http.Request, user models.User) (model
 
 	return billing, nil
 }
+
+// Billings of users with a failed payment, for the dunning task
+func GetPastDueBillingsUnauthorized(c context.Context) ([]models.Billing, error) {
+	ks, err := datastore.NewQuery("Billing").Filter("IsPastDue =", true).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Billing{}, err
+	}
+
+	billings := make([]models.Billing, len(ks))
+	err = nds.GetMulti(c, ks, billings)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Billing{}, err
+	}
+
+	for i := 0; i < len(billings); i++ {
+		billings[i].Format(ks[i], "billings")
+	}
+
+	return billings, nil
+}
+
+// Keeps the limits that the users had before the limits came from the
+// plan catalog, for the plan limits sweep
+func SetLegacyPlanLimitsUnauthorized(c context.Context, r *http.Request, users []models.User) error {
+	billings := []models.Billing{}
+	for i := 0; i < len(users); i++ {
+		if users[i].BillingId == 0 {
+			continue
+		}
+
+		userBilling, err := GetUserBilling(c, r, users[i])
+		if err != nil {
+			return err
+		}
+
+		if billing.SetLegacyPlanLimits(c, &userBilling) {
+			billings = append(billings, userBilling)
+		}
+	}
+
+	if len(billings) == 0 {
+		return nil
+	}
+	return models.SaveBillings(c, billings)
+}
+
+func GetUserByBillingUnauthorized(c context.Context, userBilling models.Billing) (models.User, error) {
+	return getUserByBillingId(c, userBilling.Id)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/http"
 
 	"golang.org/x/net/context"
@@ -9,9 +10,14 @@ import (
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 
+	"github.com/pquerna/ffjson/ffjson"
 	"github.com/qedus/nds"
 
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+	apiSearch "github.com/news-ai/api/search"
+
+	tabulaeModels "github.com/news-ai/tabulae/models"
 
 	"github.com/news-ai/web/utilities"
 )
@@ -46,6 +52,77 @@ func getClient(c context.Context, id int64) (models.Client, error) {
 	return models.Client{}, errors.New("No client by this id")
 }
 
+func getClientForUser(c context.Context, r *http.Request, id string, action policy.Action) (models.Client, models.User, error) {
+	currentId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, models.User{}, err
+	}
+
+	client, err := getClient(c, currentId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, models.User{}, err
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, action, client)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, models.User{}, err
+	}
+
+	return client, currentUser, nil
+}
+
+// Clients can only be created in, or moved to, a team where the current
+// user is a member that can create clients
+func canCreateClientInTeam(c context.Context, currentUser models.User, teamId int64) error {
+	if teamId == 0 {
+		return nil
+	}
+
+	return policy.Authorize(c, currentUser, policy.ActionCreate, models.Client{TeamId: teamId})
+}
+
+// Moving a client off its team needs team-admin rights on the team, and
+// only the person who created a client can take it off every team
+func canMoveClientToTeam(c context.Context, currentUser models.User, client models.Client, teamId int64) error {
+	if client.TeamId != 0 || client.CreatedBy != currentUser.Id {
+		err := policy.Authorize(c, currentUser, policy.ActionManage, client)
+		if err != nil {
+			return err
+		}
+	}
+
+	if teamId == 0 {
+		if client.CreatedBy != currentUser.Id {
+			return policy.ErrForbidden
+		}
+		return nil
+	}
+
+	return canCreateClientInTeam(c, currentUser, teamId)
+}
+
+// Social accounts that a client tracks count towards the limits of the
+// plan of the user who created it
+func socialAccountsOfClient(client models.Client) int {
+	count := 0
+	if client.Twitter != "" {
+		count++
+	}
+	if client.Instagram != "" {
+		count++
+	}
+	return count
+}
+
 /*
 * Public methods
  */
@@ -62,11 +139,17 @@ func GetClients(c context.Context, r *http.Request) ([]models.Client, interface{
 		return []models.Client{}, nil, 0, 0, err
 	}
 
-	if !user.IsAdmin {
-		return []models.Client{}, nil, 0, 0, errors.New("Forbidden")
+	query := datastore.NewQuery("Client")
+
+	// Users only see the clients of their team
+	if !policy.Can(c, user, policy.ActionRead, policy.Collection("Client")) {
+		if user.TeamId != 0 {
+			query = query.Filter("TeamId =", user.TeamId)
+		} else {
+			query = query.Filter("TeamId =", int64(0)).Filter("CreatedBy =", user.Id)
+		}
 	}
 
-	query := datastore.NewQuery("Client")
 	query = ConstructQuery(query, r)
 	ks, err := query.KeysOnly().GetAll(c, nil)
 	if err != nil {
@@ -89,18 +172,233 @@ func GetClients(c context.Context, r *http.Request) ([]models.Client, interface{
 	return clients, nil, len(clients), 0, nil
 }
 
-func GetClient(c context.Context, id string) (models.Client, interface{}, error) {
+func GetClient(c context.Context, r *http.Request, id string) (models.Client, interface{}, error) {
 	// Get the details of a client
-	currentId, err := utilities.StringIdToInt(id)
+	client, _, err := getClientForUser(c, r, id, policy.ActionRead)
+	if err != nil {
+		return models.Client{}, nil, err
+	}
+	return client, nil, nil
+}
+
+func GetTweetsForClient(c context.Context, r *http.Request, id string) (interface{}, interface{}, int, int, error) {
+	client, _, err := getClientForUser(c, r, id, policy.ActionRead)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	tweets, total, err := apiSearch.SearchTweetsByUsername(c, r, client.Twitter)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, 0, 0, err
+	}
+
+	return tweets, nil, len(tweets), total, nil
+}
+
+func GetInstagramPostsForClient(c context.Context, r *http.Request, id string) (interface{}, interface{}, int, int, error) {
+	client, _, err := getClientForUser(c, r, id, policy.ActionRead)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	instagramPosts, total, err := apiSearch.SearchInstagramPostsByUsername(c, r, client.Instagram)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, 0, 0, err
+	}
+
+	return instagramPosts, nil, len(instagramPosts), total, nil
+}
+
+func GetHeadlinesForClient(c context.Context, r *http.Request, id string) (interface{}, interface{}, int, int, error) {
+	client, _, err := getClientForUser(c, r, id, policy.ActionRead)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	// The websites and blog of the client are used as their feeds
+	feeds := []string{}
+	for i := 0; i < len(client.Websites); i++ {
+		if client.Websites[i] != "" {
+			feeds = append(feeds, client.Websites[i])
+		}
+	}
+
+	if client.Blog != "" {
+		feeds = append(feeds, client.Blog)
+	}
+
+	headlines, total, err := apiSearch.SearchHeadlinesByResourceId(c, r, []tabulaeModels.Feed{}, feeds)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, 0, 0, err
+	}
+
+	return headlines, nil, len(headlines), total, nil
+}
+
+/*
+* Create methods
+ */
+
+func CreateClient(c context.Context, r *http.Request) (models.Client, interface{}, error) {
+	buf, _ := ioutil.ReadAll(r.Body)
+
+	currentUser, err := GetCurrentUser(c, r)
 	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.Client{}, nil, err
 	}
 
-	client, err := getClient(c, currentId)
+	decoder := ffjson.NewDecoder()
+	var client models.Client
+	err = decoder.Decode(buf, &client)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, nil, err
+	}
+
+	if client.Name == "" {
+		return models.Client{}, nil, errors.New("Please provide a name for the client")
+	}
+
+	// Clients belong to the team of the person who creates them
+	if client.TeamId == 0 {
+		client.TeamId = currentUser.TeamId
+	}
+
+	err = canCreateClientInTeam(c, currentUser, client.TeamId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, nil, err
+	}
+
+	err = checkSocialAccountsLimit(c, r, currentUser, socialAccountsOfClient(client))
+	if err != nil {
+		return models.Client{}, nil, err
+	}
+
+	_, err = client.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, nil, err
+	}
+
+	return client, nil, nil
+}
+
+/*
+* Update methods
+ */
+
+func UpdateClient(c context.Context, r *http.Request, id string) (models.Client, interface{}, error) {
+	client, currentUser, err := getClientForUser(c, r, id, policy.ActionUpdate)
+	if err != nil {
+		return models.Client{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var updatedClient map[string]interface{}
+	err = decoder.Decode(buf, &updatedClient)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, nil, err
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range updatedClient {
+		field := normalizeField(k)
+
+		// These fields are managed by the API
+		if field == "Id" || field == "Type" || field == "CreatedBy" || field == "Created" || field == "Updated" {
+			continue
+		}
+
+		fields[field] = v
+	}
+
+	originalClient := client
+	err = client.FillStruct(fields)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Client{}, nil, err
+	}
+
+	if client.TeamId != originalClient.TeamId {
+		err = canMoveClientToTeam(c, currentUser, originalClient, client.TeamId)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.Client{}, nil, err
+		}
+	}
+
+	newSocialAccounts := socialAccountsOfClient(client) - socialAccountsOfClient(originalClient)
+	if newSocialAccounts > 0 {
+		creator, err := getUserUnauthorized(c, r, client.CreatedBy)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.Client{}, nil, err
+		}
+
+		err = checkSocialAccountsLimit(c, r, creator, newSocialAccounts)
+		if err != nil {
+			return models.Client{}, nil, err
+		}
+	}
+
+	_, err = client.Save(c)
 	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.Client{}, nil, err
 	}
+
 	return client, nil, nil
 }
+
+/*
+* Delete methods
+ */
+
+func DeleteClient(c context.Context, r *http.Request, id string) (interface{}, interface{}, error) {
+	client, _, err := getClientForUser(c, r, id, policy.ActionDelete)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Remove the client from any agencies it is attached to
+	ks, err := datastore.NewQuery("Agency").Filter("Clients =", client.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, err
+	}
+
+	var agencies []models.Agency
+	agencies = make([]models.Agency, len(ks))
+	err = nds.GetMulti(c, ks, agencies)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, err
+	}
+
+	for i := 0; i < len(agencies); i++ {
+		agencies[i].Format(ks[i], "agencies")
+		clients := []int64{}
+		for x := 0; x < len(agencies[i].Clients); x++ {
+			if agencies[i].Clients[x] != client.Id {
+				clients = append(clients, agencies[i].Clients[x])
+			}
+		}
+		agencies[i].Clients = clients
+		agencies[i].Save(c)
+	}
+
+	_, err = client.Delete(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, nil, err
+	}
+
+	return nil, nil, nil
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+)
+
+/*
+* Private methods
+ */
+
+// Coupon rules are managed by platform admins
+func authorizeCouponRules(c context.Context, r *http.Request) (models.User, error) {
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, policy.Collection("CouponRule"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	return currentUser, nil
+}
+
+func validCouponRule(c context.Context, couponRule models.CouponRule) error {
+	if couponRule.Code == "" {
+		return errors.New("Coupon rules need the code of a Stripe coupon")
+	}
+
+	for i := 0; i < len(couponRule.Durations); i++ {
+		if couponRule.Durations[i] != "monthly" && couponRule.Durations[i] != "annually" {
+			return errors.New("Duration is invalid")
+		}
+	}
+
+	for i := 0; i < len(couponRule.PlanIds); i++ {
+		_, err := billing.FindPlan(c, couponRule.PlanIds[i])
+		if err != nil {
+			return errors.New("No plan by the id " + couponRule.PlanIds[i])
+		}
+	}
+
+	if couponRule.MaxRedemptions < 0 {
+		return errors.New("Maximum redemptions can not be negative")
+	}
+
+	if !couponRule.ExpiresAt.IsZero() && couponRule.ExpiresAt.Before(couponRule.StartsAt) {
+		return errors.New("Coupon rules have to start before they expire")
+	}
+
+	return nil
+}
+
+func getCouponRule(c context.Context, code string) (models.CouponRule, error) {
+	couponRule, hasRule, err := billing.GetCouponRule(c, code)
+	if err != nil {
+		return models.CouponRule{}, err
+	}
+
+	if !hasRule {
+		return models.CouponRule{}, errors.New("No coupon rule for this code")
+	}
+
+	return couponRule, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetCouponRules(c context.Context, r *http.Request) ([]models.CouponRule, interface{}, int, int, error) {
+	_, err := authorizeCouponRules(c, r)
+	if err != nil {
+		return []models.CouponRule{}, nil, 0, 0, err
+	}
+
+	couponRules, err := billing.GetCouponRules(c)
+	if err != nil {
+		return []models.CouponRule{}, nil, 0, 0, err
+	}
+
+	return couponRules, nil, len(couponRules), 0, nil
+}
+
+// Coupon rules are found by their code since the default rules are not
+// stored
+func GetCouponRule(c context.Context, r *http.Request, code string) (models.CouponRule, interface{}, error) {
+	_, err := authorizeCouponRules(c, r)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	couponRule, err := getCouponRule(c, code)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	return couponRule, nil, nil
+}
+
+/*
+* Create methods
+ */
+
+func CreateCouponRule(c context.Context, r *http.Request) (models.CouponRule, interface{}, error) {
+	currentUser, err := authorizeCouponRules(c, r)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var couponRule models.CouponRule
+	err = decoder.Decode(buf, &couponRule)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, nil, err
+	}
+
+	couponRule.Code = strings.ToUpper(couponRule.Code)
+	couponRule.Redemptions = 0
+
+	err = validCouponRule(c, couponRule)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	existingRule, hasRule, err := billing.GetCouponRule(c, couponRule.Code)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	// Default rules can be replaced by a stored one
+	if hasRule && existingRule.Id != 0 {
+		return models.CouponRule{}, nil, errors.New("A rule for this coupon already exists")
+	}
+
+	_, err = couponRule.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, nil, err
+	}
+
+	audit.Record(r, models.AuditActionCouponRuleCreate, couponRule, nil, couponRule)
+	return couponRule, nil, nil
+}
+
+/*
+* Update methods
+ */
+
+func UpdateCouponRule(c context.Context, r *http.Request, code string) (models.CouponRule, interface{}, error) {
+	currentUser, err := authorizeCouponRules(c, r)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	couponRule, err := getCouponRule(c, code)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+	before := couponRule
+
+	// Only the fields that are sent are changed
+	buf, _ := ioutil.ReadAll(r.Body)
+	err = ffjson.Unmarshal(buf, &couponRule)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, nil, err
+	}
+
+	couponRule.Id = before.Id
+	couponRule.Code = before.Code
+	couponRule.Redemptions = before.Redemptions
+	couponRule.Created = before.Created
+	couponRule.CreatedBy = before.CreatedBy
+
+	err = validCouponRule(c, couponRule)
+	if err != nil {
+		return models.CouponRule{}, nil, err
+	}
+
+	// Default rules are stored the first time they are changed
+	if couponRule.Id == 0 {
+		_, err = couponRule.Create(c, r, currentUser)
+	} else {
+		_, err = couponRule.Save(c)
+	}
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.CouponRule{}, nil, err
+	}
+
+	audit.Record(r, models.AuditActionCouponRuleUpdate, couponRule, before, couponRule)
+	return couponRule, nil, nil
+}
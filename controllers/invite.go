@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/mail"
+	"strings"
 
 	"golang.org/x/net/context"
 
@@ -15,6 +16,7 @@ import (
 	"google.golang.org/appengine/log"
 
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
 
 	"github.com/news-ai/tabulae/emails"
 
@@ -47,6 +49,37 @@ func generateTokenAndEmail(c context.Context, r *http.Request, invite models.Inv
 		return models.UserInviteCode{}, invalidEmailError
 	}
 
+	referralCode := models.UserInviteCode{}
+	referralCode.Email = validEmail.Address
+	referralCode.TeamId = invite.TeamId
+	referralCode.CreatedBy = currentUser.Id
+
+	err = policy.Authorize(c, currentUser, policy.ActionCreate, referralCode)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserInviteCode{}, err
+	}
+
+	// If the user is being invited to a team then the current user has
+	// to be able to manage the invite and the team needs to have space.
+	if invite.TeamId != 0 {
+		err = policy.Authorize(c, currentUser, policy.ActionManage, referralCode)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.UserInviteCode{}, err
+		}
+
+		team, err := getTeam(c, invite.TeamId)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.UserInviteCode{}, err
+		}
+
+		if len(team.Members) >= team.MaxMembers {
+			return models.UserInviteCode{}, errors.New("The team has reached the allowed number of members")
+		}
+	}
+
 	// Get the Contact by id
 	ks, err := datastore.NewQuery("UserInviteCode").Filter("Email =", validEmail.Address).KeysOnly().GetAll(c, nil)
 	if err != nil {
@@ -88,8 +121,6 @@ func generateTokenAndEmail(c context.Context, r *http.Request, invite models.Inv
 		return models.UserInviteCode{}, userExistsError
 	}
 
-	referralCode := models.UserInviteCode{}
-	referralCode.Email = validEmail.Address
 	referralCode.InviteCode = utilities.RandToken()
 	_, err = referralCode.Create(c, r, currentUser)
 	if err != nil {
@@ -139,13 +170,19 @@ func GetInvites(c context.Context, r *http.Request) ([]models.UserInviteCode, in
 		return []models.UserInviteCode{}, nil, 0, 0, err
 	}
 
+	readableInviteCodes := []models.UserInviteCode{}
 	for i := 0; i < len(userInviteCodes); i++ {
 		userInviteCodes[i].Format(ks[i], "invites")
+		if policy.Can(c, currentUser, policy.ActionRead, userInviteCodes[i]) {
+			readableInviteCodes = append(readableInviteCodes, userInviteCodes[i])
+		}
 	}
 
-	return userInviteCodes, nil, len(userInviteCodes), 0, nil
+	return readableInviteCodes, nil, len(readableInviteCodes), 0, nil
 }
 
+// The invitation code is what the invited person is given, so it is not
+// authorized against a user
 func GetInviteFromInvitationCode(c context.Context, r *http.Request, invitationCode string) (models.UserInviteCode, error) {
 	ks, err := datastore.NewQuery("UserInviteCode").Filter("InviteCode =", invitationCode).KeysOnly().GetAll(c, nil)
 	if err != nil {
@@ -196,3 +233,54 @@ func CreateInvite(c context.Context, r *http.Request) (models.UserInviteCode, in
 
 	return userInvite, nil, nil
 }
+
+// An invitation that can still be used by a user with an email. Invitations
+// that have been used, or were sent to another email, are rejected.
+func GetInviteForEmail(c context.Context, r *http.Request, invitationCode string, email string) (models.UserInviteCode, error) {
+	userInviteCode, err := GetInviteFromInvitationCode(c, r, invitationCode)
+	if err != nil {
+		return models.UserInviteCode{}, err
+	}
+
+	if userInviteCode.IsUsed {
+		return models.UserInviteCode{}, errors.New("This invitation has already been used")
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(userInviteCode.Email), strings.TrimSpace(email)) {
+		return models.UserInviteCode{}, errors.New("This invitation was sent to another email")
+	}
+
+	return userInviteCode, nil
+}
+
+/*
+* Update methods
+ */
+
+// Uses up an invitation. Two users can't both use the same invitation.
+func UseInvite(c context.Context, userInviteCode *models.UserInviteCode) error {
+	inviteKey := datastore.NewKey(c, "UserInviteCode", "", userInviteCode.Id, nil)
+	err := nds.RunInTransaction(c, func(tc context.Context) error {
+		var storedInviteCode models.UserInviteCode
+		err := nds.Get(tc, inviteKey, &storedInviteCode)
+		if err != nil {
+			return err
+		}
+
+		if storedInviteCode.IsUsed {
+			return errors.New("This invitation has already been used")
+		}
+
+		storedInviteCode.Format(inviteKey, "invites")
+		storedInviteCode.IsUsed = true
+		_, err = storedInviteCode.Save(tc)
+		return err
+	}, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	userInviteCode.IsUsed = true
+	return nil
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+// Invoices are for the billing of the current user, or for the billing of
+// an agency when there is an agency in the query
+func getInvoiceBilling(c context.Context, r *http.Request) (models.Billing, models.Agency, models.User, error) {
+	agencyId := r.URL.Query().Get("agency")
+	if agencyId != "" {
+		agency, currentUser, err := getAgencyForAction(c, r, agencyId)
+		if err != nil {
+			return models.Billing{}, models.Agency{}, models.User{}, err
+		}
+
+		agencyBilling, err := getAgencyBilling(c, agency)
+		if err != nil {
+			return models.Billing{}, models.Agency{}, models.User{}, err
+		}
+
+		return agencyBilling, agency, currentUser, nil
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Billing{}, models.Agency{}, models.User{}, err
+	}
+
+	userBilling, err := GetUserBilling(c, r, currentUser)
+	if err != nil {
+		return models.Billing{}, models.Agency{}, models.User{}, err
+	}
+
+	// Receipts of users only show the agency they work for when the agency
+	// has verified the email domain of the user
+	for i := 0; i < len(currentUser.Employers); i++ {
+		agency, err := getAgency(c, currentUser.Employers[i])
+		if err == nil && isUserInVerifiedDomain(agency, currentUser) {
+			return userBilling, agency, currentUser, nil
+		}
+	}
+
+	return userBilling, models.Agency{}, currentUser, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetInvoices(c context.Context, r *http.Request) ([]billing.Invoice, interface{}, int, int, error) {
+	invoiceBilling, _, _, err := getInvoiceBilling(c, r)
+	if err != nil {
+		return []billing.Invoice{}, nil, 0, 0, err
+	}
+
+	offset := gcontext.Get(r, "offset").(int)
+	limit := gcontext.Get(r, "limit").(int)
+
+	invoices, err := billing.GetCustomerInvoices(r, &invoiceBilling, offset, limit)
+	if err != nil {
+		return []billing.Invoice{}, nil, 0, 0, err
+	}
+
+	return invoices, nil, len(invoices), 0, nil
+}
+
+func GetInvoice(c context.Context, r *http.Request, id string) (billing.Invoice, interface{}, error) {
+	invoiceBilling, _, _, err := getInvoiceBilling(c, r)
+	if err != nil {
+		return billing.Invoice{}, nil, err
+	}
+
+	invoice, err := billing.GetCustomerInvoice(r, &invoiceBilling, id)
+	if err != nil {
+		return billing.Invoice{}, nil, err
+	}
+
+	return invoice, nil, nil
+}
+
+// The receipt of an invoice as a PDF
+func GetInvoiceReceipt(c context.Context, r *http.Request, id string) ([]byte, error) {
+	invoiceBilling, agency, currentUser, err := getInvoiceBilling(c, r)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := billing.GetCustomerInvoice(r, &invoiceBilling, id)
+	if err != nil {
+		return nil, err
+	}
+
+	customer := billing.ReceiptCustomer{
+		Name:  strings.TrimSpace(currentUser.FirstName + " " + currentUser.LastName),
+		Email: currentUser.Email,
+	}
+	if agency.Id != 0 {
+		customer.Name = agency.Name
+		customer.AddressLines = agency.AddressLines()
+		if invoiceBilling.IsAgency {
+			customer.Email = agency.Email
+		}
+	}
+
+	receipt, err := billing.RenderReceipt(invoice, customer)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+
+	return receipt, nil
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/web/utilities"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getLinkedAccounts(c context.Context, userId int64) ([]models.LinkedAccount, error) {
+	ks, err := datastore.NewQuery("LinkedAccount").Filter("UserId =", userId).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LinkedAccount{}, err
+	}
+
+	linkedAccounts := make([]models.LinkedAccount, len(ks))
+	err = nds.GetMulti(c, ks, linkedAccounts)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LinkedAccount{}, err
+	}
+
+	for i := 0; i < len(linkedAccounts); i++ {
+		linkedAccounts[i].Format(ks[i], "linkedaccounts")
+	}
+
+	return linkedAccounts, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetLinkedAccountsForUser(c context.Context, r *http.Request, id string) ([]models.LinkedAccount, interface{}, int, int, error) {
+	user, _, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return []models.LinkedAccount{}, nil, 0, 0, err
+	}
+
+	linkedAccounts, err := getLinkedAccounts(c, user.Id)
+	if err != nil {
+		return []models.LinkedAccount{}, nil, 0, 0, err
+	}
+
+	return linkedAccounts, nil, len(linkedAccounts), 0, nil
+}
+
+// Returns datastore.ErrNoSuchEntity if the user hasn't linked the provider
+func GetLinkedAccountForUser(c context.Context, userId int64, provider string) (models.LinkedAccount, error) {
+	linkedAccounts, err := getLinkedAccounts(c, userId)
+	if err != nil {
+		return models.LinkedAccount{}, err
+	}
+
+	for i := 0; i < len(linkedAccounts); i++ {
+		if linkedAccounts[i].Provider == provider {
+			return linkedAccounts[i], nil
+		}
+	}
+
+	return models.LinkedAccount{}, datastore.ErrNoSuchEntity
+}
+
+/*
+* Update methods
+ */
+
+// Encrypts the tokens of the linked accounts of users again, for the
+// reencryptSecrets task
+func ReencryptLinkedAccountsUnauthorized(c context.Context, users []models.User) error {
+	for i := 0; i < len(users); i++ {
+		linkedAccounts, err := getLinkedAccounts(c, users[i].Id)
+		if err != nil {
+			return err
+		}
+
+		for x := 0; x < len(linkedAccounts); x++ {
+			if !linkedAccounts[x].NeedsEncryption() {
+				continue
+			}
+
+			_, err = linkedAccounts[x].Save(c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Links an account at a provider to a user, or updates the account that
+// is already linked. Refresh tokens are only sent the first time a user
+// gives access so they are kept if a new one isn't sent.
+func SaveLinkedAccountForUser(c context.Context, r *http.Request, user models.User, account models.LinkedAccount) (models.LinkedAccount, error) {
+	linkedAccount, err := GetLinkedAccountForUser(c, user.Id, account.Provider)
+	if err != nil {
+		linkedAccount = models.LinkedAccount{}
+		linkedAccount.UserId = user.Id
+		linkedAccount.Provider = account.Provider
+	}
+
+	linkedAccount.ProviderUserId = account.ProviderUserId
+	linkedAccount.Email = account.Email
+	linkedAccount.Scopes = account.Scopes
+	linkedAccount.AccessToken = account.AccessToken
+	linkedAccount.TokenType = account.TokenType
+	linkedAccount.Expires = account.Expires
+	utilities.UpdateIfNotBlank(&linkedAccount.RefreshToken, account.RefreshToken)
+
+	if linkedAccount.Created.IsZero() {
+		_, err = linkedAccount.Create(c, r, user)
+	} else {
+		_, err = linkedAccount.Save(c)
+	}
+
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.LinkedAccount{}, err
+	}
+
+	return linkedAccount, nil
+}
+
+/*
+* Delete methods
+ */
+
+func RemoveLinkedAccountForUser(c context.Context, userId int64, provider string) error {
+	linkedAccount, err := GetLinkedAccountForUser(c, userId, provider)
+	if err != nil {
+		// Nothing to remove
+		return nil
+	}
+
+	_, err = linkedAccount.Delete(c)
+	return err
+}
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+)
+
+type loginLimit struct {
+	MaxFailures int
+	Window      time.Duration
+}
+
+// How many failures are allowed for an email or IP address before they
+// are locked out. Password resets count every request.
+var loginLimits = map[string]loginLimit{
+	models.LoginAttemptPassword + ":email":       {MaxFailures: 5, Window: 15 * time.Minute},
+	models.LoginAttemptPassword + ":ip":          {MaxFailures: 20, Window: 15 * time.Minute},
+	models.LoginAttemptForgetPassword + ":email": {MaxFailures: 3, Window: time.Hour},
+	models.LoginAttemptForgetPassword + ":ip":    {MaxFailures: 10, Window: time.Hour},
+}
+
+var (
+	loginLockoutBase    = 5 * time.Minute
+	loginLockoutMaximum = 24 * time.Hour
+)
+
+/*
+* Private methods
+ */
+
+func loginAttemptIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Two-factor failures count against the same lockout as password failures
+func loginLockoutKind(kind string) string {
+	if kind == models.LoginAttemptTwoFactor {
+		return models.LoginAttemptPassword
+	}
+	return kind
+}
+
+func loginLockoutKeys(r *http.Request, kind string, email string) []string {
+	kind = loginLockoutKind(kind)
+	return []string{kind + ":email:" + strings.ToLower(email), kind + ":ip:" + loginAttemptIP(r)}
+}
+
+func loginLimitForKey(key string) loginLimit {
+	parts := strings.SplitN(key, ":", 3)
+	return loginLimits[parts[0]+":"+parts[1]]
+}
+
+// Each lockout is twice as long as the one before it
+func loginLockoutDuration(lockouts int) time.Duration {
+	duration := loginLockoutBase
+	for i := 1; i < lockouts; i++ {
+		duration = duration * 2
+		if duration > loginLockoutMaximum {
+			return loginLockoutMaximum
+		}
+	}
+	return duration
+}
+
+/*
+* Get methods
+ */
+
+func getLoginLockout(c context.Context, key string) (models.LoginLockout, error) {
+	loginLockout := models.LoginLockout{Key: key}
+	loginLockoutId := loginLockout.LockoutKey(c)
+
+	err := nds.Get(c, loginLockoutId, &loginLockout)
+	if err == datastore.ErrNoSuchEntity {
+		return models.LoginLockout{Key: key}, nil
+	}
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.LoginLockout{}, err
+	}
+
+	loginLockout.Format(loginLockoutId, "loginlockouts")
+	return loginLockout, nil
+}
+
+/*
+* Update methods
+ */
+
+// Parallel failures are counted in a transaction so none of them are lost
+func addLoginFailure(c context.Context, key string) {
+	limit := loginLimitForKey(key)
+	if limit.MaxFailures == 0 {
+		return
+	}
+
+	err := nds.RunInTransaction(c, func(tc context.Context) error {
+		loginLockout, err := getLoginLockout(tc, key)
+		if err != nil {
+			return err
+		}
+
+		// Start counting again once the window has passed
+		if loginLockout.FirstFailure.IsZero() || time.Since(loginLockout.FirstFailure) > limit.Window {
+			loginLockout.Failures = 0
+			loginLockout.FirstFailure = time.Now()
+		}
+
+		loginLockout.Failures += 1
+		if loginLockout.Failures >= limit.MaxFailures {
+			loginLockout.Lockouts += 1
+			loginLockout.LockedUntil = time.Now().Add(loginLockoutDuration(loginLockout.Lockouts))
+			loginLockout.Failures = 0
+			loginLockout.FirstFailure = time.Time{}
+			log.Warningf(c, "Locking out %v until %v", key, loginLockout.LockedUntil)
+		}
+
+		_, err = loginLockout.Save(tc)
+		return err
+	}, &datastore.TransactionOptions{Attempts: 10})
+
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+}
+
+func clearLoginLockout(c context.Context, key string) error {
+	return nds.RunInTransaction(c, func(tc context.Context) error {
+		loginLockout, err := getLoginLockout(tc, key)
+		if err != nil {
+			return err
+		}
+
+		if loginLockout.Created.IsZero() {
+			return nil
+		}
+
+		loginLockout.Failures = 0
+		loginLockout.FirstFailure = time.Time{}
+		loginLockout.Lockouts = 0
+		loginLockout.LockedUntil = time.Time{}
+		_, err = loginLockout.Save(tc)
+		return err
+	}, nil)
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// Checks if the email or the IP address of a request are locked out.
+// Returns when the lockout ends.
+func GetLoginLockout(c context.Context, r *http.Request, kind string, email string) (time.Time, bool) {
+	keys := loginLockoutKeys(r, kind, email)
+	lockedUntil := time.Time{}
+	for i := 0; i < len(keys); i++ {
+		loginLockout, err := getLoginLockout(c, keys[i])
+		if err != nil {
+			continue
+		}
+		if loginLockout.IsLocked() && loginLockout.LockedUntil.After(lockedUntil) {
+			lockedUntil = loginLockout.LockedUntil
+		}
+	}
+	return lockedUntil, !lockedUntil.IsZero()
+}
+
+// Admins can see login attempts filtered by email, IP address or kind
+func GetLoginAttempts(c context.Context, r *http.Request) ([]models.LoginAttempt, interface{}, int, int, error) {
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LoginAttempt{}, nil, 0, 0, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionRead, policy.Collection("LoginAttempt"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LoginAttempt{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("LoginAttempt")
+	if r.URL.Query().Get("email") != "" {
+		query = query.Filter("Email =", strings.ToLower(r.URL.Query().Get("email")))
+	}
+	if r.URL.Query().Get("ip") != "" {
+		query = query.Filter("IP =", r.URL.Query().Get("ip"))
+	}
+	if r.URL.Query().Get("kind") != "" {
+		query = query.Filter("Kind =", r.URL.Query().Get("kind"))
+	}
+
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LoginAttempt{}, nil, 0, 0, err
+	}
+
+	var loginAttempts []models.LoginAttempt
+	loginAttempts = make([]models.LoginAttempt, len(ks))
+	err = nds.GetMulti(c, ks, loginAttempts)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.LoginAttempt{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(loginAttempts); i++ {
+		loginAttempts[i].Format(ks[i], "loginattempts")
+	}
+
+	return loginAttempts, nil, len(loginAttempts), 0, nil
+}
+
+/*
+* Create methods
+ */
+
+// Records a login attempt. Failures count towards locking out the email
+// and IP address. A successful login clears the lockout of the email.
+func RecordLoginAttempt(c context.Context, r *http.Request, kind string, email string, success bool, blocked bool) {
+	loginAttempt := models.LoginAttempt{
+		Email:     strings.ToLower(email),
+		IP:        loginAttemptIP(r),
+		UserAgent: r.UserAgent(),
+		Kind:      kind,
+		Success:   success,
+		Blocked:   blocked,
+	}
+	loginAttempt.Create(c, r)
+
+	if blocked {
+		return
+	}
+
+	keys := loginLockoutKeys(r, kind, email)
+
+	// Every password reset request counts towards the limit
+	if !success || kind == models.LoginAttemptForgetPassword {
+		for i := 0; i < len(keys); i++ {
+			addLoginFailure(c, keys[i])
+		}
+		return
+	}
+
+	clearLoginLockout(c, keys[0])
+}
+
+/*
+* Action methods
+ */
+
+// Lets an admin unlock a user that has been locked out
+func UnlockUser(c context.Context, r *http.Request, id string) (models.User, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	kinds := []string{models.LoginAttemptPassword, models.LoginAttemptForgetPassword}
+	for i := 0; i < len(kinds); i++ {
+		err = clearLoginLockout(c, kinds[i]+":email:"+user.Email)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, nil, errors.New("Could not unlock the user")
+		}
+	}
+
+	return user, nil, nil
+}
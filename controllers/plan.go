@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+)
+
+/*
+* Private methods
+ */
+
+// The plan catalog is only managed by platform admins
+func authorizePlans(c context.Context, r *http.Request) (models.User, error) {
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, policy.Collection("Plan"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	return currentUser, nil
+}
+
+func validPlan(plan models.Plan) error {
+	if plan.PlanId == "" || plan.Name == "" {
+		return errors.New("Plans need an id and a name")
+	}
+
+	if billing.StripePlanToPlanId(plan.PlanId) != plan.PlanId {
+		return errors.New("The plan id has to be the id of the monthly plan")
+	}
+
+	if plan.MonthlyPrice < 0 || plan.YearlyPrice < 0 {
+		return errors.New("Prices can not be negative")
+	}
+
+	if plan.EmailAccounts < 0 || plan.DailyEmailsAllowed < 0 || plan.SocialAccounts < 0 || plan.TeamMembers < 0 {
+		return errors.New("Limits can not be negative")
+	}
+
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetPlans(c context.Context, r *http.Request) ([]models.Plan, interface{}, int, int, error) {
+	_, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Plan{}, nil, 0, 0, err
+	}
+
+	plans, err := billing.GetPlans(c)
+	if err != nil {
+		return []models.Plan{}, nil, 0, 0, err
+	}
+
+	return plans, nil, len(plans), 0, nil
+}
+
+// Plans are found by their Stripe id since the default plans are not
+// stored
+func GetPlan(c context.Context, r *http.Request, id string) (models.Plan, interface{}, error) {
+	_, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Plan{}, nil, err
+	}
+
+	plan, err := billing.FindPlan(c, id)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+
+	return plan, nil, nil
+}
+
+/*
+* Create methods
+ */
+
+func CreatePlan(c context.Context, r *http.Request) (models.Plan, interface{}, error) {
+	currentUser, err := authorizePlans(c, r)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var plan models.Plan
+	err = decoder.Decode(buf, &plan)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Plan{}, nil, err
+	}
+
+	err = validPlan(plan)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+
+	_, err = billing.FindPlan(c, plan.PlanId)
+	if err == nil {
+		return models.Plan{}, nil, errors.New("A plan with this id already exists")
+	}
+
+	_, err = plan.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Plan{}, nil, err
+	}
+
+	audit.Record(r, models.AuditActionPlanCatalogCreate, plan, nil, plan)
+	return plan, nil, nil
+}
+
+/*
+* Update methods
+ */
+
+// Changing the limits of a plan changes them for everyone on it. To
+// change them for new users, retire the plan and add a new one.
+func UpdatePlan(c context.Context, r *http.Request, id string) (models.Plan, interface{}, error) {
+	currentUser, err := authorizePlans(c, r)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+
+	plan, err := billing.FindPlan(c, id)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+	before := plan
+
+	// Only the fields that are sent are changed
+	buf, _ := ioutil.ReadAll(r.Body)
+	err = ffjson.Unmarshal(buf, &plan)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Plan{}, nil, err
+	}
+
+	plan.Id = before.Id
+	plan.PlanId = before.PlanId
+	plan.Created = before.Created
+	plan.CreatedBy = before.CreatedBy
+
+	err = validPlan(plan)
+	if err != nil {
+		return models.Plan{}, nil, err
+	}
+
+	// Default plans are stored the first time they are changed
+	if plan.Id == 0 {
+		_, err = plan.Create(c, r, currentUser)
+	} else {
+		_, err = plan.Save(c)
+	}
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Plan{}, nil, err
+	}
+
+	audit.Record(r, models.AuditActionPlanCatalogUpdate, plan, before, plan)
+	return plan, nil, nil
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+)
+
+var refreshTokenDuration = 30 * 24 * time.Hour
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getRefreshToken(c context.Context, token string) (models.RefreshToken, error) {
+	ks, err := datastore.NewQuery("RefreshToken").Filter("TokenHash =", hashSecret(token)).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.RefreshToken{}, err
+	}
+
+	if len(ks) == 0 {
+		return models.RefreshToken{}, errors.New("Invalid refresh token")
+	}
+
+	var refreshToken models.RefreshToken
+	err = nds.Get(c, ks[0], &refreshToken)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.RefreshToken{}, err
+	}
+
+	refreshToken.Format(ks[0], "refreshtokens")
+	return refreshToken, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+// Creates a new refresh token for a user. The token itself is only
+// returned here and never stored.
+func CreateRefreshTokenForUser(c context.Context, r *http.Request, user models.User) (string, models.RefreshToken, error) {
+	token, err := generateSecret("nrt_")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return "", models.RefreshToken{}, err
+	}
+
+	refreshToken := models.RefreshToken{
+		UserId:    user.Id,
+		TokenHash: hashSecret(token),
+		Expires:   time.Now().Add(refreshTokenDuration),
+	}
+
+	_, err = refreshToken.Create(c, r, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return "", models.RefreshToken{}, err
+	}
+
+	return token, refreshToken, nil
+}
+
+/*
+* Update methods
+ */
+
+// Swaps a refresh token for a new one. If a token that was already swapped
+// is used again it has probably been stolen so every token of the user
+// is revoked.
+func ExchangeRefreshToken(c context.Context, r *http.Request, token string) (models.User, string, error) {
+	refreshToken, err := getRefreshToken(c, token)
+	if err != nil {
+		return models.User{}, "", err
+	}
+
+	if refreshToken.Revoked && refreshToken.ReplacedBy != 0 {
+		log.Warningf(c, "Refresh token %v was used again. Revoking every token for user %v", refreshToken.Id, refreshToken.UserId)
+		RevokeRefreshTokensForUser(c, refreshToken.UserId)
+		return models.User{}, "", errors.New("Invalid refresh token")
+	}
+
+	if !refreshToken.IsValid() {
+		return models.User{}, "", errors.New("Invalid refresh token")
+	}
+
+	user, err := getUserUnauthorized(c, r, refreshToken.UserId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, "", err
+	}
+
+	if user.IsLoginDisabled() {
+		return models.User{}, "", errors.New("Forbidden")
+	}
+
+	newToken, newRefreshToken, err := CreateRefreshTokenForUser(c, r, user)
+	if err != nil {
+		return models.User{}, "", err
+	}
+
+	refreshToken.Revoked = true
+	refreshToken.ReplacedBy = newRefreshToken.Id
+	_, err = refreshToken.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, "", err
+	}
+
+	return user, newToken, nil
+}
+
+/*
+* Delete methods
+ */
+
+func RevokeRefreshToken(c context.Context, token string) error {
+	refreshToken, err := getRefreshToken(c, token)
+	if err != nil {
+		return err
+	}
+
+	refreshToken.Revoked = true
+	_, err = refreshToken.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
+
+func RevokeRefreshTokensForUser(c context.Context, userId int64) error {
+	ks, err := datastore.NewQuery("RefreshToken").Filter("UserId =", userId).Filter("Revoked =", false).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	var refreshTokens []models.RefreshToken
+	refreshTokens = make([]models.RefreshToken, len(ks))
+	err = nds.GetMulti(c, ks, refreshTokens)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	for i := 0; i < len(refreshTokens); i++ {
+		refreshTokens[i].Revoked = true
+		refreshTokens[i].Updated = time.Now()
+	}
+
+	_, err = nds.PutMulti(c, ks, refreshTokens)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
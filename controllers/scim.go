@@ -0,0 +1,967 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/utils"
+
+	"github.com/news-ai/tabulae/sync"
+
+	"github.com/news-ai/web/utilities"
+)
+
+// Most resources returned in a single SCIM list response
+var scimMaxCount = 100
+
+// We only support the eq operator, which is what directories use to look
+// up users and groups before they create them
+var scimFilterRegex = regexp.MustCompile(`^(\S+)\s+(?i:eq)\s+"(.*)"$`)
+
+var scimMemberPathRegex = regexp.MustCompile(`^(?i:members)\[(?i:value)\s+(?i:eq)\s+"(.+)"\]$`)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+// The agency that the SCIM token of the request belongs to
+func getSCIMAgency(r *http.Request) (models.Agency, error) {
+	value, ok := gcontext.GetOk(r, "scimagency")
+	if !ok {
+		return models.Agency{}, models.NewSCIMError(http.StatusUnauthorized, "", "Please provide the SCIM token of your agency")
+	}
+	return value.(models.Agency), nil
+}
+
+// Agencies can only see the users that their directory provisioned, in
+// the email domains that they verified
+func getSCIMUser(c context.Context, r *http.Request, agency models.Agency, id string) (models.User, error) {
+	userId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		return models.User{}, models.NewSCIMError(http.StatusNotFound, "", "User "+id+" not found")
+	}
+
+	user, err := getUserUnauthorized(c, r, userId)
+	if err != nil || user.SCIMAgencyId != agency.Id || !int64InSlice(agency.Id, user.Employers) || !isUserInVerifiedDomain(agency, user) {
+		return models.User{}, models.NewSCIMError(http.StatusNotFound, "", "User "+id+" not found")
+	}
+
+	return user, nil
+}
+
+// Agencies can only see their own teams
+func getSCIMTeam(c context.Context, agency models.Agency, id string) (models.Team, error) {
+	teamId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		return models.Team{}, models.NewSCIMError(http.StatusNotFound, "", "Group "+id+" not found")
+	}
+
+	team, err := getTeam(c, teamId)
+	if err != nil || team.AgencyId != agency.Id {
+		return models.Team{}, models.NewSCIMError(http.StatusNotFound, "", "Group "+id+" not found")
+	}
+
+	return team, nil
+}
+
+// Teams from the directory of an agency are capped by the plan of the
+// administrator of the agency, the same as teams that users create
+func getSCIMTeamMaximumMembers(c context.Context, r *http.Request, agency models.Agency) (int, error) {
+	if len(agency.Administrators) == 0 {
+		return 0, models.NewSCIMError(http.StatusForbidden, "", "Your agency needs an administrator on a paid plan to create teams")
+	}
+
+	administrator, err := getUserUnauthorized(c, r, agency.Administrators[0])
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, models.NewSCIMError(http.StatusForbidden, "", "Your agency needs an administrator on a paid plan to create teams")
+	}
+
+	maxMembers, err := getUserMaximumTeamMembers(c, r, administrator)
+	if err != nil {
+		return 0, models.NewSCIMError(http.StatusForbidden, "", err.Error())
+	}
+	return maxMembers, nil
+}
+
+func getSCIMPagination(r *http.Request) (int, int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 || count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	return startIndex, count
+}
+
+// Returns the attribute and value of a filter. Attribute names in SCIM
+// are case insensitive.
+func parseSCIMFilter(filter string) (string, string, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return "", "", nil
+	}
+
+	matches := scimFilterRegex.FindStringSubmatch(filter)
+	if len(matches) != 3 {
+		return "", "", models.NewSCIMError(http.StatusBadRequest, "invalidFilter", "Only eq filters are supported")
+	}
+
+	return strings.ToLower(matches[1]), matches[2], nil
+}
+
+func pageSCIMKeys(ks []*datastore.Key, startIndex int, count int) []*datastore.Key {
+	start := startIndex - 1
+	if start > len(ks) {
+		start = len(ks)
+	}
+
+	end := start + count
+	if end > len(ks) {
+		end = len(ks)
+	}
+
+	return ks[start:end]
+}
+
+/*
+* Format methods
+ */
+
+func scimLocation(resource string, id int64) string {
+	return utils.BASEURL + "/scim/v2/" + resource + "/" + strconv.FormatInt(id, 10)
+}
+
+func userToSCIM(user models.User) models.SCIMUser {
+	active := !user.SCIMDeactivated
+	scimUser := models.SCIMUser{
+		Schemas:    []string{models.SCIMSchemaUser},
+		Id:         strconv.FormatInt(user.Id, 10),
+		ExternalId: user.SCIMExternalId,
+		UserName:   user.Email,
+		Name: models.SCIMName{
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		Emails: []models.SCIMEmail{
+			{
+				Value:   user.Email,
+				Type:    "work",
+				Primary: true,
+			},
+		},
+		Active: &active,
+		Meta: &models.SCIMMeta{
+			ResourceType: "User",
+			Created:      user.Created,
+			LastModified: user.Updated,
+			Location:     scimLocation("Users", user.Id),
+		},
+	}
+
+	if user.TeamId != 0 {
+		scimUser.Groups = []models.SCIMReference{
+			{
+				Value: strconv.FormatInt(user.TeamId, 10),
+			},
+		}
+	}
+
+	return scimUser
+}
+
+func teamToSCIM(team models.Team) models.SCIMGroup {
+	members := []models.SCIMReference{}
+	for i := 0; i < len(team.Members); i++ {
+		members = append(members, models.SCIMReference{
+			Value: strconv.FormatInt(team.Members[i], 10),
+		})
+	}
+
+	return models.SCIMGroup{
+		Schemas:     []string{models.SCIMSchemaGroup},
+		Id:          strconv.FormatInt(team.Id, 10),
+		ExternalId:  team.SCIMExternalId,
+		DisplayName: team.Name,
+		Members:     members,
+		Meta: &models.SCIMMeta{
+			ResourceType: "Group",
+			Created:      team.Created,
+			LastModified: team.Updated,
+			Location:     scimLocation("Groups", team.Id),
+		},
+	}
+}
+
+/*
+* Update methods
+ */
+
+// Some directories send booleans as strings
+func scimBool(value interface{}) (bool, bool) {
+	switch value := value.(type) {
+	case bool:
+		return value, true
+	case string:
+		active, err := strconv.ParseBool(value)
+		return active, err == nil
+	}
+	return false, false
+}
+
+func scimString(value interface{}) string {
+	stringValue, _ := value.(string)
+	return stringValue
+}
+
+// The email of a SCIM user is their userName. The primary email is used
+// when the userName is not an email.
+func scimUserEmail(scimUser models.SCIMUser) string {
+	if strings.Contains(scimUser.UserName, "@") {
+		return strings.ToLower(scimUser.UserName)
+	}
+
+	for i := 0; i < len(scimUser.Emails); i++ {
+		if scimUser.Emails[i].Primary || len(scimUser.Emails) == 1 {
+			return strings.ToLower(scimUser.Emails[i].Value)
+		}
+	}
+	return ""
+}
+
+// Active users get a seat on the plan of the agency when its seats are
+// synced, or their access back when they have a plan of their own.
+// Deactivated users lose their access, are logged out and their api keys
+// are revoked.
+func setSCIMUserActive(c context.Context, r *http.Request, agency models.Agency, user *models.User, active bool) {
+	wasDeactivated := user.SCIMDeactivated
+	user.SCIMDeactivated = !active
+	if active {
+		if wasDeactivated && hasOwnActiveBilling(c, r, *user) {
+			user.IsActive = true
+		}
+		return
+	}
+
+	user.IsActive = false
+	RevokeSessionsForUser(c, user.Id)
+	RevokeApiKeysForUser(c, user)
+}
+
+// Saves a user and updates the seats of the agency, since the user can
+// have taken or given back a seat
+func saveSCIMUser(c context.Context, r *http.Request, agency models.Agency, user *models.User) {
+	SaveUser(c, r, user)
+
+	err := syncAgencySeats(c, r, agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+}
+
+func updateSCIMUserAttribute(c context.Context, r *http.Request, agency models.Agency, user *models.User, attribute string, value interface{}) error {
+	switch strings.ToLower(attribute) {
+	case "active":
+		active, ok := scimBool(value)
+		if !ok {
+			return models.NewSCIMError(http.StatusBadRequest, "invalidValue", "active has to be a boolean")
+		}
+		setSCIMUserActive(c, r, agency, user, active)
+	case "externalid":
+		user.SCIMExternalId = scimString(value)
+	case "name.givenname":
+		user.FirstName = scimString(value)
+	case "name.familyname":
+		user.LastName = scimString(value)
+	case "name":
+		name, _ := value.(map[string]interface{})
+		for nameAttribute, nameValue := range name {
+			err := updateSCIMUserAttribute(c, r, agency, user, "name."+nameAttribute, nameValue)
+			if err != nil {
+				return err
+			}
+		}
+	case "username":
+		if strings.ToLower(scimString(value)) != user.Email {
+			return models.NewSCIMError(http.StatusBadRequest, "mutability", "The userName of a user can't be changed")
+		}
+	}
+
+	// Attributes we don't store are ignored
+	return nil
+}
+
+// The ids of the members in the value of a group patch operation
+func scimMemberIds(value interface{}) []string {
+	ids := []string{}
+
+	members, ok := value.([]interface{})
+	if !ok {
+		members = []interface{}{value}
+	}
+
+	for i := 0; i < len(members); i++ {
+		member, ok := members[i].(map[string]interface{})
+		if ok && scimString(member["value"]) != "" {
+			ids = append(ids, scimString(member["value"]))
+		}
+	}
+	return ids
+}
+
+func addSCIMMembersToTeam(c context.Context, r *http.Request, agency models.Agency, team *models.Team, ids []string) error {
+	for i := 0; i < len(ids); i++ {
+		user, err := getSCIMUser(c, r, agency, ids[i])
+		if err != nil {
+			return err
+		}
+
+		err = addUserToTeam(c, r, team, &user)
+		if err != nil {
+			return models.NewSCIMError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+	}
+	return nil
+}
+
+func removeSCIMMembersFromTeam(c context.Context, r *http.Request, team *models.Team, ids []string) error {
+	for i := 0; i < len(ids); i++ {
+		// Members that have left the agency can still be removed
+		userId, err := utilities.StringIdToInt(ids[i])
+		if err != nil || !team.IsMember(userId) {
+			continue
+		}
+
+		user, err := getUserUnauthorized(c, r, userId)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			team.RemoveMember(userId)
+			continue
+		}
+
+		err = removeUserFromTeam(c, r, team, &user)
+		if err != nil {
+			return models.NewSCIMError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+	}
+	return nil
+}
+
+// Makes the members of a team the same as the members sent by the
+// directory
+func replaceSCIMMembersOfTeam(c context.Context, r *http.Request, agency models.Agency, team *models.Team, ids []string) error {
+	removeIds := []string{}
+	for i := 0; i < len(team.Members); i++ {
+		memberId := strconv.FormatInt(team.Members[i], 10)
+		if !stringInSlice(memberId, ids) {
+			removeIds = append(removeIds, memberId)
+		}
+	}
+
+	err := removeSCIMMembersFromTeam(c, r, team, removeIds)
+	if err != nil {
+		return err
+	}
+
+	return addSCIMMembersToTeam(c, r, agency, team, ids)
+}
+
+func updateSCIMTeamAttribute(c context.Context, r *http.Request, agency models.Agency, team *models.Team, op string, attribute string, value interface{}) error {
+	// Removing a single member: members[value eq "id"]
+	matches := scimMemberPathRegex.FindStringSubmatch(attribute)
+	if len(matches) == 2 {
+		if op != "remove" {
+			return models.NewSCIMError(http.StatusBadRequest, "invalidPath", "Members can only be removed by value")
+		}
+		return removeSCIMMembersFromTeam(c, r, team, []string{matches[1]})
+	}
+
+	switch strings.ToLower(attribute) {
+	case "displayname":
+		if scimString(value) == "" {
+			return models.NewSCIMError(http.StatusBadRequest, "invalidValue", "displayName can't be blank")
+		}
+		team.Name = scimString(value)
+	case "externalid":
+		team.SCIMExternalId = scimString(value)
+	case "members":
+		switch op {
+		case "add":
+			return addSCIMMembersToTeam(c, r, agency, team, scimMemberIds(value))
+		case "remove":
+			// Removing members without a value removes everyone
+			if value == nil {
+				return replaceSCIMMembersOfTeam(c, r, agency, team, []string{})
+			}
+			return removeSCIMMembersFromTeam(c, r, team, scimMemberIds(value))
+		case "replace":
+			return replaceSCIMMembersOfTeam(c, r, agency, team, scimMemberIds(value))
+		}
+	}
+
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// Gets the agency a SCIM token belongs to
+func GetAgencyFromSCIMToken(c context.Context, token string) (models.Agency, error) {
+	if token == "" {
+		return models.Agency{}, errors.New("No SCIM token")
+	}
+	return filterAgency(c, "SCIMTokenHash", hashSecret(token))
+}
+
+func GetSCIMUsers(c context.Context, r *http.Request) (models.SCIMListResponse, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMListResponse{}, err
+	}
+
+	attribute, value, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		return models.SCIMListResponse{}, err
+	}
+
+	query := datastore.NewQuery("User").Filter("SCIMAgencyId =", agency.Id)
+	switch attribute {
+	case "":
+	case "username", "emails.value":
+		query = query.Filter("Email =", strings.ToLower(value))
+	case "externalid":
+		query = query.Filter("SCIMExternalId =", value)
+	default:
+		return models.SCIMListResponse{}, models.NewSCIMError(http.StatusBadRequest, "invalidFilter", "Users can only be filtered by userName or externalId")
+	}
+
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMListResponse{}, err
+	}
+
+	startIndex, count := getSCIMPagination(r)
+	pageKeys := pageSCIMKeys(ks, startIndex, count)
+
+	users := make([]models.User, len(pageKeys))
+	err = nds.GetMulti(c, pageKeys, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMListResponse{}, err
+	}
+
+	scimUsers := []models.SCIMUser{}
+	for i := 0; i < len(users); i++ {
+		users[i].Format(pageKeys[i], "users")
+		scimUsers = append(scimUsers, userToSCIM(users[i]))
+	}
+
+	return models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: len(ks),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(scimUsers),
+		Resources:    scimUsers,
+	}, nil
+}
+
+func GetSCIMUser(c context.Context, r *http.Request, id string) (models.SCIMUser, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	user, err := getSCIMUser(c, r, agency, id)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	return userToSCIM(user), nil
+}
+
+func GetSCIMGroups(c context.Context, r *http.Request) (models.SCIMListResponse, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMListResponse{}, err
+	}
+
+	attribute, value, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		return models.SCIMListResponse{}, err
+	}
+
+	query := datastore.NewQuery("Team").Filter("AgencyId =", agency.Id)
+	switch attribute {
+	case "":
+	case "displayname":
+		query = query.Filter("Name =", value)
+	case "externalid":
+		query = query.Filter("SCIMExternalId =", value)
+	default:
+		return models.SCIMListResponse{}, models.NewSCIMError(http.StatusBadRequest, "invalidFilter", "Groups can only be filtered by displayName or externalId")
+	}
+
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMListResponse{}, err
+	}
+
+	startIndex, count := getSCIMPagination(r)
+	pageKeys := pageSCIMKeys(ks, startIndex, count)
+
+	teams := make([]models.Team, len(pageKeys))
+	err = nds.GetMulti(c, pageKeys, teams)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMListResponse{}, err
+	}
+
+	scimGroups := []models.SCIMGroup{}
+	for i := 0; i < len(teams); i++ {
+		teams[i].Format(pageKeys[i], "teams")
+		scimGroups = append(scimGroups, teamToSCIM(teams[i]))
+	}
+
+	return models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: len(ks),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(scimGroups),
+		Resources:    scimGroups,
+	}, nil
+}
+
+func GetSCIMGroup(c context.Context, r *http.Request, id string) (models.SCIMGroup, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	team, err := getSCIMTeam(c, agency, id)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	return teamToSCIM(team), nil
+}
+
+/*
+* Create methods
+ */
+
+// Generates a new SCIM token for an agency. Any token the agency had
+// before stops working.
+func CreateAgencySCIMToken(c context.Context, r *http.Request, id string) (models.AgencySCIMToken, interface{}, error) {
+	agency, currentUser, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.AgencySCIMToken{}, nil, err
+	}
+
+	err = requireVerifiedDomain(c, currentUser, agency)
+	if err != nil {
+		return models.AgencySCIMToken{}, nil, err
+	}
+
+	token, err := generateSecret("nsc_")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencySCIMToken{}, nil, err
+	}
+
+	agency.SCIMTokenHash = hashSecret(token)
+	agency.SCIMTokenPrefix = token[:12]
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.AgencySCIMToken{}, nil, err
+	}
+
+	return models.AgencySCIMToken{
+		Token:  token,
+		Prefix: agency.SCIMTokenPrefix,
+	}, nil, nil
+}
+
+// Provisions a user for an agency. Users that already exist are only
+// linked to the directory when they opted into the single sign-on of the
+// agency.
+func CreateSCIMUser(c context.Context, r *http.Request) (models.SCIMUser, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var scimUser models.SCIMUser
+	err = decoder.Decode(buf, &scimUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	email := scimUserEmail(scimUser)
+	emailDomain, err := utilities.ExtractEmailExtension(email)
+	if err != nil || !agency.HasEmailDomain(emailDomain) || isPublicEmailDomain(emailDomain) || !agency.IsDomainVerified(emailDomain) {
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "The userName has to be an email in a domain that your agency has verified")
+	}
+
+	user, err := GetUserByEmail(c, email)
+	isNewUser := err != nil
+	if !isNewUser && (user.SCIMAgencyId == agency.Id || user.SSOAgencyId != agency.Id || !int64InSlice(agency.Id, user.Employers)) {
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusConflict, "uniqueness", "A user with this userName already exists")
+	}
+
+	if isNewUser {
+		user = models.User{}
+		user.Email = email
+		user.EmailConfirmed = true
+		user.SSOAgencyId = agency.Id
+	}
+
+	user.FirstName = scimUser.Name.GivenName
+	user.LastName = scimUser.Name.FamilyName
+	user.SCIMExternalId = scimUser.ExternalId
+	user.SCIMAgencyId = agency.Id
+	addEmployer(&user, agency.Id)
+
+	if isNewUser {
+		_, err = user.Create(c, r)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.SCIMUser{}, err
+		}
+		sync.ResourceSync(r, user.Id, "User", "create")
+	}
+
+	setSCIMUserActive(c, r, agency, &user, scimUser.Active == nil || *scimUser.Active)
+	saveSCIMUser(c, r, agency, &user)
+
+	return userToSCIM(user), nil
+}
+
+func CreateSCIMGroup(c context.Context, r *http.Request) (models.SCIMGroup, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var scimGroup models.SCIMGroup
+	err = decoder.Decode(buf, &scimGroup)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	if scimGroup.DisplayName == "" {
+		return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "displayName can't be blank")
+	}
+
+	maxMembers, err := getSCIMTeamMaximumMembers(c, r, agency)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	if len(scimGroup.Members) > maxMembers {
+		return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "The number of members is greater than the allowed number of members")
+	}
+
+	// Check the members before the team is created
+	memberIds := []string{}
+	for i := 0; i < len(scimGroup.Members); i++ {
+		user, err := getSCIMUser(c, r, agency, scimGroup.Members[i].Value)
+		if err != nil {
+			return models.SCIMGroup{}, err
+		}
+
+		if user.TeamId != 0 {
+			return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "User "+scimGroup.Members[i].Value+" is already a member of another team")
+		}
+
+		memberIds = append(memberIds, scimGroup.Members[i].Value)
+	}
+
+	team := models.Team{}
+	team.Name = scimGroup.DisplayName
+	team.AgencyId = agency.Id
+	team.MaxMembers = maxMembers
+	team.SCIMExternalId = scimGroup.ExternalId
+
+	// Teams from a directory don't have an owner
+	_, err = team.Create(c, r, models.User{})
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMGroup{}, err
+	}
+	audit.Record(r, models.AuditActionTeamCreate, team, nil, team)
+
+	err = addSCIMMembersToTeam(c, r, agency, &team, memberIds)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMGroup{}, err
+	}
+
+	return teamToSCIM(team), nil
+}
+
+/*
+* Update methods
+ */
+
+// Replaces the attributes of a user that we store
+func ReplaceSCIMUser(c context.Context, r *http.Request, id string) (models.SCIMUser, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	user, err := getSCIMUser(c, r, agency, id)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var scimUser models.SCIMUser
+	err = decoder.Decode(buf, &scimUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	if scimUserEmail(scimUser) != user.Email {
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "mutability", "The userName of a user can't be changed")
+	}
+
+	user.FirstName = scimUser.Name.GivenName
+	user.LastName = scimUser.Name.FamilyName
+	user.SCIMExternalId = scimUser.ExternalId
+	setSCIMUserActive(c, r, agency, &user, scimUser.Active == nil || *scimUser.Active)
+
+	saveSCIMUser(c, r, agency, &user)
+	return userToSCIM(user), nil
+}
+
+func PatchSCIMUser(c context.Context, r *http.Request, id string) (models.SCIMUser, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	user, err := getSCIMUser(c, r, agency, id)
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var scimPatch models.SCIMPatch
+	err = decoder.Decode(buf, &scimPatch)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	for i := 0; i < len(scimPatch.Operations); i++ {
+		operation := scimPatch.Operations[i]
+		op := strings.ToLower(operation.Op)
+
+		if op == "remove" {
+			// Only the external id can be removed from a user
+			if strings.ToLower(operation.Path) == "externalid" {
+				user.SCIMExternalId = ""
+			}
+			continue
+		}
+
+		if op != "add" && op != "replace" {
+			return models.SCIMUser{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "Unknown operation "+operation.Op)
+		}
+
+		// Operations without a path have the attributes in the value
+		if operation.Path == "" {
+			attributes, _ := operation.Value.(map[string]interface{})
+			for attribute, value := range attributes {
+				err = updateSCIMUserAttribute(c, r, agency, &user, attribute, value)
+				if err != nil {
+					return models.SCIMUser{}, err
+				}
+			}
+			continue
+		}
+
+		err = updateSCIMUserAttribute(c, r, agency, &user, operation.Path, operation.Value)
+		if err != nil {
+			return models.SCIMUser{}, err
+		}
+	}
+
+	saveSCIMUser(c, r, agency, &user)
+	return userToSCIM(user), nil
+}
+
+// Updates the name and members of a team. The same membership rules as
+// the rest of the teams apply.
+func PatchSCIMGroup(c context.Context, r *http.Request, id string) (models.SCIMGroup, error) {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	team, err := getSCIMTeam(c, agency, id)
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var scimPatch models.SCIMPatch
+	err = decoder.Decode(buf, &scimPatch)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	for i := 0; i < len(scimPatch.Operations); i++ {
+		operation := scimPatch.Operations[i]
+		op := strings.ToLower(operation.Op)
+
+		if op != "add" && op != "remove" && op != "replace" {
+			return models.SCIMGroup{}, models.NewSCIMError(http.StatusBadRequest, "invalidValue", "Unknown operation "+operation.Op)
+		}
+
+		// Operations without a path have the attributes in the value
+		if operation.Path == "" {
+			attributes, _ := operation.Value.(map[string]interface{})
+			for attribute, value := range attributes {
+				err = updateSCIMTeamAttribute(c, r, agency, &team, op, attribute, value)
+				if err != nil {
+					break
+				}
+			}
+		} else {
+			err = updateSCIMTeamAttribute(c, r, agency, &team, op, operation.Path, operation.Value)
+		}
+
+		// Members that were changed before the error are kept
+		if err != nil {
+			team.Save(c)
+			return models.SCIMGroup{}, err
+		}
+	}
+
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.SCIMGroup{}, err
+	}
+
+	return teamToSCIM(team), nil
+}
+
+/*
+* Delete methods
+ */
+
+func RevokeAgencySCIMToken(c context.Context, r *http.Request, id string) (models.Agency, interface{}, error) {
+	agency, _, err := getAgencyForAction(c, r, id)
+	if err != nil {
+		return models.Agency{}, nil, err
+	}
+
+	agency.SCIMTokenHash = ""
+	agency.SCIMTokenPrefix = ""
+	_, err = agency.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, nil, err
+	}
+
+	return agency, nil, nil
+}
+
+// Users are never deleted through SCIM. They are deactivated instead.
+func DeactivateSCIMUser(c context.Context, r *http.Request, id string) error {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := getSCIMUser(c, r, agency, id)
+	if err != nil {
+		return err
+	}
+
+	setSCIMUserActive(c, r, agency, &user, false)
+	saveSCIMUser(c, r, agency, &user)
+	return nil
+}
+
+// Only teams that were created through SCIM can be deleted. Their members
+// are taken off the team first.
+func DeleteSCIMGroup(c context.Context, r *http.Request, id string) error {
+	agency, err := getSCIMAgency(r)
+	if err != nil {
+		return err
+	}
+
+	team, err := getSCIMTeam(c, agency, id)
+	if err != nil {
+		return err
+	}
+
+	if team.CreatedBy != 0 {
+		return models.NewSCIMError(http.StatusBadRequest, "mutability", "Only teams that were created by your directory can be deleted")
+	}
+
+	err = replaceSCIMMembersOfTeam(c, r, agency, &team, []string{})
+	if err != nil {
+		return err
+	}
+
+	_, err = team.Delete(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	audit.Record(r, models.AuditActionTeamDelete, team, team, nil)
+
+	return nil
+}
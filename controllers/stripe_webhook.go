@@ -0,0 +1,408 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/notifications"
+	"github.com/news-ai/api/webhooks"
+
+	"github.com/news-ai/tabulae/sync"
+)
+
+var errStripeEventHandled = errors.New("Stripe event was already handled")
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getBillingByStripeId(c context.Context, stripeId string) (models.Billing, error) {
+	ks, err := datastore.NewQuery("Billing").Filter("StripeId =", stripeId).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Billing{}, err
+	}
+
+	if len(ks) == 0 {
+		return models.Billing{}, errors.New("No billing for Stripe customer " + stripeId)
+	}
+
+	var userBilling models.Billing
+	err = nds.Get(c, ks[0], &userBilling)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Billing{}, err
+	}
+
+	userBilling.Format(ks[0], "billings")
+	return userBilling, nil
+}
+
+func getUserByBillingId(c context.Context, billingId int64) (models.User, error) {
+	ks, err := datastore.NewQuery("User").Filter("BillingId =", billingId).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	if len(ks) == 0 {
+		return models.User{}, errors.New("No user for billing")
+	}
+
+	var user models.User
+	err = nds.Get(c, ks[0], &user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	user.Format(ks[0], "users")
+	return user, nil
+}
+
+func stripeBillingAuditValues(user models.User, userBilling models.Billing) map[string]interface{} {
+	return map[string]interface{}{
+		"plan":      userBilling.StripePlanId,
+		"expires":   userBilling.Expires,
+		"isontrial": userBilling.IsOnTrial,
+		"iscancel":  userBilling.IsCancel,
+		"ispastdue": userBilling.IsPastDue,
+		"isactive":  user.IsActive,
+	}
+}
+
+/*
+* Update methods
+ */
+
+func applyStripeInvoicePaid(user *models.User, userBilling *models.Billing, invoice billing.StripeInvoice) {
+	periodEnd := invoice.PeriodEnd()
+	if periodEnd.After(userBilling.Expires) {
+		userBilling.Expires = periodEnd
+	}
+	if periodEnd.After(userBilling.StripePaidThrough) {
+		userBilling.StripePaidThrough = periodEnd
+	}
+
+	if invoice.AmountPaid > 0 {
+		userBilling.IsOnTrial = false
+		userBilling.HasPaid = true
+	}
+
+	billing.ClearDunning(userBilling)
+
+	// Paying doesn't lift a ban
+	if !user.IsBanned {
+		user.IsActive = true
+	}
+}
+
+// Stripe keeps retrying the payment. Users keep access until their grace
+// period ends. Failures for a period that has been paid since are ignored.
+func applyStripeInvoicePaymentFailed(user *models.User, userBilling *models.Billing, invoice billing.StripeInvoice) {
+	periodEnd := invoice.PeriodEnd()
+	if !periodEnd.IsZero() && !periodEnd.After(userBilling.StripePaidThrough) {
+		return
+	}
+
+	billing.StartDunning(userBilling, invoice.AttemptCount)
+}
+
+func applyStripeSubscription(user *models.User, userBilling *models.Billing, subscription billing.StripeSubscription) {
+	if subscription.Plan.Id != "" {
+		userBilling.StripePlanId = billing.StripePlanToPlanId(subscription.Plan.Id)
+	}
+
+	if subscription.CurrentPeriodEnd != 0 {
+		userBilling.Expires = time.Unix(subscription.CurrentPeriodEnd, 0)
+	}
+
+	userBilling.IsCancel = subscription.CancelAtPeriodEnd
+	userBilling.IsOnTrial = subscription.Status == "trialing"
+
+	switch subscription.Status {
+	case "active", "trialing":
+		billing.ClearDunning(userBilling)
+		if !user.IsBanned {
+			user.IsActive = true
+		}
+	case "past_due":
+		billing.StartDunning(userBilling, 0)
+	case "canceled", "unpaid", "incomplete_expired":
+		user.IsActive = false
+	}
+}
+
+func applyStripeSubscriptionDeleted(user *models.User, userBilling *models.Billing, subscription billing.StripeSubscription) {
+	userBilling.IsCancel = true
+	userBilling.IsOnTrial = false
+
+	endedAt := time.Now()
+	if subscription.EndedAt != 0 {
+		endedAt = time.Unix(subscription.EndedAt, 0)
+	}
+	if userBilling.Expires.After(endedAt) {
+		userBilling.Expires = endedAt
+	}
+
+	billing.ClearDunning(userBilling)
+	user.IsActive = false
+}
+
+// Applies an event to a billing and the user it belongs to. Subscription
+// events that are older than the latest one that was applied are ignored,
+// and so are failed payments for invoices that were paid.
+func applyStripeEvent(user *models.User, userBilling *models.Billing, event billing.StripeEvent, invoice billing.StripeInvoice, subscription billing.StripeSubscription) {
+	switch event.Type {
+	case "invoice.paid", "invoice.payment_succeeded":
+		applyStripeInvoicePaid(user, userBilling, invoice)
+	case "invoice.payment_failed":
+		applyStripeInvoicePaymentFailed(user, userBilling, invoice)
+	case "customer.subscription.updated", "customer.subscription.deleted", "customer.subscription.trial_will_end":
+		if event.Created != 0 {
+			eventCreated := time.Unix(event.Created, 0)
+			if eventCreated.Before(userBilling.StripeSubscriptionUpdated) {
+				return
+			}
+			userBilling.StripeSubscriptionUpdated = eventCreated
+		}
+
+		switch event.Type {
+		case "customer.subscription.updated":
+			applyStripeSubscription(user, userBilling, subscription)
+			if userBilling.IsAgency && subscription.Quantity > 0 {
+				userBilling.Seats = subscription.Quantity
+			}
+		case "customer.subscription.deleted":
+			applyStripeSubscriptionDeleted(user, userBilling, subscription)
+		case "customer.subscription.trial_will_end":
+			if subscription.TrialEnd != 0 {
+				userBilling.Expires = time.Unix(subscription.TrialEnd, 0)
+			}
+		}
+	}
+}
+
+// Applies an event and records it as handled in one transaction, so an
+// event that Stripe sends again changes nothing. The billing and the user
+// are loaded again in the transaction in case another event changed them.
+// Agencies have no user to save.
+func saveStripeEvent(c context.Context, event billing.StripeEvent, user *models.User, userBilling *models.Billing, invoice billing.StripeInvoice, subscription billing.StripeSubscription) error {
+	stripeEvent := models.StripeEvent{}
+	stripeEvent.Key = event.Id
+	stripeEvent.Type = event.Type
+	if event.Created != 0 {
+		stripeEvent.EventCreated = time.Unix(event.Created, 0)
+	}
+
+	return nds.RunInTransaction(c, func(tc context.Context) error {
+		var handledEvent models.StripeEvent
+		err := nds.Get(tc, stripeEvent.EventKey(tc), &handledEvent)
+		if err == nil {
+			return errStripeEventHandled
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		billingKey := userBilling.BaseKey(tc, "Billing")
+		err = nds.Get(tc, billingKey, userBilling)
+		if err != nil {
+			return err
+		}
+		userBilling.Format(billingKey, "billings")
+
+		if !userBilling.IsAgency {
+			userKey := user.BaseKey(tc, "User")
+			err = nds.Get(tc, userKey, user)
+			if err != nil {
+				return err
+			}
+			user.Format(userKey, "users")
+		}
+
+		planId := userBilling.StripePlanId
+		isOnTrial := userBilling.IsOnTrial
+		applyStripeEvent(user, userBilling, event, invoice, subscription)
+
+		// Moving to another plan, or paying for the plan after its trial,
+		// subscribes to the limits that the plan has now
+		if userBilling.StripePlanId != planId || (isOnTrial && !userBilling.IsOnTrial && !userBilling.IsCancel) {
+			billing.SetPlanLimits(c, userBilling)
+		}
+
+		_, err = userBilling.Save(tc)
+		if err != nil {
+			return err
+		}
+
+		if !userBilling.IsAgency {
+			_, err = user.Save(tc)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = stripeEvent.Save(tc)
+		return err
+	}, &datastore.TransactionOptions{XG: true, Attempts: 10})
+}
+
+// Trials that Stripe charged for are paid, and subscriptions that Stripe
+// ended are canceled. Subscriptions that users canceled here already
+// triggered user.canceled.
+func triggerStripeWebhooks(r *http.Request, user models.User, previousBilling models.Billing, userBilling models.Billing, event billing.StripeEvent) {
+	if previousBilling.IsOnTrial && !userBilling.IsOnTrial && userBilling.HasPaid && !userBilling.IsCancel {
+		webhooks.Trigger(r, models.WebhookEventUserPaid, user, map[string]interface{}{
+			"plan":    userBilling.StripePlanId,
+			"expires": userBilling.Expires,
+		})
+	}
+
+	if event.Type == "customer.subscription.deleted" && !previousBilling.IsCancel {
+		webhooks.Trigger(r, models.WebhookEventUserCanceled, user, map[string]interface{}{
+			"plan":    userBilling.StripePlanId,
+			"expires": userBilling.Expires,
+		})
+	}
+}
+
+// Agencies are billed for seats instead of a user. Members lose their
+// seats when the subscription of the agency ends.
+func handleAgencyStripeEvent(c context.Context, r *http.Request, event billing.StripeEvent, agencyBilling models.Billing, invoice billing.StripeInvoice, subscription billing.StripeSubscription) error {
+	agency, err := getAgency(c, agencyBilling.AgencyId)
+	if err != nil {
+		log.Infof(c, "%v", err)
+		return nil
+	}
+
+	// Seats are not users, so changes to the placeholder are not saved.
+	// Members get or lose their access once the billing is saved.
+	seatUser := models.User{}
+	before := stripeBillingAuditValues(seatUser, agencyBilling)
+
+	err = saveStripeEvent(c, event, &seatUser, &agencyBilling, invoice, subscription)
+	if err == errStripeEventHandled {
+		return nil
+	}
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	err = UpdateAgencySeatAccess(c, r, agencyBilling)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+	}
+
+	after := stripeBillingAuditValues(seatUser, agencyBilling)
+	after["event"] = event.Type
+	after["eventid"] = event.Id
+	after["seats"] = agencyBilling.Seats
+	audit.RecordAs(r, models.User{}, models.AuditActionStripeEvent, agency, before, after)
+
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Action methods
+ */
+
+// Updates the billing of a user, and if they are active, from an event
+// that Stripe sent us. Events we don't use, and events that were already
+// handled, are ignored.
+func HandleStripeEvent(c context.Context, r *http.Request, event billing.StripeEvent) error {
+	var stripeId string
+	var invoice billing.StripeInvoice
+	var subscription billing.StripeSubscription
+
+	switch event.Type {
+	case "invoice.paid", "invoice.payment_succeeded", "invoice.payment_failed":
+		err := json.Unmarshal(event.Data.Object, &invoice)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return err
+		}
+		stripeId = invoice.Customer
+	case "customer.subscription.updated", "customer.subscription.deleted", "customer.subscription.trial_will_end":
+		err := json.Unmarshal(event.Data.Object, &subscription)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return err
+		}
+		stripeId = subscription.Customer
+	default:
+		return nil
+	}
+
+	if event.Id == "" {
+		return errors.New("Stripe event has no id")
+	}
+
+	userBilling, err := getBillingByStripeId(c, stripeId)
+	if err != nil {
+		// Customers that are not ours, like from the Stripe dashboard
+		log.Infof(c, "%v", err)
+		return nil
+	}
+
+	if userBilling.IsAgency {
+		return handleAgencyStripeEvent(c, r, event, userBilling, invoice, subscription)
+	}
+
+	user, err := getUserByBillingId(c, userBilling.Id)
+	if err != nil {
+		log.Infof(c, "%v", err)
+		return nil
+	}
+
+	before := stripeBillingAuditValues(user, userBilling)
+	previousBilling := userBilling
+
+	err = saveStripeEvent(c, event, &user, &userBilling, invoice, subscription)
+	if err == errStripeEventHandled {
+		return nil
+	}
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	sync.ResourceSync(r, user.Id, "User", "create")
+	triggerStripeWebhooks(r, user, previousBilling, userBilling, event)
+
+	// Only sent the first time Stripe sends the event
+	if event.Type == "customer.subscription.trial_will_end" && subscription.TrialEnd != 0 {
+		err = notifications.TrialWillEnd(c, user, time.Unix(subscription.TrialEnd, 0))
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+
+	after := stripeBillingAuditValues(user, userBilling)
+	after["event"] = event.Type
+	after["eventid"] = event.Id
+	audit.RecordAs(r, models.User{}, models.AuditActionStripeEvent, user, before, after)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+)
+
+func testStripeInvoice(t *testing.T, periodEnd time.Time) billing.StripeInvoice {
+	data := fmt.Sprintf(`{"id":"in_1","amount_paid":1899,"attempt_count":1,"lines":{"data":[{"period":{"start":%d,"end":%d}}]}}`, periodEnd.AddDate(0, -1, 0).Unix(), periodEnd.Unix())
+
+	invoice := billing.StripeInvoice{}
+	err := json.Unmarshal([]byte(data), &invoice)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	return invoice
+}
+
+func TestApplyStripeInvoiceEvents(t *testing.T) {
+	periodEnd := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	invoice := testStripeInvoice(t, periodEnd)
+	paid := billing.StripeEvent{Id: "evt_1", Type: "invoice.paid"}
+	failed := billing.StripeEvent{Id: "evt_2", Type: "invoice.payment_failed"}
+
+	// A failed payment that arrives after the invoice was paid
+	user := models.User{}
+	userBilling := models.Billing{}
+	applyStripeEvent(&user, &userBilling, paid, invoice, billing.StripeSubscription{})
+	applyStripeEvent(&user, &userBilling, failed, invoice, billing.StripeSubscription{})
+	if userBilling.IsPastDue {
+		t.Errorf("a failed payment for a paid invoice started dunning")
+	}
+	if !userBilling.StripePaidThrough.Equal(periodEnd) {
+		t.Errorf("paid through %v, want %v", userBilling.StripePaidThrough, periodEnd)
+	}
+
+	// A failed payment for the next period
+	applyStripeEvent(&user, &userBilling, failed, testStripeInvoice(t, periodEnd.AddDate(0, 1, 0)), billing.StripeSubscription{})
+	if !userBilling.IsPastDue {
+		t.Errorf("a failed payment for an unpaid invoice didn't start dunning")
+	}
+
+	// A failed payment before the invoice was paid
+	user = models.User{}
+	userBilling = models.Billing{}
+	applyStripeEvent(&user, &userBilling, failed, invoice, billing.StripeSubscription{})
+	if !userBilling.IsPastDue {
+		t.Errorf("a failed payment didn't start dunning")
+	}
+	applyStripeEvent(&user, &userBilling, paid, invoice, billing.StripeSubscription{})
+	if userBilling.IsPastDue {
+		t.Errorf("paying the invoice didn't clear dunning")
+	}
+}
@@ -13,7 +13,10 @@ import (
 	"github.com/pquerna/ffjson/ffjson"
 	"github.com/qedus/nds"
 
+	"github.com/news-ai/api/audit"
+	"github.com/news-ai/api/billing"
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
 
 	"github.com/news-ai/web/utilities"
 )
@@ -48,6 +51,121 @@ func getTeam(c context.Context, id int64) (models.Team, error) {
 	return models.Team{}, errors.New("No team by this id")
 }
 
+// Users on a paid plan can create their own team. The size of the team
+// is capped by the plan that they are on.
+func getUserMaximumTeamMembers(c context.Context, r *http.Request, user models.User) (int, error) {
+	_, agencyBilling, err := getAgencyBillingForUser(c, user)
+	if err == nil {
+		return billing.GetBillingPlan(c, agencyBilling).TeamMembers, nil
+	}
+
+	userBilling, err := GetUserBilling(c, r, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, err
+	}
+
+	if !user.IsActive || userBilling.IsOnTrial || userBilling.IsCancel || userBilling.StripePlanId == "free" {
+		return 0, errors.New("You need to be on a paid plan to create a team")
+	}
+
+	return billing.GetBillingPlan(c, userBilling).TeamMembers, nil
+}
+
+// Adds a user to a team if the team has room for them. The caller saves
+// the team.
+func addUserToTeam(c context.Context, r *http.Request, team *models.Team, user *models.User) error {
+	if user.TeamId != 0 && user.TeamId != team.Id {
+		return errors.New("User is already a member of another team")
+	}
+
+	if !team.IsMember(user.Id) {
+		if len(team.Members) >= team.MaxMembers {
+			return errors.New("The team has reached the allowed number of members")
+		}
+		team.AddMember(user.Id)
+		audit.Record(r, models.AuditActionTeamMemberAdd, *team, nil, nil, user.Id)
+	}
+
+	// Members of an agency that pays for seats get access through it once
+	// the seats of the agency are synced
+	if user.TeamId != team.Id {
+		user.TeamId = team.Id
+		SaveUser(c, r, user)
+	}
+	return nil
+}
+
+// Removes a user from a team. The owner of a team can't be removed. The
+// caller saves the team.
+func removeUserFromTeam(c context.Context, r *http.Request, team *models.Team, user *models.User) error {
+	if team.CreatedBy == user.Id {
+		return errors.New("Can't remove the owner of the team. Transfer the ownership first")
+	}
+
+	if user.TeamId == team.Id {
+		user.TeamId = 0
+		SaveUser(c, r, user)
+	}
+
+	if team.IsMember(user.Id) {
+		team.RemoveMember(user.Id)
+		audit.Record(r, models.AuditActionTeamMemberRemove, *team, nil, nil, user.Id)
+	}
+	return nil
+}
+
+// Gets the team and the user the action is being performed on for any
+// of the team membership actions
+func getTeamAndMemberForAction(c context.Context, r *http.Request, id string) (models.Team, models.User, error) {
+	currentId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	team, err := getTeam(c, currentId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, team)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var teamMember models.TeamMember
+	err = decoder.Decode(buf, &teamMember)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	if teamMember.UserId == 0 {
+		return models.Team{}, models.User{}, errors.New("Please provide a userid")
+	}
+
+	// The user could be on another team (or no team) so we can't
+	// use getUser here since it checks that they are on the same team.
+	user, err := getUserUnauthorized(c, r, teamMember.UserId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, models.User{}, err
+	}
+
+	return team, user, nil
+}
+
 /*
 * Public methods
  */
@@ -64,8 +182,10 @@ func GetTeams(c context.Context, r *http.Request) ([]models.Team, interface{}, i
 		return []models.Team{}, nil, 0, 0, err
 	}
 
-	if !user.IsAdmin {
-		return []models.Team{}, nil, 0, 0, errors.New("Forbidden")
+	err = policy.Authorize(c, user, policy.ActionRead, policy.Collection("Team"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.Team{}, nil, 0, 0, err
 	}
 
 	query := datastore.NewQuery("Team")
@@ -91,7 +211,7 @@ func GetTeams(c context.Context, r *http.Request) ([]models.Team, interface{}, i
 	return teams, nil, len(teams), 0, nil
 }
 
-func GetTeam(c context.Context, id string) (models.Team, interface{}, error) {
+func GetTeam(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
 	// Get the details of the current team
 	currentId, err := utilities.StringIdToInt(id)
 	if err != nil {
@@ -104,6 +224,19 @@ func GetTeam(c context.Context, id string) (models.Team, interface{}, error) {
 		log.Errorf(c, "%v", err)
 		return models.Team{}, nil, err
 	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, nil, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionRead, team)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, nil, err
+	}
+
 	return team, nil, nil
 }
 
@@ -120,10 +253,6 @@ func CreateTeam(c context.Context, r *http.Request) ([]models.Team, interface{},
 		return []models.Team{}, nil, err
 	}
 
-	if !currentUser.IsAdmin {
-		return []models.Team{}, nil, errors.New("Forbidden")
-	}
-
 	decoder := ffjson.NewDecoder()
 	var team models.Team
 	err = decoder.Decode(buf, &team)
@@ -132,6 +261,44 @@ func CreateTeam(c context.Context, r *http.Request) ([]models.Team, interface{},
 		return []models.Team{}, nil, err
 	}
 
+	// Users that are not admins can only create a team for themselves.
+	// Other members join the team through team invitations.
+	if !policy.Can(c, currentUser, policy.ActionCreate, policy.Collection("Team")) {
+		if currentUser.TeamId != 0 {
+			return []models.Team{}, nil, errors.New("You are already a member of a team")
+		}
+
+		maxMembers, err := getUserMaximumTeamMembers(c, r, currentUser)
+		if err != nil {
+			return []models.Team{}, nil, err
+		}
+
+		if team.MaxMembers == 0 || team.MaxMembers > maxMembers {
+			team.MaxMembers = maxMembers
+		}
+
+		// Teams of an agency take seats on its plan, so they are only
+		// part of the agency the creator is a verified employee of. Any
+		// other agency has to approve the team first.
+		requestedAgencyId := team.AgencyId
+		team.AgencyId = 0
+		team.RequestedAgencyId = 0
+
+		verifiedAgency, err := getVerifiedEmployer(c, currentUser)
+		if err == nil && (requestedAgencyId == 0 || requestedAgencyId == verifiedAgency.Id) {
+			team.AgencyId = verifiedAgency.Id
+		} else if requestedAgencyId != 0 {
+			_, err = getAgency(c, requestedAgencyId)
+			if err != nil {
+				return []models.Team{}, nil, errors.New("No agency by this id")
+			}
+			team.RequestedAgencyId = requestedAgencyId
+		}
+
+		team.Members = []int64{currentUser.Id}
+		team.Admins = []int64{currentUser.Id}
+	}
+
 	if len(team.Members) > team.MaxMembers {
 		return []models.Team{}, nil, errors.New("The number of members is greater than the allowed number of members")
 	}
@@ -167,6 +334,255 @@ func CreateTeam(c context.Context, r *http.Request) ([]models.Team, interface{},
 	team.Members = confirmMembers
 	team.Admins = confirmAdmins
 	team.Save(c)
+	audit.Record(r, models.AuditActionTeamCreate, team, nil, team, team.Members...)
+
+	err = SyncAgencySeatsForTeam(c, r, team)
+	if err != nil {
+		return []models.Team{team}, nil, err
+	}
 
 	return []models.Team{team}, nil, nil
 }
+
+/*
+* Action methods
+ */
+
+func AddMemberToTeam(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if team.IsMember(user.Id) {
+		return team, nil, errors.New("User is already a member of this team")
+	}
+
+	// Joining a team lets its admins see the work of the user, so users
+	// are only added without an invitation when they are verified
+	// employees of the agency of the team
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	if !isPlatformAdmin(c, currentUser) && !isVerifiedEmployee(c, team.AgencyId, user) {
+		return team, nil, errors.New("Please invite this user to the team instead")
+	}
+
+	err = addUserToTeam(c, r, &team, &user)
+	if err != nil {
+		return team, nil, err
+	}
+
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	err = SyncAgencySeatsForTeam(c, r, team)
+	if err != nil {
+		return team, nil, err
+	}
+
+	return team, nil, nil
+}
+
+func RemoveMemberFromTeam(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsMember(user.Id) {
+		return team, nil, errors.New("User is not a member of this team")
+	}
+
+	err = removeUserFromTeam(c, r, &team, &user)
+	if err != nil {
+		return team, nil, err
+	}
+
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	err = SyncAgencySeatsForTeam(c, r, team)
+	if err != nil {
+		return team, nil, err
+	}
+
+	return team, nil, nil
+}
+
+func PromoteTeamAdmin(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsMember(user.Id) {
+		return team, nil, errors.New("User is not a member of this team")
+	}
+
+	if team.IsAdmin(user.Id) {
+		return team, nil, errors.New("User is already an admin of this team")
+	}
+
+	team.RemoveReadOnly(user.Id)
+	team.AddAdmin(user.Id)
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamAdminAdd, team, nil, nil, user.Id)
+
+	return team, nil, nil
+}
+
+func DemoteTeamAdmin(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsAdmin(user.Id) {
+		return team, nil, errors.New("User is not an admin of this team")
+	}
+
+	if team.CreatedBy == user.Id {
+		return team, nil, errors.New("Can't demote the owner of the team. Transfer the ownership first")
+	}
+
+	if len(team.Admins) == 1 {
+		return team, nil, errors.New("A team needs to have at least one admin")
+	}
+
+	team.RemoveAdmin(user.Id)
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamAdminRemove, team, nil, nil, user.Id)
+
+	return team, nil, nil
+}
+
+func TransferTeamOwnership(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsMember(user.Id) {
+		return team, nil, errors.New("User is not a member of this team")
+	}
+
+	if team.CreatedBy == user.Id {
+		return team, nil, errors.New("User is already the owner of this team")
+	}
+
+	// The new owner of the team is always an admin of the team
+	previousOwner := team.CreatedBy
+	team.CreatedBy = user.Id
+	team.RemoveReadOnly(user.Id)
+	team.AddAdmin(user.Id)
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamOwnerTransfer, team, map[string]int64{"owner": previousOwner}, map[string]int64{"owner": user.Id}, previousOwner, user.Id)
+
+	return team, nil, nil
+}
+
+func MakeTeamMemberReadOnly(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsMember(user.Id) {
+		return team, nil, errors.New("User is not a member of this team")
+	}
+
+	if team.IsAdmin(user.Id) {
+		return team, nil, errors.New("Admins of the team can't be read-only. Demote them first")
+	}
+
+	team.AddReadOnly(user.Id)
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamReadOnlyAdd, team, nil, nil, user.Id)
+
+	return team, nil, nil
+}
+
+func RemoveTeamMemberReadOnly(c context.Context, r *http.Request, id string) (models.Team, interface{}, error) {
+	team, user, err := getTeamAndMemberForAction(c, r, id)
+	if err != nil {
+		return models.Team{}, nil, err
+	}
+
+	if !team.IsReadOnly(user.Id) {
+		return team, nil, errors.New("User is not a read-only member of this team")
+	}
+
+	team.RemoveReadOnly(user.Id)
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return team, nil, err
+	}
+
+	audit.Record(r, models.AuditActionTeamReadOnlyRemove, team, nil, nil, user.Id)
+
+	return team, nil, nil
+}
+
+// Adds a user that signed up through a team invitation to that team
+func AddUserToTeamFromInvite(c context.Context, r *http.Request, user *models.User, invite models.UserInviteCode) error {
+	if invite.TeamId == 0 {
+		return nil
+	}
+
+	team, err := getTeam(c, invite.TeamId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	err = addUserToTeam(c, r, &team, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = team.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	err = SyncAgencySeatsForTeam(c, r, team)
+
+	// The user could have been given a seat on the plan of the agency
+	updatedUser, getErr := getUserUnauthorized(c, r, user.Id)
+	if getErr == nil {
+		*user = updatedUser
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+)
+
+// TOTP settings from RFC 6238. These are what authenticator apps expect.
+var (
+	twoFactorStep        = int64(30)
+	twoFactorDigits      = 6
+	twoFactorSkew        = int64(1)
+	twoFactorBackupCodes = 10
+)
+
+/*
+* Private methods
+ */
+
+func generateTwoFactorSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+func generateTwoFactorBackupCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func twoFactorCodeAt(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < twoFactorDigits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", twoFactorDigits, value%modulo), nil
+}
+
+// Returns the time step that the code is valid for at a time or 0 if it
+// isn't valid. Codes from one step before or after are allowed for clock
+// drift.
+func validateTwoFactorCodeForSecret(secret string, code string, now time.Time) int64 {
+	currentStep := now.Unix() / twoFactorStep
+	for step := currentStep - twoFactorSkew; step <= currentStep+twoFactorSkew; step++ {
+		expected, err := twoFactorCodeAt(secret, step)
+		if err != nil {
+			return 0
+		}
+
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+func decodeTwoFactorCode(c context.Context, r *http.Request) (string, error) {
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var twoFactorCode models.UserTwoFactorCode
+	err := decoder.Decode(buf, &twoFactorCode)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return "", err
+	}
+
+	if twoFactorCode.Code == "" {
+		return "", errors.New("Please enter a code from your authenticator app")
+	}
+
+	return twoFactorCode.Code, nil
+}
+
+func clearTwoFactor(user *models.User) {
+	user.TwoFactorEnabled = false
+	user.TwoFactorSecret = ""
+	user.TwoFactorPendingSecret = ""
+	user.TwoFactorBackupCodes = []string{}
+	user.TwoFactorLastStep = 0
+}
+
+/*
+* Public methods
+ */
+
+// Checks a code from an authenticator app or one of the backup codes of a
+// user. Used codes are saved on the user so they can't be used again.
+func ValidateTwoFactorCode(c context.Context, user *models.User, code string) bool {
+	code = strings.TrimSpace(strings.Replace(code, " ", "", -1))
+	if !user.TwoFactorEnabled || code == "" {
+		return false
+	}
+
+	step := validateTwoFactorCodeForSecret(user.TwoFactorSecret, code, time.Now())
+	if step != 0 && step > user.TwoFactorLastStep {
+		user.TwoFactorLastStep = step
+		user.Save(c)
+		return true
+	}
+
+	hashedCode := hashSecret(strings.ToLower(code))
+	for i := 0; i < len(user.TwoFactorBackupCodes); i++ {
+		if hmac.Equal([]byte(user.TwoFactorBackupCodes[i]), []byte(hashedCode)) {
+			user.TwoFactorBackupCodes = append(user.TwoFactorBackupCodes[:i], user.TwoFactorBackupCodes[i+1:]...)
+			user.Save(c)
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+* Update methods
+ */
+
+// Starts enrolling a user in two-factor authentication. The secret is only
+// used once the user confirms it with a code from their authenticator app.
+func EnrollTwoFactorForUser(c context.Context, r *http.Request, id string) (models.UserTwoFactor, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	// Only the user themselves can enroll their authenticator app
+	if user.Id != currentUser.Id {
+		return models.UserTwoFactor{}, nil, errors.New("Forbidden")
+	}
+
+	if user.TwoFactorEnabled {
+		return models.UserTwoFactor{}, nil, errors.New("Two-factor authentication is already enabled")
+	}
+
+	secret, err := generateTwoFactorSecret()
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	user.TwoFactorPendingSecret = secret
+	_, err = user.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	// Authenticator apps scan this URL as a QR code
+	otpAuthURL := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/NewsAI:" + user.Email,
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", "NewsAI")
+	otpAuthURL.RawQuery = query.Encode()
+
+	twoFactor := models.UserTwoFactor{
+		Enabled:    false,
+		Secret:     secret,
+		OTPAuthURL: otpAuthURL.String(),
+	}
+	return twoFactor, nil, nil
+}
+
+// Turns on two-factor authentication once the user has entered a valid code
+// for the secret they enrolled with. The backup codes are only shown here.
+func ConfirmTwoFactorForUser(c context.Context, r *http.Request, id string) (models.UserTwoFactor, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	if user.Id != currentUser.Id {
+		return models.UserTwoFactor{}, nil, errors.New("Forbidden")
+	}
+
+	if user.TwoFactorPendingSecret == "" {
+		return models.UserTwoFactor{}, nil, errors.New("Please start enrolling in two-factor authentication first")
+	}
+
+	code, err := decodeTwoFactorCode(c, r)
+	if err != nil {
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	step := validateTwoFactorCodeForSecret(user.TwoFactorPendingSecret, code, time.Now())
+	if step == 0 {
+		return models.UserTwoFactor{}, nil, errors.New("The code you entered is not valid")
+	}
+
+	backupCodes := []string{}
+	hashedBackupCodes := []string{}
+	for i := 0; i < twoFactorBackupCodes; i++ {
+		backupCode, err := generateTwoFactorBackupCode()
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.UserTwoFactor{}, nil, err
+		}
+		backupCodes = append(backupCodes, backupCode)
+		hashedBackupCodes = append(hashedBackupCodes, hashSecret(backupCode))
+	}
+
+	user.TwoFactorEnabled = true
+	user.TwoFactorSecret = user.TwoFactorPendingSecret
+	user.TwoFactorPendingSecret = ""
+	user.TwoFactorBackupCodes = hashedBackupCodes
+	user.TwoFactorLastStep = step
+	_, err = user.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserTwoFactor{}, nil, err
+	}
+
+	twoFactor := models.UserTwoFactor{
+		Enabled:     true,
+		BackupCodes: backupCodes,
+	}
+	return twoFactor, nil, nil
+}
+
+// Users can turn off two-factor authentication with a valid code
+func DisableTwoFactorForUser(c context.Context, r *http.Request, id string) (models.User, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	if user.Id != currentUser.Id {
+		return models.User{}, nil, errors.New("Forbidden")
+	}
+
+	if !user.TwoFactorEnabled {
+		return user, nil, errors.New("Two-factor authentication is not enabled")
+	}
+
+	code, err := decodeTwoFactorCode(c, r)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	if !ValidateTwoFactorCode(c, &user, code) {
+		return models.User{}, nil, errors.New("The code you entered is not valid")
+	}
+
+	clearTwoFactor(&user)
+	_, err = user.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	return user, nil, nil
+}
+
+// Admins can reset two-factor authentication for a user that lost their
+// authenticator app and backup codes
+func ResetTwoFactorForUser(c context.Context, r *http.Request, id string) (models.User, interface{}, error) {
+	user, currentUser, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	clearTwoFactor(&user)
+	_, err = user.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, nil, err
+	}
+
+	return user, nil, nil
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 secret from the test vectors in RFC 6238
+var rfcTwoFactorSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTwoFactorCodeAt(t *testing.T) {
+	// RFC 6238 gives 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{time: 59, code: "287082"},
+		{time: 1111111109, code: "081804"},
+		{time: 1111111111, code: "050471"},
+		{time: 1234567890, code: "005924"},
+		{time: 2000000000, code: "279037"},
+		{time: 20000000000, code: "353130"},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		code, err := twoFactorCodeAt(rfcTwoFactorSecret, tests[i].time/twoFactorStep)
+		if err != nil {
+			t.Fatalf("twoFactorCodeAt: %v", err)
+		}
+		if code != tests[i].code {
+			t.Errorf("code at %d is %q, want %q", tests[i].time, code, tests[i].code)
+		}
+	}
+
+	// Authenticator apps can give the secret in lower case
+	code, err := twoFactorCodeAt(strings.ToLower(rfcTwoFactorSecret), 59/twoFactorStep)
+	if err != nil || code != "287082" {
+		t.Errorf("code for a lower case secret is %q, %v", code, err)
+	}
+
+	if _, err := twoFactorCodeAt("not base32!", 1); err == nil {
+		t.Errorf("twoFactorCodeAt should fail for an invalid secret")
+	}
+}
+
+func TestValidateTwoFactorCodeForSecret(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	currentStep := now.Unix() / twoFactorStep
+
+	tests := []struct {
+		name string
+		at   time.Time
+		code string
+		step int64
+	}{
+		{name: "current code", at: now, code: "081804", step: currentStep},
+		{name: "code from the step before", at: now.Add(30 * time.Second), code: "081804", step: currentStep},
+		{name: "code from the step after", at: now.Add(-30 * time.Second), code: "081804", step: currentStep},
+		{name: "code from two steps before", at: now.Add(60 * time.Second), code: "081804", step: 0},
+		{name: "code from two steps after", at: now.Add(-60 * time.Second), code: "081804", step: 0},
+		{name: "wrong code", at: now, code: "000000", step: 0},
+		{name: "blank code", at: now, code: "", step: 0},
+		{name: "8 digit code", at: now, code: "07081804", step: 0},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		step := validateTwoFactorCodeForSecret(rfcTwoFactorSecret, tests[i].code, tests[i].at)
+		if step != tests[i].step {
+			t.Errorf("%s: step is %d, want %d", tests[i].name, step, tests[i].step)
+		}
+	}
+
+	if step := validateTwoFactorCodeForSecret("not base32!", "081804", now); step != 0 {
+		t.Errorf("an invalid secret gave step %d, want 0", step)
+	}
+}
+
+func TestGenerateTwoFactorSecret(t *testing.T) {
+	secret, err := generateTwoFactorSecret()
+	if err != nil {
+		t.Fatalf("generateTwoFactorSecret: %v", err)
+	}
+
+	// 160 bits, which RFC 4226 recommends
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+	if _, err := twoFactorCodeAt(secret, 1); err != nil {
+		t.Errorf("secret %q can't make codes: %v", secret, err)
+	}
+
+	other, _ := generateTwoFactorSecret()
+	if other == secret {
+		t.Errorf("generateTwoFactorSecret gave the same secret twice")
+	}
+}
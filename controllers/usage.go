@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/web/utilities"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+	"github.com/news-ai/api/usage"
+)
+
+var usageMetrics = []string{
+	models.UsageEmailsSent,
+	models.UsageEnrichmentCredits,
+	models.UsageMediaSearches,
+}
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+// The user of a usage request, when the current user can act on them
+func getUsageUser(c context.Context, r *http.Request, id string, action policy.Action) (models.User, error) {
+	user := models.User{}
+	err := errors.New("")
+
+	switch id {
+	case "me":
+		user, err = GetCurrentUser(c, r)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, err
+		}
+	default:
+		userId, err := utilities.StringIdToInt(id)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, err
+		}
+		user, err = getUser(c, r, userId)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, err
+		}
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, action, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// The plan of a user, and the agency billing when they have a seat on
+// the plan of their agency
+func getUserPlan(c context.Context, r *http.Request, user models.User) (models.Plan, models.Billing, error) {
+	_, agencyBilling, err := getAgencyBillingForUser(c, user)
+	if err == nil {
+		return billing.GetBillingPlan(c, agencyBilling), agencyBilling, nil
+	}
+
+	userBilling, err := GetUserBilling(c, r, user)
+	if err != nil {
+		return models.Plan{}, models.Billing{}, err
+	}
+
+	return billing.GetBillingPlan(c, userBilling), models.Billing{}, nil
+}
+
+func getUsageLimits(user models.User, plan models.Plan, agencyBilling models.Billing, metric string) (usage.Limits, error) {
+	limits := usage.Limits{
+		Owner:   usage.User(user),
+		Daily:   usage.Unlimited,
+		Monthly: usage.Unlimited,
+	}
+
+	switch metric {
+	case models.UsageEmailsSent:
+		limits.Daily = plan.DailyEmailsAllowed
+
+		// Daily emails are pooled between everyone with a seat on an
+		// agency plan
+		if agencyBilling.IsAgency {
+			limits.Owner = usage.Agency(agencyBilling)
+			limits.Daily = plan.DailyEmailsAllowed * agencyBilling.Seats
+		}
+	case models.UsageEnrichmentCredits:
+		limits.Monthly = user.EnhanceCredits
+	case models.UsageMediaSearches:
+		if !user.MediaDatabaseAccess {
+			limits.Daily = 0
+			limits.Monthly = 0
+		}
+	default:
+		return usage.Limits{}, errors.New("Unknown usage metric")
+	}
+
+	return limits, nil
+}
+
+func getUsageMetric(c context.Context, owner usage.Owner, metric string, limits usage.Limits) (models.UsageMetric, error) {
+	today, thisMonth, err := usage.Get(c, owner, metric)
+	if err != nil {
+		return models.UsageMetric{}, err
+	}
+
+	return models.UsageMetric{
+		Metric:       metric,
+		Today:        today,
+		DailyLimit:   limits.Daily,
+		ThisMonth:    thisMonth,
+		MonthlyLimit: limits.Monthly,
+		Pooled:       limits.Owner.Type == usage.OwnerAgency,
+	}, nil
+}
+
+// Social accounts that the clients matching a filter, like the clients
+// that a user created, track now
+func countSocialAccounts(c context.Context, filter string, value int64) (int, error) {
+	ks, err := datastore.NewQuery("Client").Filter(filter, value).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, err
+	}
+
+	clients := make([]models.Client, len(ks))
+	err = nds.GetMulti(c, ks, clients)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0, err
+	}
+
+	count := 0
+	for i := 0; i < len(clients); i++ {
+		count += socialAccountsOfClient(clients[i])
+	}
+	return count, nil
+}
+
+// Social accounts count towards the user who created the client, and are
+// given back when the client stops tracking them or is deleted
+func checkSocialAccountsLimit(c context.Context, r *http.Request, user models.User, newAccounts int) error {
+	if newAccounts < 1 {
+		return nil
+	}
+
+	plan, _, err := getUserPlan(c, r, user)
+	if err != nil {
+		return err
+	}
+	if plan.SocialAccounts == usage.Unlimited {
+		return nil
+	}
+
+	socialAccounts, err := countSocialAccounts(c, "CreatedBy =", user.Id)
+	if err != nil {
+		return err
+	}
+
+	if socialAccounts+newAccounts > plan.SocialAccounts {
+		return errors.New("You have reached the limit of social accounts on your plan")
+	}
+	return nil
+}
+
+// Users can add as many emails as the email accounts of their plan
+func checkEmailAccountsLimit(c context.Context, r *http.Request, user models.User) error {
+	plan, _, err := getUserPlan(c, r, user)
+	if err != nil {
+		return err
+	}
+
+	if len(user.Emails) >= plan.EmailAccounts {
+		return errors.New("You have reached the limit of email accounts on your plan")
+	}
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// Emails are sent by another service that reports them before sending,
+// so the emails sent today are read from the counter of the user
+func GetUserDailyEmail(c context.Context, r *http.Request, user models.User) int {
+	emailsSent, _, err := usage.Get(c, usage.User(user), models.UsageEmailsSent)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return 0
+	}
+	return emailsSent
+}
+
+func GetUserUsage(c context.Context, r *http.Request, id string) (models.UserUsage, interface{}, error) {
+	user, err := getUsageUser(c, r, id, policy.ActionBilling)
+	if err != nil {
+		return models.UserUsage{}, nil, err
+	}
+
+	plan, agencyBilling, err := getUserPlan(c, r, user)
+	if err != nil {
+		return models.UserUsage{}, nil, err
+	}
+
+	userUsage := models.UserUsage{}
+	userUsage.UserId = user.Id
+	userUsage.PlanName = plan.Name
+	userUsage.Metrics = []models.UsageMetric{}
+	userUsage.TeamMetrics = []models.UsageMetric{}
+
+	team, err := getTeam(c, user.TeamId)
+	if err == nil {
+		userUsage.TeamId = team.Id
+	}
+
+	for i := 0; i < len(usageMetrics); i++ {
+		limits, err := getUsageLimits(user, plan, agencyBilling, usageMetrics[i])
+		if err != nil {
+			return models.UserUsage{}, nil, err
+		}
+
+		// Pooled limits are shown with the usage of the whole agency
+		owner := usage.User(user)
+		if limits.Owner.Type == usage.OwnerAgency {
+			owner = limits.Owner
+		}
+
+		usageMetric, err := getUsageMetric(c, owner, usageMetrics[i], limits)
+		if err != nil {
+			return models.UserUsage{}, nil, err
+		}
+		userUsage.Metrics = append(userUsage.Metrics, usageMetric)
+
+		if userUsage.TeamId != 0 {
+			teamLimits := usage.Limits{Owner: usage.Team(team), Daily: usage.Unlimited, Monthly: usage.Unlimited}
+			teamMetric, err := getUsageMetric(c, teamLimits.Owner, usageMetrics[i], teamLimits)
+			if err != nil {
+				return models.UserUsage{}, nil, err
+			}
+			userUsage.TeamMetrics = append(userUsage.TeamMetrics, teamMetric)
+		}
+	}
+
+	socialAccounts, err := countSocialAccounts(c, "CreatedBy =", user.Id)
+	if err != nil {
+		return models.UserUsage{}, nil, err
+	}
+	userUsage.Metrics = append(userUsage.Metrics, models.UsageMetric{
+		Metric:       models.UsageSocialAccounts,
+		Today:        socialAccounts,
+		DailyLimit:   usage.Unlimited,
+		ThisMonth:    socialAccounts,
+		MonthlyLimit: plan.SocialAccounts,
+		Gauge:        true,
+	})
+
+	if userUsage.TeamId != 0 {
+		teamSocialAccounts, err := countSocialAccounts(c, "TeamId =", team.Id)
+		if err != nil {
+			return models.UserUsage{}, nil, err
+		}
+		userUsage.TeamMetrics = append(userUsage.TeamMetrics, models.UsageMetric{
+			Metric:       models.UsageSocialAccounts,
+			Today:        teamSocialAccounts,
+			DailyLimit:   usage.Unlimited,
+			ThisMonth:    teamSocialAccounts,
+			MonthlyLimit: usage.Unlimited,
+			Gauge:        true,
+		})
+	}
+
+	return userUsage, nil, nil
+}
+
+/*
+* Action methods
+ */
+
+// Checks an amount of a metric against the plan of a user and counts it
+// for the user, their team and their agency when it is within the
+// limits. Everything that is metered goes through here, including emails
+// sent by other services.
+func ConsumeUsage(c context.Context, r *http.Request, user models.User, metric string, amount int) error {
+	plan, agencyBilling, err := getUserPlan(c, r, user)
+	if err != nil {
+		return err
+	}
+
+	limits, err := getUsageLimits(user, plan, agencyBilling, metric)
+	if err != nil {
+		return err
+	}
+
+	owners := []usage.Owner{usage.User(user)}
+	team, err := getTeam(c, user.TeamId)
+	if err == nil {
+		owners = append(owners, usage.Team(team))
+	}
+
+	return usage.Consume(c, metric, amount, limits, owners...)
+}
+
+// Services that send emails, spend enrichment credits or search the media
+// database report what they used here before they use it. An error means
+// that the user is over the limits of their plan.
+func ReportUserUsage(c context.Context, r *http.Request, id string) (models.UserUsage, interface{}, error) {
+	user, err := getUsageUser(c, r, id, policy.ActionUpdate)
+	if err != nil {
+		return models.UserUsage{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var usageReport models.UsageReport
+	err = decoder.Decode(buf, &usageReport)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserUsage{}, nil, err
+	}
+
+	// Usage can't be given back through a report
+	if usageReport.Amount < 1 {
+		return models.UserUsage{}, nil, errors.New("Please provide an amount of usage")
+	}
+
+	err = ConsumeUsage(c, r, user, usageReport.Metric, usageReport.Amount)
+	if err != nil {
+		return models.UserUsage{}, nil, err
+	}
+
+	return GetUserUsage(c, r, id)
+}
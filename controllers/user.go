@@ -18,13 +18,15 @@ import (
 	"github.com/pquerna/ffjson/ffjson"
 	"github.com/qedus/nds"
 
+	"github.com/news-ai/api/audit"
 	"github.com/news-ai/api/billing"
 
 	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+	"github.com/news-ai/api/webhooks"
 	"github.com/news-ai/tabulae/emails"
 	"github.com/news-ai/tabulae/sync"
 
-	"github.com/news-ai/web/permissions"
 	"github.com/news-ai/web/utilities"
 )
 
@@ -55,8 +57,8 @@ func getUser(c context.Context, r *http.Request, id int64) (models.User, error)
 			return models.User{}, err
 		}
 
-		if user.TeamId != currentUser.TeamId && !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-			err = errors.New("Forbidden")
+		err = policy.Authorize(c, currentUser, policy.ActionRead, user)
+		if err != nil {
 			log.Errorf(c, "%v", err)
 			return models.User{}, err
 		}
@@ -66,6 +68,46 @@ func getUser(c context.Context, r *http.Request, id int64) (models.User, error)
 	return models.User{}, errors.New("No user by this id")
 }
 
+// Gets a user by id, or "me", that the current user is allowed to update
+func getUserForUpdate(c context.Context, r *http.Request, id string) (models.User, models.User, error) {
+	user := models.User{}
+	err := errors.New("")
+
+	switch id {
+	case "me":
+		user, err = GetCurrentUser(c, r)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, models.User{}, err
+		}
+	default:
+		userId, err := utilities.StringIdToInt(id)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, models.User{}, err
+		}
+		user, err = getUser(c, r, userId)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return models.User{}, models.User{}, err
+		}
+	}
+
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, models.User{}, err
+	}
+
+	return user, currentUser, nil
+}
+
 func getUserUnauthorized(c context.Context, r *http.Request, id int64) (models.User, error) {
 	// Get the current signed in user details by Id
 	var user models.User
@@ -92,8 +134,10 @@ func getUsers(c context.Context, r *http.Request) ([]models.User, error) {
 		return []models.User{}, err
 	}
 
-	if !user.IsAdmin {
-		return []models.User{}, errors.New("Forbidden")
+	err = policy.Authorize(c, user, policy.ActionRead, policy.Collection("User"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
 	}
 
 	query := datastore.NewQuery("User")
@@ -378,16 +422,6 @@ func GetCurrentUser(c context.Context, r *http.Request) (models.User, error) {
 	return user, nil
 }
 
-func GetUserFromApiKey(r *http.Request, ApiKey string) (models.User, error) {
-	c := appengine.NewContext(r)
-	user, err := GetUserByApiKey(c, ApiKey)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		return models.User{}, err
-	}
-	return user, nil
-}
-
 func GetUserByIdUnauthorized(c context.Context, r *http.Request, userId int64) (models.User, error) {
 	// Method dangerous since it can log into as any user. Be careful.
 	user, err := getUserUnauthorized(c, r, userId)
@@ -439,8 +473,8 @@ func AddPlanToUser(c context.Context, r *http.Request, id string) (models.User,
 		return models.User{}, nil, err
 	}
 
-	if !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionManage, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -461,13 +495,9 @@ func AddPlanToUser(c context.Context, r *http.Request, id string) (models.User,
 	}
 
 	originalPlan := ""
-	switch userNewPlan.Plan {
-	case "bronze":
-		originalPlan = "Personal"
-	case "silver-1":
-		originalPlan = "Freelancer"
-	case "gold-1":
-		originalPlan = "Business"
+	newPlan, err := billing.FindPlan(c, userNewPlan.Plan)
+	if err == nil {
+		originalPlan = newPlan.Name
 	}
 
 	if userNewPlan.Duration != "monthly" && userNewPlan.Duration != "annually" {
@@ -521,8 +551,8 @@ func AddEmailToUser(c context.Context, r *http.Request, id string) (models.User,
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -557,6 +587,11 @@ func AddEmailToUser(c context.Context, r *http.Request, id string) (models.User,
 		}
 	}
 
+	err = checkEmailAccountsLimit(c, r, user)
+	if err != nil {
+		return user, nil, err
+	}
+
 	// Generate User Emails Code to send to confirmation email
 	userEmailCode := models.UserEmailCode{}
 	userEmailCode.InviteCode = utilities.RandToken()
@@ -602,8 +637,8 @@ func RemoveEmailFromUser(c context.Context, r *http.Request, id string) (models.
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -637,20 +672,6 @@ func RemoveEmailFromUser(c context.Context, r *http.Request, id string) (models.
 	return user, nil, nil
 }
 
-func GetUserDailyEmail(c context.Context, r *http.Request, user models.User) int {
-	t := time.Now()
-	todayDateMorning := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
-	todayDateNight := time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 59, time.Local)
-
-	emailsSent, err := datastore.NewQuery("Email").Filter("CreatedBy =", user.Id).Filter("IsSent =", true).Filter("Cancel =", false).Filter("Created <=", todayDateNight).Filter("Created >=", todayDateMorning).KeysOnly().GetAll(c, nil)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		return 0
-	}
-
-	return len(emailsSent)
-}
-
 func GetUserPlanDetails(c context.Context, r *http.Request, id string) (models.UserPlan, interface{}, error) {
 	user := models.User{}
 	err := errors.New("")
@@ -681,24 +702,41 @@ func GetUserPlanDetails(c context.Context, r *http.Request, id string) (models.U
 		return models.UserPlan{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionBilling, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.UserPlan{}, nil, err
 	}
 
+	// Daily emails are pooled between everyone with a seat on an agency plan
+	_, agencyBilling, err := getAgencyBillingForUser(c, user)
+	if err == nil {
+		plan := billing.GetBillingPlan(c, agencyBilling)
+		userPlan := models.UserPlan{}
+		userPlan.PlanName = plan.Name
+		userPlan.EmailAccounts = plan.EmailAccounts
+		userPlan.AgencyPlan = true
+		userPlan.DailyEmailsAllowed = plan.DailyEmailsAllowed * agencyBilling.Seats
+		userPlan.EmailsSentToday = getAgencyDailyEmail(c, r, agencyBilling)
+		userPlan.ShowPaymentBanner = agencyBilling.IsPastDue
+		userPlan.GracePeriodEnds = agencyBilling.GracePeriodEnds
+		return userPlan, nil, nil
+	}
+
 	userBilling, err := GetUserBilling(c, r, user)
 	if err != nil {
 		return models.UserPlan{}, nil, err
 	}
 
 	userPlan := models.UserPlan{}
-	userPlanName := billing.BillingIdToPlanName(userBilling.StripePlanId)
-	userPlan.PlanName = userPlanName
-	userPlan.EmailAccounts = billing.UserMaximumEmailAccounts(userPlanName)
+	plan := billing.GetBillingPlan(c, userBilling)
+	userPlan.PlanName = plan.Name
+	userPlan.EmailAccounts = plan.EmailAccounts
 	userPlan.OnTrial = userBilling.IsOnTrial
-	userPlan.DailyEmailsAllowed = billing.StripePlanIdToMaximumEmailSent(userBilling.StripePlanId)
+	userPlan.DailyEmailsAllowed = plan.DailyEmailsAllowed
 	userPlan.EmailsSentToday = GetUserDailyEmail(c, r, user)
+	userPlan.ShowPaymentBanner = userBilling.IsPastDue
+	userPlan.GracePeriodEnds = userBilling.GracePeriodEnds
 
 	return userPlan, nil, nil
 }
@@ -733,8 +771,8 @@ func ConfirmAddEmailToUser(c context.Context, r *http.Request, id string) (model
 		return user, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return user, nil, err
 	}
@@ -757,8 +795,8 @@ func ConfirmAddEmailToUser(c context.Context, r *http.Request, id string) (model
 		}
 
 		if len(userEmailCodes) > 0 {
-			if !permissions.AccessToObject(user.Id, userEmailCodes[0].CreatedBy) {
-				err = errors.New("Forbidden")
+			err = policy.Authorize(c, user, policy.ActionUpdate, userEmailCodes[0])
+			if err != nil {
 				log.Errorf(c, "%v", err)
 				return user, nil, err
 			}
@@ -812,8 +850,8 @@ func FeedbackFromUser(c context.Context, r *http.Request, id string) (models.Use
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -856,34 +894,46 @@ func Update(c context.Context, r *http.Request, u *models.User) (*models.User, e
 		CreateAgencyFromUser(c, r, u)
 	}
 
-	billing, err := GetUserBilling(c, r, *u)
+	// Users with a seat on the plan of their agency are billed through it
+	_, _, err := getAgencyBillingForUser(c, *u)
+	if err == nil {
+		return u, nil
+	}
+
+	userBilling, err := GetUserBilling(c, r, *u)
 	if err != nil {
 		return u, err
 	}
 
-	if billing.Expires.Before(time.Now()) {
-		if billing.IsOnTrial {
+	// Accounts with a failed payment are locked when the grace period ends
+	if billing.LockPastDueAccount(r, u, &userBilling) {
+		userBilling.Save(c)
+		u.Save(c)
+	}
+
+	if userBilling.Expires.Before(time.Now()) {
+		if userBilling.IsOnTrial {
 			u.IsActive = false
 			u.Save(c)
 
-			billing.IsOnTrial = false
-			billing.Save(c)
+			userBilling.IsOnTrial = false
+			userBilling.Save(c)
 		} else {
-			if billing.IsCancel {
+			if userBilling.IsCancel {
 				u.IsActive = false
 				u.Save(c)
 			} else {
-				if billing.StripePlanId != "free" {
-					// If they haven't canceled then we can add a month until they do.
-					// More sophisticated to add the amount depending on what
-					// plan they were on.
-					addAMonth := billing.Expires.AddDate(0, 1, 0)
-					billing.Expires = addAMonth
-					billing.Save(c)
-
-					// Keep the user active
-					u.IsActive = true
-					u.Save(c)
+				if userBilling.StripePlanId != "free" {
+					// Failed payments start dunning when Stripe tells us. If we
+					// still haven't heard that the renewal was paid a few days
+					// later, the user keeps their account until the grace
+					// period ends. Reminders are sent by the processDunning
+					// task.
+					renewalOverdue := userBilling.Expires.Add(billing.RenewalGracePeriod).Before(time.Now())
+					if !userBilling.IsPastDue && renewalOverdue {
+						billing.StartDunning(&userBilling, 0)
+						userBilling.Save(c)
+					}
 				}
 			}
 		}
@@ -922,8 +972,8 @@ func UpdateUser(c context.Context, r *http.Request, id string) (models.User, int
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -1015,15 +1065,18 @@ func BanUser(c context.Context, r *http.Request, id string) (models.User, interf
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionManage, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
 
+	before := map[string]bool{"isactive": user.IsActive, "isbanned": user.IsBanned}
 	user.IsActive = false
 	user.IsBanned = true
 	SaveUser(c, r, &user)
+	audit.Record(r, models.AuditActionUserBan, user, before, map[string]bool{"isactive": user.IsActive, "isbanned": user.IsBanned})
+	webhooks.Trigger(r, models.WebhookEventUserBanned, user, nil)
 	return user, nil, nil
 }
 
@@ -1057,8 +1110,8 @@ func GetAndRefreshLiveToken(c context.Context, r *http.Request, id string) (inte
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -1123,8 +1176,8 @@ func UpdateUserEmail(c context.Context, r *http.Request, id string) (models.User
 		return models.User{}, nil, err
 	}
 
-	if !permissions.AccessToObject(user.Id, currentUser.Id) && !currentUser.IsAdmin {
-		err = errors.New("Forbidden")
+	err = policy.Authorize(c, currentUser, policy.ActionUpdate, user)
+	if err != nil {
 		log.Errorf(c, "%v", err)
 		return models.User{}, nil, err
 	}
@@ -1139,11 +1192,15 @@ func UpdateUserEmail(c context.Context, r *http.Request, id string) (models.User
 	}
 
 	// If new user wants to get daily emails
+	previousEmail := user.Email
 	if updatedUser.Email != "" {
 		user.Email = updatedUser.Email
 	}
 
 	user.Save(c)
 	sync.ResourceSync(r, user.Id, "User", "create")
+	if user.Email != previousEmail {
+		audit.Record(r, models.AuditActionEmailChange, user, map[string]string{"email": previousEmail}, map[string]string{"email": user.Email})
+	}
 	return user, nil, nil
 }
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	gcontext "github.com/gorilla/context"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/web/utilities"
+)
+
+// Sessions only write when they were last seen every few minutes
+var userSessionSeenInterval = 5 * time.Minute
+
+var ErrSessionRevoked = errors.New("This session has been revoked")
+
+/*
+* Private methods
+ */
+
+// A short description of the device a session is on
+func deviceFromUserAgent(userAgent string) string {
+	devices := []struct {
+		Match  string
+		Device string
+	}{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Macintosh", "Mac"},
+		{"Windows", "Windows"},
+		{"CrOS", "Chromebook"},
+		{"Linux", "Linux"},
+	}
+
+	browsers := []struct {
+		Match   string
+		Browser string
+	}{
+		{"Edge/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Chrome/", "Chrome"},
+		{"Firefox/", "Firefox"},
+		{"Safari/", "Safari"},
+	}
+
+	device := "Unknown device"
+	for i := 0; i < len(devices); i++ {
+		if strings.Contains(userAgent, devices[i].Match) {
+			device = devices[i].Device
+			break
+		}
+	}
+
+	for i := 0; i < len(browsers); i++ {
+		if strings.Contains(userAgent, browsers[i].Match) {
+			return browsers[i].Browser + " on " + device
+		}
+	}
+
+	return device
+}
+
+/*
+* Get methods
+ */
+
+func getUserSession(c context.Context, id int64) (models.UserSession, error) {
+	if id == 0 {
+		return models.UserSession{}, errors.New("datastore: no such entity")
+	}
+
+	var userSession models.UserSession
+	userSessionId := datastore.NewKey(c, "UserSession", "", id, nil)
+
+	err := nds.Get(c, userSessionId, &userSession)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSession{}, err
+	}
+
+	if !userSession.Created.IsZero() {
+		userSession.Format(userSessionId, "sessions")
+		return userSession, nil
+	}
+	return models.UserSession{}, errors.New("No session by this id")
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetSessionsForUser(c context.Context, r *http.Request, id string) ([]models.UserSession, interface{}, int, int, error) {
+	user, _, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return []models.UserSession{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("UserSession").Filter("UserId =", user.Id).Filter("Revoked =", false)
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.UserSession{}, nil, 0, 0, err
+	}
+
+	var userSessions []models.UserSession
+	userSessions = make([]models.UserSession, len(ks))
+	err = nds.GetMulti(c, ks, userSessions)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.UserSession{}, nil, 0, 0, err
+	}
+
+	currentSessionId, _ := gcontext.Get(r, "sessionid").(int64)
+	for i := 0; i < len(userSessions); i++ {
+		userSessions[i].Format(ks[i], "sessions")
+		userSessions[i].Current = userSessions[i].Id == currentSessionId
+	}
+
+	return userSessions, nil, len(userSessions), 0, nil
+}
+
+/*
+* Create methods
+ */
+
+func CreateUserSession(c context.Context, r *http.Request, user models.User) (models.UserSession, error) {
+	userSession := models.UserSession{
+		UserId:    user.Id,
+		Device:    deviceFromUserAgent(r.UserAgent()),
+		UserAgent: r.UserAgent(),
+		IP:        loginAttemptIP(r),
+	}
+
+	_, err := userSession.Create(c, r, user)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSession{}, err
+	}
+
+	return userSession, nil
+}
+
+/*
+* Update methods
+ */
+
+// Checks that a session has not been revoked and updates when it was last
+// seen. Returns an error if the session can't be used anymore.
+func TouchUserSession(c context.Context, r *http.Request, id int64, user models.User) (models.UserSession, error) {
+	userSession, err := getUserSession(c, id)
+	if err != nil {
+		return models.UserSession{}, err
+	}
+
+	if userSession.Revoked || userSession.UserId != user.Id {
+		return models.UserSession{}, ErrSessionRevoked
+	}
+
+	ip := loginAttemptIP(r)
+	if time.Since(userSession.LastSeen) > userSessionSeenInterval || userSession.IP != ip {
+		userSession.LastSeen = time.Now()
+		userSession.IP = ip
+		userSession.Save(c)
+	}
+
+	gcontext.Set(r, "sessionid", userSession.Id)
+	return userSession, nil
+}
+
+/*
+* Delete methods
+ */
+
+func RevokeSessionForUser(c context.Context, r *http.Request, id string, sessionId string) (models.UserSession, interface{}, error) {
+	user, _, err := getUserForUpdate(c, r, id)
+	if err != nil {
+		return models.UserSession{}, nil, err
+	}
+
+	currentSessionId, err := utilities.StringIdToInt(sessionId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSession{}, nil, err
+	}
+
+	userSession, err := getUserSession(c, currentSessionId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSession{}, nil, err
+	}
+
+	if userSession.UserId != user.Id {
+		return models.UserSession{}, nil, errors.New("No session by this id")
+	}
+
+	_, err = userSession.Revoke(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSession{}, nil, err
+	}
+
+	return userSession, nil, nil
+}
+
+// Revokes every session and refresh token of a user. Used when their
+// password changes.
+func RevokeSessionsForUser(c context.Context, userId int64) error {
+	ks, err := datastore.NewQuery("UserSession").Filter("UserId =", userId).Filter("Revoked =", false).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	var userSessions []models.UserSession
+	userSessions = make([]models.UserSession, len(ks))
+	err = nds.GetMulti(c, ks, userSessions)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	for i := 0; i < len(userSessions); i++ {
+		userSessions[i].Revoked = true
+		userSessions[i].RevokedAt = time.Now()
+		userSessions[i].Updated = time.Now()
+	}
+
+	_, err = nds.PutMulti(c, ks, userSessions)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	return RevokeRefreshTokensForUser(c, userId)
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getUserSweepRun(c context.Context, id int64) (models.UserSweepRun, error) {
+	userSweepRunId := datastore.NewKey(c, "UserSweepRun", "", id, nil)
+
+	var userSweepRun models.UserSweepRun
+	err := nds.Get(c, userSweepRunId, &userSweepRun)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSweepRun{}, err
+	}
+
+	userSweepRun.Format(userSweepRunId, "usersweepruns")
+	return userSweepRun, nil
+}
+
+func getUsersPageQuery(cursor string, limit int) (*datastore.Query, error) {
+	query := datastore.NewQuery("User").Limit(limit)
+	if cursor != "" {
+		datastoreCursor, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Start(datastoreCursor)
+	}
+	return query, nil
+}
+
+// Updates a run in a transaction since its batches finish at the same time
+func updateUserSweepRun(c context.Context, id int64, update func(*models.UserSweepRun)) (models.UserSweepRun, error) {
+	var userSweepRun models.UserSweepRun
+	err := nds.RunInTransaction(c, func(tc context.Context) error {
+		var err error
+		userSweepRun, err = getUserSweepRun(tc, id)
+		if err != nil {
+			return err
+		}
+
+		update(&userSweepRun)
+		if userSweepRun.IsFinished() && userSweepRun.Finished.IsZero() {
+			userSweepRun.Finished = time.Now()
+		}
+
+		_, err = userSweepRun.Save(tc)
+		return err
+	}, &datastore.TransactionOptions{Attempts: 10})
+
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UserSweepRun{}, err
+	}
+	return userSweepRun, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+// Gets a page of users starting at a cursor. Empty cursors start at the
+// first user.
+func GetUsersPageUnauthorized(c context.Context, cursor string, limit int) ([]models.User, error) {
+	query, err := getUsersPageQuery(cursor, limit)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	users := make([]models.User, len(ks))
+	err = nds.GetMulti(c, ks, users)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.User{}, err
+	}
+
+	for i := 0; i < len(users); i++ {
+		users[i].Format(ks[i], "users")
+	}
+	return users, nil
+}
+
+// Gets the cursor after a page of users without loading them. Returns if
+// there could be more users after the page.
+func GetNextUsersCursor(c context.Context, cursor string, limit int) (string, bool, error) {
+	query, err := getUsersPageQuery(cursor, limit)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return "", false, err
+	}
+
+	count := 0
+	t := query.KeysOnly().Run(c)
+	for {
+		_, err := t.Next(nil)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return "", false, err
+		}
+		count++
+	}
+
+	nextCursor, err := t.Cursor()
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return "", false, err
+	}
+
+	return nextCursor.String(), count == limit, nil
+}
+
+func GetUserSweepRuns(c context.Context) ([]models.UserSweepRun, error) {
+	ks, err := datastore.NewQuery("UserSweepRun").Order("-Created").Limit(50).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.UserSweepRun{}, err
+	}
+
+	userSweepRuns := make([]models.UserSweepRun, len(ks))
+	err = nds.GetMulti(c, ks, userSweepRuns)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.UserSweepRun{}, err
+	}
+
+	for i := 0; i < len(userSweepRuns); i++ {
+		userSweepRuns[i].Format(ks[i], "usersweepruns")
+	}
+	return userSweepRuns, nil
+}
+
+/*
+* Update methods
+ */
+
+// Counts the batch of a page that has been queued for a run. The last
+// page of users also marks every batch as queued. Pages are only counted
+// once.
+func AddBatchToUserSweepRun(c context.Context, id int64, page int, lastBatch bool) (models.UserSweepRun, error) {
+	return updateUserSweepRun(c, id, func(userSweepRun *models.UserSweepRun) {
+		if !userSweepRun.IsPageQueued(page) {
+			userSweepRun.QueuedPages = append(userSweepRun.QueuedPages, page)
+			userSweepRun.Batches++
+		}
+		if lastBatch {
+			userSweepRun.BatchesQueued = true
+		}
+	})
+}
+
+// Counts the users of the batch of a page. Batches that are retried after
+// they finished are only counted once.
+func FinishUserSweepBatch(c context.Context, id int64, page int, processed int, updated int, failed int) (models.UserSweepRun, error) {
+	return updateUserSweepRun(c, id, func(userSweepRun *models.UserSweepRun) {
+		if userSweepRun.IsPageDone(page) {
+			return
+		}
+		userSweepRun.DonePages = append(userSweepRun.DonePages, page)
+		userSweepRun.BatchesDone++
+		userSweepRun.UsersProcessed += processed
+		userSweepRun.UsersUpdated += updated
+		userSweepRun.UsersFailed += failed
+	})
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/policy"
+	"github.com/news-ai/api/webhooks"
+
+	"github.com/news-ai/web/utilities"
+)
+
+/*
+* Private methods
+ */
+
+func validWebhookEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(events); i++ {
+		if !stringInSlice(events[i], models.WebhookEvents) {
+			return false
+		}
+	}
+	return true
+}
+
+// Webhooks are only managed by platform admins
+func authorizeWebhooks(c context.Context, r *http.Request) (models.User, error) {
+	currentUser, err := GetCurrentUser(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	err = policy.Authorize(c, currentUser, policy.ActionManage, policy.Collection("WebhookSubscription"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.User{}, err
+	}
+
+	return currentUser, nil
+}
+
+/*
+* Get methods
+ */
+
+func getWebhookSubscription(c context.Context, r *http.Request, id string) (models.WebhookSubscription, error) {
+	_, err := authorizeWebhooks(c, r)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	currentId, err := utilities.StringIdToInt(id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, err
+	}
+
+	subscriptionId := datastore.NewKey(c, "WebhookSubscription", "", currentId, nil)
+
+	var subscription models.WebhookSubscription
+	err = nds.Get(c, subscriptionId, &subscription)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, errors.New("No webhook by this id")
+	}
+
+	subscription.Format(subscriptionId, "webhooks")
+	return subscription, nil
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Get methods
+ */
+
+func GetWebhookSubscriptions(c context.Context, r *http.Request) ([]models.WebhookSubscription, interface{}, int, int, error) {
+	_, err := authorizeWebhooks(c, r)
+	if err != nil {
+		return []models.WebhookSubscription{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("WebhookSubscription")
+	query = ConstructQuery(query, r)
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.WebhookSubscription{}, nil, 0, 0, err
+	}
+
+	subscriptions := make([]models.WebhookSubscription, len(ks))
+	err = nds.GetMulti(c, ks, subscriptions)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.WebhookSubscription{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(subscriptions); i++ {
+		subscriptions[i].Format(ks[i], "webhooks")
+	}
+
+	return subscriptions, nil, len(subscriptions), 0, nil
+}
+
+func GetWebhookSubscription(c context.Context, r *http.Request, id string) (models.WebhookSubscription, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+	return subscription, nil, nil
+}
+
+// The delivery log of a subscription, newest first
+func GetWebhookDeliveries(c context.Context, r *http.Request, id string) ([]models.WebhookDelivery, interface{}, int, int, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return []models.WebhookDelivery{}, nil, 0, 0, err
+	}
+
+	query := datastore.NewQuery("WebhookDelivery").Filter("SubscriptionId =", subscription.Id)
+	if r.URL.Query().Get("status") != "" {
+		query = query.Filter("Status =", r.URL.Query().Get("status"))
+	}
+	query = ConstructQuery(query.Order("-Created"), r)
+
+	ks, err := query.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.WebhookDelivery{}, nil, 0, 0, err
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(ks))
+	err = nds.GetMulti(c, ks, deliveries)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return []models.WebhookDelivery{}, nil, 0, 0, err
+	}
+
+	for i := 0; i < len(deliveries); i++ {
+		deliveries[i].Format(ks[i], "webhookdeliveries")
+	}
+
+	return deliveries, nil, len(deliveries), 0, nil
+}
+
+/*
+* Create methods
+ */
+
+func CreateWebhookSubscription(c context.Context, r *http.Request) (models.WebhookSubscription, interface{}, error) {
+	currentUser, err := authorizeWebhooks(c, r)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var subscription models.WebhookSubscription
+	err = decoder.Decode(buf, &subscription)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	if !validHTTPSURL(subscription.URL) {
+		return models.WebhookSubscription{}, nil, errors.New("Webhooks have to use an https url")
+	}
+
+	if !validWebhookEvents(subscription.Events) {
+		return models.WebhookSubscription{}, nil, errors.New("Invalid webhook event")
+	}
+
+	secret, err := generateSecret("whsec_")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	subscription.Secret = secret
+	subscription.Active = true
+
+	_, err = subscription.Create(c, r, currentUser)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	// This is the only time the secret is shown
+	subscription.SigningSecret = secret
+	return subscription, nil, nil
+}
+
+/*
+* Update methods
+ */
+
+func UpdateWebhookSubscription(c context.Context, r *http.Request, id string) (models.WebhookSubscription, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	buf, _ := ioutil.ReadAll(r.Body)
+	decoder := ffjson.NewDecoder()
+	var updatedSubscription models.WebhookSubscription
+	err = decoder.Decode(buf, &updatedSubscription)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	if updatedSubscription.URL != "" {
+		if !validHTTPSURL(updatedSubscription.URL) {
+			return subscription, nil, errors.New("Webhooks have to use an https url")
+		}
+		subscription.URL = updatedSubscription.URL
+	}
+
+	if len(updatedSubscription.Events) > 0 {
+		if !validWebhookEvents(updatedSubscription.Events) {
+			return subscription, nil, errors.New("Invalid webhook event")
+		}
+		subscription.Events = updatedSubscription.Events
+	}
+
+	utilities.UpdateIfNotBlank(&subscription.Description, updatedSubscription.Description)
+
+	_, err = subscription.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	return subscription, nil, nil
+}
+
+/*
+* Action methods
+ */
+
+func ActivateWebhookSubscription(c context.Context, r *http.Request, id string, active bool) (models.WebhookSubscription, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	subscription.Active = active
+	_, err = subscription.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	return subscription, nil, nil
+}
+
+// Deliveries that are already queued are signed with the new secret
+func RotateWebhookSecret(c context.Context, r *http.Request, id string) (models.WebhookSubscription, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	secret, err := generateSecret("whsec_")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	subscription.Secret = secret
+	_, err = subscription.Save(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	subscription.SigningSecret = secret
+	return subscription, nil, nil
+}
+
+func RedeliverWebhook(c context.Context, r *http.Request, id string, deliveryId string) (models.WebhookDelivery, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookDelivery{}, nil, err
+	}
+
+	currentDeliveryId, err := utilities.StringIdToInt(deliveryId)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookDelivery{}, nil, err
+	}
+
+	deliveryKey := datastore.NewKey(c, "WebhookDelivery", "", currentDeliveryId, nil)
+
+	var delivery models.WebhookDelivery
+	err = nds.Get(c, deliveryKey, &delivery)
+	if err != nil || delivery.SubscriptionId != subscription.Id {
+		return models.WebhookDelivery{}, nil, errors.New("No webhook delivery by this id")
+	}
+	delivery.Format(deliveryKey, "webhookdeliveries")
+
+	err = webhooks.Redeliver(c, delivery)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookDelivery{}, nil, err
+	}
+
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	return delivery, nil, nil
+}
+
+/*
+* Delete methods
+ */
+
+func DeleteWebhookSubscription(c context.Context, r *http.Request, id string) (models.WebhookSubscription, interface{}, error) {
+	subscription, err := getWebhookSubscription(c, r, id)
+	if err != nil {
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	_, err = subscription.Delete(c)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.WebhookSubscription{}, nil, err
+	}
+
+	return subscription, nil, nil
+}
@@ -0,0 +1,273 @@
+// Package encryption encrypts secrets that we store, like OAuth tokens
+// and SMTP passwords, with envelope encryption.
+//
+// Every secret is encrypted with its own random data key. The data key is
+// encrypted with a key encryption key from the ENCRYPTIONKEYS environment
+// variable, and stored next to the secret.
+//
+// ENCRYPTIONKEYS is a comma separated list of <key id>:<base64 key> with
+// 32 byte keys. The first key encrypts new secrets and every key can
+// decrypt them. To rotate keys add a new key to the front of the list,
+// run /tasks/reencryptSecrets and then remove the old key.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Prefix of every encrypted secret
+const encryptedPrefix = "enc:v1:"
+
+var (
+	ErrNoEncryptionKey  = errors.New("No encryption key has been configured")
+	ErrUnknownKey       = errors.New("The secret was encrypted with an unknown key")
+	ErrInvalidEncrypted = errors.New("Invalid encrypted secret")
+)
+
+type encryptionKey struct {
+	Id  string
+	Key []byte
+}
+
+var (
+	keysOnce sync.Once
+	keys     []encryptionKey
+	keysErr  error
+)
+
+/*
+* Private methods
+ */
+
+func parseKeys(value string) ([]encryptionKey, error) {
+	parsedKeys := []encryptionKey{}
+	entries := strings.Split(value, ",")
+	for i := 0; i < len(entries); i++ {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("Encryption keys have to be <key id>:<base64 key>")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("The encryption key " + parts[0] + " has to be 32 bytes")
+		}
+
+		parsedKeys = append(parsedKeys, encryptionKey{
+			Id:  parts[0],
+			Key: key,
+		})
+	}
+
+	if len(parsedKeys) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+	return parsedKeys, nil
+}
+
+func getKeys() ([]encryptionKey, error) {
+	keysOnce.Do(func() {
+		keys, keysErr = parseKeys(os.Getenv("ENCRYPTIONKEYS"))
+	})
+	return keys, keysErr
+}
+
+func getKey(id string) ([]byte, error) {
+	allKeys, err := getKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(allKeys); i++ {
+		if allKeys[i].Id == id {
+			return allKeys[i].Key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// Encrypts with AES-GCM. The nonce is in front of the ciphertext.
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidEncrypted
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+/*
+* Public methods
+ */
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Secrets that are not encrypted, or are encrypted with a key that is not
+// the current key, have to be encrypted again
+func NeedsEncryption(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	if !IsEncrypted(value) {
+		return true
+	}
+
+	allKeys, err := getKeys()
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return parts[0] != allKeys[0].Id
+}
+
+// Encrypts a secret with the current key
+func Encrypt(plaintext []byte) (string, error) {
+	allKeys, err := getKeys()
+	if err != nil {
+		return "", err
+	}
+	currentKey := allKeys[0]
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedDataKey, err := seal(currentKey.Key, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + currentKey.Id + ":" + base64.RawURLEncoding.EncodeToString(encryptedDataKey) + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func Decrypt(value string) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, ErrInvalidEncrypted
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrInvalidEncrypted
+	}
+
+	key, err := getKey(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedDataKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidEncrypted
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidEncrypted
+	}
+
+	dataKey, err := open(key, encryptedDataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataKey, ciphertext)
+}
+
+// Encrypts a string secret. Blank secrets and secrets that are already
+// encrypted are left alone.
+func EncryptString(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	return Encrypt([]byte(value))
+}
+
+// Decrypts a string secret. Secrets from before encryption was added are
+// returned as they are.
+func DecryptString(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	plaintext, err := Decrypt(value)
+	if err != nil {
+		return value, err
+	}
+	return string(plaintext), nil
+}
+
+func EncryptBytes(value []byte) ([]byte, error) {
+	if len(value) == 0 || IsEncrypted(string(value)) {
+		return value, nil
+	}
+
+	encrypted, err := Encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encrypted), nil
+}
+
+func DecryptBytes(value []byte) ([]byte, error) {
+	if !IsEncrypted(string(value)) {
+		return value, nil
+	}
+
+	plaintext, err := Decrypt(string(value))
+	if err != nil {
+		return value, err
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,212 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// Replaces the keys that would come from ENCRYPTIONKEYS
+func useKeys(t *testing.T, value string) {
+	parsedKeys, err := parseKeys(value)
+	if err != nil {
+		t.Fatalf("parseKeys(%q): %v", value, err)
+	}
+
+	keysOnce = sync.Once{}
+	keysOnce.Do(func() {})
+	keys, keysErr = parsedKeys, nil
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		value string
+		ids   []string
+		fails bool
+	}{
+		{value: "a:" + testKey(1), ids: []string{"a"}},
+		{value: " b:" + testKey(2) + " , a:" + testKey(1) + ",", ids: []string{"b", "a"}},
+		{value: "", fails: true},
+		{value: testKey(1), fails: true},
+		{value: ":" + testKey(1), fails: true},
+		{value: "a:" + base64.StdEncoding.EncodeToString([]byte("short")), fails: true},
+		{value: "a:not base64", fails: true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		parsedKeys, err := parseKeys(tests[i].value)
+		if tests[i].fails {
+			if err == nil {
+				t.Errorf("parseKeys(%q) should fail", tests[i].value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseKeys(%q): %v", tests[i].value, err)
+			continue
+		}
+
+		if len(parsedKeys) != len(tests[i].ids) {
+			t.Errorf("parseKeys(%q) has %d keys, want %d", tests[i].value, len(parsedKeys), len(tests[i].ids))
+			continue
+		}
+		for j := 0; j < len(parsedKeys); j++ {
+			if parsedKeys[j].Id != tests[i].ids[j] {
+				t.Errorf("parseKeys(%q) key %d is %q, want %q", tests[i].value, j, parsedKeys[j].Id, tests[i].ids[j])
+			}
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	useKeys(t, "a:"+testKey(1))
+
+	plaintext := []byte("ya29.refresh-token")
+	encrypted, err := Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	if !IsEncrypted(encrypted) || !strings.HasPrefix(encrypted, encryptedPrefix+"a:") {
+		t.Fatalf("Encrypt gave %q, want it encrypted with key a", encrypted)
+	}
+	if strings.Contains(encrypted, string(plaintext)) {
+		t.Fatalf("Encrypt gave %q, which has the plaintext in it", encrypted)
+	}
+	if NeedsEncryption(encrypted) {
+		t.Errorf("NeedsEncryption(%q) should be false with the current key", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt gave %q, want %q", decrypted, plaintext)
+	}
+
+	// Every secret has its own data key and nonce
+	again, err := Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if again == encrypted {
+		t.Errorf("Encrypt gave the same value twice")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	useKeys(t, "a:"+testKey(1))
+
+	encrypted, err := Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(encrypted, encryptedPrefix), ":")
+	ciphertext, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	ciphertext[len(ciphertext)-1] ^= 1
+	tampered := encryptedPrefix + parts[0] + ":" + parts[1] + ":" + base64.RawURLEncoding.EncodeToString(ciphertext)
+
+	values := []string{
+		tampered,
+		"plaintext",
+		encryptedPrefix + "a:missing",
+		encryptedPrefix + "a:!!:!!",
+		encryptedPrefix + "a::",
+	}
+	for i := 0; i < len(values); i++ {
+		if _, err := Decrypt(values[i]); err == nil {
+			t.Errorf("Decrypt(%q) should fail", values[i])
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	useKeys(t, "a:"+testKey(1))
+
+	oldEncrypted, err := Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// A new key is added to the front of the list
+	useKeys(t, "b:"+testKey(2)+",a:"+testKey(1))
+
+	if !NeedsEncryption(oldEncrypted) {
+		t.Errorf("NeedsEncryption should be true for a secret encrypted with an old key")
+	}
+
+	decrypted, err := DecryptString(oldEncrypted)
+	if err != nil || decrypted != "secret" {
+		t.Fatalf("DecryptString with an old key gave %q, %v", decrypted, err)
+	}
+
+	newEncrypted, err := Encrypt([]byte(decrypted))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(newEncrypted, encryptedPrefix+"b:") {
+		t.Errorf("Encrypt gave %q, want it encrypted with key b", newEncrypted)
+	}
+	if NeedsEncryption(newEncrypted) {
+		t.Errorf("NeedsEncryption should be false for a secret encrypted with the current key")
+	}
+
+	// The old key is removed once every secret has been encrypted again
+	useKeys(t, "b:"+testKey(2))
+
+	if _, err := Decrypt(oldEncrypted); err != ErrUnknownKey {
+		t.Errorf("Decrypt with a removed key gave %v, want ErrUnknownKey", err)
+	}
+
+	decrypted, err = DecryptString(newEncrypted)
+	if err != nil || decrypted != "secret" {
+		t.Errorf("DecryptString gave %q, %v", decrypted, err)
+	}
+}
+
+func TestStringsAndBytes(t *testing.T) {
+	useKeys(t, "a:"+testKey(1))
+
+	if value, err := EncryptString(""); err != nil || value != "" {
+		t.Errorf("EncryptString(\"\") gave %q, %v", value, err)
+	}
+	if NeedsEncryption("") {
+		t.Errorf("NeedsEncryption(\"\") should be false")
+	}
+
+	// Secrets from before encryption was added
+	if !NeedsEncryption("legacy") {
+		t.Errorf("NeedsEncryption(\"legacy\") should be true")
+	}
+	if value, err := DecryptString("legacy"); err != nil || value != "legacy" {
+		t.Errorf("DecryptString(\"legacy\") gave %q, %v", value, err)
+	}
+	if value, err := DecryptBytes([]byte("legacy")); err != nil || string(value) != "legacy" {
+		t.Errorf("DecryptBytes(\"legacy\") gave %q, %v", value, err)
+	}
+
+	encrypted, err := EncryptString("secret")
+	if err != nil {
+		t.Fatalf("EncryptString: %v", err)
+	}
+	if again, err := EncryptString(encrypted); err != nil || again != encrypted {
+		t.Errorf("EncryptString should leave encrypted secrets alone, gave %q, %v", again, err)
+	}
+
+	encryptedBytes, err := EncryptBytes([]byte("secret"))
+	if err != nil {
+		t.Fatalf("EncryptBytes: %v", err)
+	}
+	decryptedBytes, err := DecryptBytes(encryptedBytes)
+	if err != nil || string(decryptedBytes) != "secret" {
+		t.Errorf("DecryptBytes gave %q, %v", decryptedBytes, err)
+	}
+}
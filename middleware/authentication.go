@@ -14,6 +14,20 @@ import (
 )
 
 func UpdateOrCreateUser(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	// SCIM requests are made by the directory of an agency and not a user.
+	// The SCIM handlers reject requests without a valid token.
+	if strings.HasPrefix(r.URL.Path, "/scim/") {
+		auth.SCIMAuthLogin(w, r, auth.GetBearerToken(r))
+		next(w, r)
+		return
+	}
+
+	// Stripe webhooks are verified with their signature by the handler
+	if r.URL.Path == "/api/billing/stripe-webhook" {
+		next(w, r)
+		return
+	}
+
 	// Basic authentication
 	apiKey, _, _ := r.BasicAuth()
 	apiKeyValid := false
@@ -22,14 +36,56 @@ func UpdateOrCreateUser(w http.ResponseWriter, r *http.Request, next http.Handle
 	}
 
 	c := appengine.NewContext(r)
+	if apiKeyValid {
+		if !auth.ApiKeyAllowsRequest(r) {
+			w.Header().Set("Content-Type", "application/json")
+			errors.ReturnError(w, http.StatusForbidden, "Forbidden", "This API key does not have access to "+r.URL.Path)
+			return
+		}
+
+		user, _ := apiControllers.GetCurrentUser(c, r)
+		apiControllers.AddUserToContext(c, r, user.Email)
+		next(w, r)
+		return
+	}
+
+	// Bearer token authentication
+	bearerToken := auth.GetBearerToken(r)
+	if bearerToken != "" {
+		if !auth.BearerAuthLogin(w, r, bearerToken) {
+			w.Header().Set("Content-Type", "application/json")
+			errors.ReturnError(w, http.StatusUnauthorized, "Authentication Required", "Your access token is invalid or has expired")
+			return
+		}
+
+		user, _ := apiControllers.GetCurrentUser(c, r)
+		apiControllers.AddUserToContext(c, r, user.Email)
+		next(w, r)
+		return
+	}
+
 	email, err := auth.GetCurrentUserEmail(r)
-	if err != nil && !strings.Contains(r.URL.Path, "/api/auth") && !strings.Contains(r.URL.Path, "/static") && !apiKeyValid {
+	if err != nil && !strings.Contains(r.URL.Path, "/api/auth") && !strings.Contains(r.URL.Path, "/static") {
 		w.Header().Set("Content-Type", "application/json")
 		errors.ReturnError(w, http.StatusUnauthorized, "Authentication Required", "Please login "+utils.APIURL+"/auth/google")
 		return
 	} else {
 		if email != "" {
 			apiControllers.AddUserToContext(c, r, email)
+
+			isAuthPage := strings.Contains(r.URL.Path, "/api/auth") || strings.Contains(r.URL.Path, "/static")
+			user, _ := apiControllers.GetCurrentUser(c, r)
+			if user.IsLoginDisabled() && !isAuthPage {
+				w.Header().Set("Content-Type", "application/json")
+				errors.ReturnError(w, http.StatusForbidden, "Forbidden", "Your account has been disabled")
+				return
+			}
+
+			if !auth.TrackSession(w, r) && !isAuthPage {
+				w.Header().Set("Content-Type", "application/json")
+				errors.ReturnError(w, http.StatusUnauthorized, "Authentication Required", "Your session has been revoked. Please login "+utils.APIURL+"/auth")
+				return
+			}
 		}
 	}
 
@@ -2,6 +2,7 @@ package models
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"google.golang.org/appengine/log"
@@ -11,21 +12,152 @@ import (
 	"github.com/qedus/nds"
 )
 
+type AgencyEmployee struct {
+	UserId int64 `json:"userid"`
+}
+
+type AgencyTeam struct {
+	TeamId int64 `json:"teamid"`
+}
+
+type AgencyClient struct {
+	ClientId int64 `json:"clientid"`
+}
+
+type AgencyMerge struct {
+	AgencyId int64 `json:"agencyid"`
+}
+
+// Single sign-on types
+const (
+	AgencySSOSAML = "saml"
+	AgencySSOOIDC = "oidc"
+)
+
+type AgencySSO struct {
+	Type     string `json:"type"`
+	Enforced bool   `json:"enforced"`
+
+	SAMLMetadataURL string `json:"samlmetadataurl"`
+	SAMLMetadata    string `json:"samlmetadata"`
+
+	OIDCIssuer       string `json:"oidcissuer"`
+	OIDCClientId     string `json:"oidcclientid"`
+	OIDCClientSecret string `json:"oidcclientsecret"`
+}
+
+// Proves that an agency owns an email domain. The record is added to the
+// DNS of the domain as a TXT record.
+type AgencyDomainVerification struct {
+	Domain   string `json:"domain"`
+	Record   string `json:"record"`
+	Verified bool   `json:"verified"`
+}
+
+// The plan an agency pays for on behalf of its team members
+type AgencyPlan struct {
+	PlanId   string `json:"planid"`
+	PlanName string `json:"planname"`
+	Duration string `json:"duration,omitempty"`
+
+	Seats        int `json:"seats"`
+	MinimumSeats int `json:"minimumseats"`
+
+	Expires   time.Time `json:"expires"`
+	IsCancel  bool      `json:"iscancel"`
+	IsPastDue bool      `json:"ispastdue"`
+
+	// Pooled between every seat
+	DailyEmailsAllowed int `json:"dailyemailsallowed"`
+	EmailsSentToday    int `json:"emailssenttoday"`
+}
+
+type AgencyNewPlan struct {
+	Plan     string `json:"plan"`
+	Duration string `json:"duration"`
+
+	// Stripe token of the card of the agency
+	Token string `json:"token"`
+
+	MinimumSeats int `json:"minimumseats"`
+}
+
+type AgencySeats struct {
+	Seats int `json:"seats"`
+
+	// Prorated cost in cents of changing to this many seats right now
+	ProrationCost int64 `json:"prorationcost"`
+}
+
+// Only returned when the token is created
+type AgencySCIMToken struct {
+	Token  string `json:"token"`
+	Prefix string `json:"prefix"`
+}
+
 type Agency struct {
 	Base
 
 	Name  string `json:"name"`
 	Email string `json:"email"`
 
+	// Other email domains of the same agency. Added when agencies are merged.
+	AlternateEmails []string `json:"alternateemails"`
+
 	BillingId int64 `json:"billingid"`
 
 	Administrators []int64 `json:"administrators" datastore:",noindex" apiModel:"User"`
+	Clients        []int64 `json:"clients" apiModel:"Client"`
+
+	// Single sign-on with the identity provider of the agency. When it is
+	// enforced users in the email domain of the agency can only use it.
+	SSOType     string `json:"ssotype"`
+	SSOEnforced bool   `json:"ssoenforced"`
+
+	SAMLMetadataURL string `json:"samlmetadataurl"`
+	SAMLMetadata    string `json:"-" datastore:",noindex"`
+
+	OIDCIssuer       string `json:"oidcissuer"`
+	OIDCClientId     string `json:"oidcclientid"`
+	OIDCClientSecret string `json:"-" datastore:",noindex"`
+
+	// Email domains that the agency has proven it owns, with a DNS TXT
+	// record or through a platform admin. Single sign-on and SCIM only
+	// apply to users in these domains.
+	VerifiedDomains         []string `json:"verifieddomains"`
+	DomainVerificationToken string   `json:"-" datastore:",noindex"`
+
+	// Directories of the agency provision users and teams through SCIM
+	// with this token. Only the hash of the token is stored.
+	SCIMTokenHash   string `json:"-"`
+	SCIMTokenPrefix string `json:"scimtokenprefix"`
+
+	// Shown on receipts
+	AddressLine1      string `json:"addressline1" datastore:",noindex"`
+	AddressLine2      string `json:"addressline2" datastore:",noindex"`
+	AddressCity       string `json:"addresscity" datastore:",noindex"`
+	AddressState      string `json:"addressstate" datastore:",noindex"`
+	AddressPostalCode string `json:"addresspostalcode" datastore:",noindex"`
+	AddressCountry    string `json:"addresscountry" datastore:",noindex"`
 }
 
 /*
 * Public methods
  */
 
+// The address of the agency as it is printed, without blank lines
+func (a *Agency) AddressLines() []string {
+	city := strings.TrimSpace(strings.Join([]string{a.AddressCity, a.AddressState, a.AddressPostalCode}, " "))
+	lines := []string{}
+	candidates := []string{a.AddressLine1, a.AddressLine2, city, a.AddressCountry}
+	for i := 0; i < len(candidates); i++ {
+		if strings.TrimSpace(candidates[i]) != "" {
+			lines = append(lines, candidates[i])
+		}
+	}
+	return lines
+}
+
 /*
 * Create methods
  */
@@ -55,10 +187,74 @@ func (a *Agency) Save(c context.Context) (*Agency, error) {
 	return a, nil
 }
 
+// Function to delete an agency from App Engine
+func (a *Agency) Delete(c context.Context) (*Agency, error) {
+	err := nds.Delete(c, a.BaseKey(c, "Agency"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return a, nil
+}
+
 /*
 * Action methods
  */
 
+func (a *Agency) IsAdministrator(userId int64) bool {
+	for i := 0; i < len(a.Administrators); i++ {
+		if a.Administrators[i] == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Agency) RemoveAdministrator(userId int64) {
+	for i := 0; i < len(a.Administrators); i++ {
+		if a.Administrators[i] == userId {
+			a.Administrators = append(a.Administrators[:i], a.Administrators[i+1:]...)
+			break
+		}
+	}
+}
+
+func (a *Agency) HasClient(clientId int64) bool {
+	for i := 0; i < len(a.Clients); i++ {
+		if a.Clients[i] == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Agency) HasSSO() bool {
+	return a.SSOType == AgencySSOSAML || a.SSOType == AgencySSOOIDC
+}
+
+func (a *Agency) IsDomainVerified(domain string) bool {
+	for i := 0; i < len(a.VerifiedDomains); i++ {
+		if a.VerifiedDomains[i] == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// If an email domain belongs to the agency
+func (a *Agency) HasEmailDomain(domain string) bool {
+	if a.Email == domain {
+		return true
+	}
+
+	for i := 0; i < len(a.AlternateEmails); i++ {
+		if a.AlternateEmails[i] == domain {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Agency) FillStruct(m map[string]interface{}) error {
 	for k, v := range m {
 		err := SetField(a, k, v)
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// Scopes that can be given to an API key
+const (
+	ApiKeyScopeFull          = "full"
+	ApiKeyScopeReadOnly      = "read-only"
+	ApiKeyScopeMediaDatabase = "media-database"
+	ApiKeyScopeBilling       = "billing"
+)
+
+var ApiKeyScopes = []string{ApiKeyScopeFull, ApiKeyScopeReadOnly, ApiKeyScopeMediaDatabase, ApiKeyScopeBilling}
+
+type ApiKey struct {
+	Base
+
+	// The user the key logs in as
+	UserId int64 `json:"userid" apiModel:"User"`
+
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+
+	// Only a hash of the key is stored. The prefix is kept so users can
+	// tell their keys apart.
+	KeyHash string `json:"-"`
+	Prefix  string `json:"prefix"`
+
+	// Only returned once when the key is created
+	Key string `json:"key,omitempty" datastore:"-"`
+
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastused"`
+
+	Revoked   bool      `json:"revoked"`
+	RevokedAt time.Time `json:"revokedat"`
+	RevokedBy int64     `json:"revokedby" apiModel:"User"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (ak *ApiKey) Create(c context.Context, r *http.Request, currentUser User) (*ApiKey, error) {
+	ak.CreatedBy = currentUser.Id
+	ak.Created = time.Now()
+	ak.Revoked = false
+
+	_, err := ak.Save(c)
+	return ak, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new api key into App Engine
+func (ak *ApiKey) Save(c context.Context) (*ApiKey, error) {
+	ak.Updated = time.Now()
+	k, err := nds.Put(c, ak.BaseKey(c, "ApiKey"), ak)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	ak.Id = k.IntID()
+	return ak, nil
+}
+
+func (ak *ApiKey) Revoke(c context.Context, currentUser User) (*ApiKey, error) {
+	ak.Revoked = true
+	ak.RevokedAt = time.Now()
+	ak.RevokedBy = currentUser.Id
+	return ak.Save(c)
+}
+
+/*
+* Action methods
+ */
+
+func (ak *ApiKey) IsExpired() bool {
+	return !ak.Expires.IsZero() && time.Now().After(ak.Expires)
+}
+
+func (ak *ApiKey) IsValid() bool {
+	return !ak.Revoked && !ak.IsExpired()
+}
+
+func (ak *ApiKey) HasScope(scope string) bool {
+	for i := 0; i < len(ak.Scopes); i++ {
+		if ak.Scopes[i] == scope {
+			return true
+		}
+	}
+	return false
+}
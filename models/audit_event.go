@@ -0,0 +1,89 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// Actions in the audit log
+const (
+	AuditActionUserBan            = "user.ban"
+	AuditActionEmailChange        = "user.email.change"
+	AuditActionPasswordReset      = "user.password.reset"
+	AuditActionPasswordChange     = "user.password.change"
+	AuditActionPlanAdd            = "billing.plan.add"
+	AuditActionPlanSwitch         = "billing.plan.switch"
+	AuditActionPlanCancel         = "billing.plan.cancel"
+	AuditActionStripeEvent        = "billing.stripe_event"
+	AuditActionAgencySeatsChange  = "billing.agency.seats"
+	AuditActionPlanCatalogCreate  = "billing.catalog.create"
+	AuditActionPlanCatalogUpdate  = "billing.catalog.update"
+	AuditActionCouponRuleCreate   = "billing.coupon_rule.create"
+	AuditActionCouponRuleUpdate   = "billing.coupon_rule.update"
+	AuditActionAccountLock        = "billing.account.lock"
+	AuditActionTeamCreate         = "team.create"
+	AuditActionTeamDelete         = "team.delete"
+	AuditActionTeamMemberAdd      = "team.member.add"
+	AuditActionTeamMemberRemove   = "team.member.remove"
+	AuditActionTeamAdminAdd       = "team.admin.add"
+	AuditActionTeamAdminRemove    = "team.admin.remove"
+	AuditActionTeamReadOnlyAdd    = "team.readonly.add"
+	AuditActionTeamReadOnlyRemove = "team.readonly.remove"
+	AuditActionTeamOwnerTransfer  = "team.owner.transfer"
+	AuditActionTeamAgencyApprove  = "team.agency.approve"
+	AuditActionTeamAgencyReject   = "team.agency.reject"
+)
+
+// An entry in the audit log. Entries are only ever created.
+type AuditEvent struct {
+	Base
+
+	// Users that are not logged in, like when resetting a password, are
+	// the actor themselves. Directories, tasks and webhooks have no actor.
+	ActorId    int64  `json:"actorid" apiModel:"User"`
+	ActorEmail string `json:"actoremail"`
+
+	Action string `json:"action"`
+
+	TargetType string `json:"targettype"`
+	TargetId   int64  `json:"targetid"`
+
+	// Every user the event is about, including the actor. Used for the
+	// account activity of a user.
+	UserIds []int64 `json:"userids" apiModel:"User"`
+
+	// JSON of the values that changed
+	Before string `json:"before" datastore:",noindex"`
+	After  string `json:"after" datastore:",noindex"`
+
+	IP        string `json:"ip"`
+	UserAgent string `json:"useragent" datastore:",noindex"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (ae *AuditEvent) Create(c context.Context, r *http.Request) (*AuditEvent, error) {
+	ae.Created = time.Now()
+	ae.Updated = ae.Created
+
+	k, err := nds.Put(c, datastore.NewIncompleteKey(c, "AuditEvent", nil), ae)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	ae.Id = k.IntID()
+	return ae, nil
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 
 	"golang.org/x/net/context"
@@ -30,6 +31,42 @@ type Billing struct {
 	TrialEmailSent bool `json:"-"`
 
 	CardsOnFile []string `json:"-"`
+
+	// If the user has ever paid for a plan
+	HasPaid bool `json:"-"`
+
+	// Agency billing, where every member of the agency without a paid plan
+	// of their own takes a seat. Admins can buy more seats than there are
+	// members.
+	AgencyId     int64   `json:"-"`
+	Seats        int     `json:"-"`
+	MinimumSeats int     `json:"-"`
+	SeatUserIds  []int64 `json:"-"`
+
+	// Dunning, when a payment for the subscription has failed
+	IsPastDue         bool      `json:"-"`
+	PastDueSince      time.Time `json:"-"`
+	GracePeriodEnds   time.Time `json:"-"`
+	PaymentRetries    int       `json:"-"`
+	DunningEmailsSent int       `json:"-"`
+	LockedAt          time.Time `json:"-"`
+
+	// The limits of the plan when the billing subscribed to it. Changes to
+	// a plan only apply to new subscribers. LimitsPlanId is the plan they
+	// are for, and is blank when the limits of the plan are used.
+	LimitsPlanId       string `json:"-"`
+	EmailAccounts      int    `json:"-"`
+	DailyEmailsAllowed int    `json:"-"`
+	SocialAccounts     int    `json:"-"`
+	TeamMembers        int    `json:"-"`
+
+	// The end of the latest period that an invoice was paid for. Stripe can
+	// send a failed payment for an invoice after it was paid.
+	StripePaidThrough time.Time `json:"-"`
+
+	// When Stripe created the latest subscription event that was applied.
+	// Stripe doesn't send events in order, so older ones are ignored.
+	StripeSubscriptionUpdated time.Time `json:"-"`
 }
 
 /*
@@ -47,6 +84,33 @@ func (bi *Billing) Create(c context.Context, r *http.Request, currentUser User)
 	return bi, err
 }
 
+// Billings from before HasPaid was added only moved off of the free plan
+// when a plan was paid for
+func (bi *Billing) IsFirstTimeCustomer() bool {
+	return !bi.HasPaid && (bi.StripePlanId == "" || bi.StripePlanId == "free")
+}
+
+// Subscriptions in their grace period are still active
+func (bi *Billing) IsActiveSubscription() bool {
+	if !bi.LockedAt.IsZero() {
+		return false
+	}
+	return bi.IsPastDue || bi.Expires.After(time.Now())
+}
+
+func (bi *Billing) HasSeat(userId int64) bool {
+	for i := 0; i < len(bi.SeatUserIds); i++ {
+		if bi.SeatUserIds[i] == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (bi *Billing) IsGracePeriodOver() bool {
+	return bi.IsPastDue && bi.GracePeriodEnds.Before(time.Now())
+}
+
 /*
 * Update methods
  */
@@ -64,3 +128,19 @@ func (bi *Billing) Save(c context.Context) (*Billing, error) {
 	bi.Id = k.IntID()
 	return bi, nil
 }
+
+// Saves billings that already exist in one batch
+func SaveBillings(c context.Context, billings []Billing) error {
+	keys := make([]*datastore.Key, len(billings))
+	for i := 0; i < len(billings); i++ {
+		billings[i].Updated = time.Now()
+		keys[i] = billings[i].BaseKey(c, "Billing")
+	}
+
+	_, err := nds.PutMulti(c, keys, billings)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
@@ -47,7 +47,7 @@ func (cl *Client) Create(c context.Context, r *http.Request, currentUser User) (
 * Update methods
  */
 
-// Function to save a new billing into App Engine
+// Function to save a new client into App Engine
 func (cl *Client) Save(c context.Context) (*Client, error) {
 	// Update the Updated time
 	cl.Updated = time.Now()
@@ -60,3 +60,27 @@ func (cl *Client) Save(c context.Context) (*Client, error) {
 	cl.Id = k.IntID()
 	return cl, nil
 }
+
+// Function to delete a client from App Engine
+func (cl *Client) Delete(c context.Context) (*Client, error) {
+	err := nds.Delete(c, cl.BaseKey(c, "Client"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return cl, nil
+}
+
+/*
+* Action methods
+ */
+
+func (cl *Client) FillStruct(m map[string]interface{}) error {
+	for k, v := range m {
+		err := SetField(cl, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
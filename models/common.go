@@ -74,7 +74,7 @@ func SetField(obj interface{}, name string, value interface{}) error {
 	}
 
 	// Cast string array
-	if name == "Categories" || name == "Tags" || name == "CC" || name == "BCC" {
+	if name == "Categories" || name == "Tags" || name == "CC" || name == "BCC" || name == "Websites" {
 		returnValue := cast.ToStringSlice(value)
 		val := reflect.ValueOf(returnValue)
 		structFieldValue.Set(val)
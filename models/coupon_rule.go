@@ -0,0 +1,97 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// Restrictions on a Stripe coupon. Coupons without a rule can be used on
+// any plan. Lists that are empty and limits that are zero allow anything.
+type CouponRule struct {
+	Base
+
+	// The id of the coupon in Stripe
+	Code        string `json:"code"`
+	Description string `json:"description"`
+
+	Active bool `json:"active"`
+
+	// "monthly" or "annually"
+	Durations []string `json:"durations"`
+	PlanIds   []string `json:"planids"`
+
+	MaxRedemptions int `json:"maxredemptions"`
+	Redemptions    int `json:"redemptions"`
+
+	StartsAt  time.Time `json:"startsat"`
+	ExpiresAt time.Time `json:"expiresat"`
+
+	FirstTimeCustomersOnly bool `json:"firsttimecustomersonly"`
+}
+
+/*
+* Public methods
+ */
+
+func (cr *CouponRule) AllowsDuration(duration string) bool {
+	if len(cr.Durations) == 0 {
+		return true
+	}
+
+	for i := 0; i < len(cr.Durations); i++ {
+		if cr.Durations[i] == duration {
+			return true
+		}
+	}
+	return false
+}
+
+func (cr *CouponRule) AllowsPlan(plan Plan) bool {
+	if len(cr.PlanIds) == 0 {
+		return true
+	}
+
+	for i := 0; i < len(cr.PlanIds); i++ {
+		if plan.Matches(cr.PlanIds[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+* Create methods
+ */
+
+func (cr *CouponRule) Create(c context.Context, r *http.Request, currentUser User) (*CouponRule, error) {
+	cr.CreatedBy = currentUser.Id
+	cr.Created = time.Now()
+
+	_, err := cr.Save(c)
+	return cr, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new coupon rule into App Engine
+func (cr *CouponRule) Save(c context.Context) (*CouponRule, error) {
+	cr.Updated = time.Now()
+	cr.Code = strings.ToUpper(cr.Code)
+
+	k, err := nds.Put(c, cr.BaseKey(c, "CouponRule"), cr)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	cr.Id = k.IntID()
+	return cr, nil
+}
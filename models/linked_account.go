@@ -0,0 +1,131 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/encryption"
+)
+
+// Identity providers users can link an account from
+const (
+	LinkedAccountGoogle   = "google"
+	LinkedAccountOutlook  = "outlook"
+	LinkedAccountLinkedin = "linkedin"
+)
+
+// An account at an identity provider that is linked to a user. A user has
+// at most one linked account for each provider.
+type LinkedAccount struct {
+	Base
+
+	UserId int64 `json:"userid" apiModel:"User"`
+
+	Provider       string `json:"provider"`
+	ProviderUserId string `json:"provideruserid"`
+	Email          string `json:"email"`
+
+	Scopes []string `json:"scopes" datastore:",noindex"`
+
+	AccessToken  string    `json:"-" datastore:",noindex"`
+	RefreshToken string    `json:"-" datastore:",noindex"`
+	TokenType    string    `json:"-" datastore:",noindex"`
+	Expires      time.Time `json:"expires"`
+
+	// If a stored token is not encrypted with the current key
+	tokensNeedEncryption bool
+}
+
+/*
+* Private methods
+ */
+
+func (la *LinkedAccount) encryptTokens() error {
+	accessToken, err := encryption.EncryptString(la.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := encryption.EncryptString(la.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	la.AccessToken = accessToken
+	la.RefreshToken = refreshToken
+	return nil
+}
+
+func (la *LinkedAccount) decryptTokens() {
+	la.tokensNeedEncryption = encryption.NeedsEncryption(la.AccessToken) || encryption.NeedsEncryption(la.RefreshToken)
+	la.AccessToken, _ = encryption.DecryptString(la.AccessToken)
+	la.RefreshToken, _ = encryption.DecryptString(la.RefreshToken)
+}
+
+/*
+* Public methods
+ */
+
+// Tokens are decrypted when a linked account is loaded
+func (la *LinkedAccount) Format(key *datastore.Key, modelType string) {
+	la.Base.Format(key, modelType)
+	la.decryptTokens()
+}
+
+func (la *LinkedAccount) NeedsEncryption() bool {
+	return la.tokensNeedEncryption
+}
+
+/*
+* Create methods
+ */
+
+func (la *LinkedAccount) Create(c context.Context, r *http.Request, currentUser User) (*LinkedAccount, error) {
+	la.CreatedBy = currentUser.Id
+	la.Created = time.Now()
+
+	_, err := la.Save(c)
+	return la, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new linked account into App Engine
+func (la *LinkedAccount) Save(c context.Context) (*LinkedAccount, error) {
+	la.Updated = time.Now()
+
+	// Tokens are only encrypted in the copy that is stored
+	storedLinkedAccount := *la
+	err := storedLinkedAccount.encryptTokens()
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+
+	k, err := nds.Put(c, la.BaseKey(c, "LinkedAccount"), &storedLinkedAccount)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	la.Id = k.IntID()
+	return la, nil
+}
+
+// Function to delete a linked account from App Engine
+func (la *LinkedAccount) Delete(c context.Context) (*LinkedAccount, error) {
+	err := nds.Delete(c, la.BaseKey(c, "LinkedAccount"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return la, nil
+}
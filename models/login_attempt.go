@@ -0,0 +1,108 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// Kinds of login attempts
+const (
+	LoginAttemptPassword       = "password"
+	LoginAttemptTwoFactor      = "two-factor"
+	LoginAttemptForgetPassword = "forget-password"
+)
+
+// A record of someone trying to log in or reset their password
+type LoginAttempt struct {
+	Base
+
+	Email     string `json:"email"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"useragent" datastore:",noindex"`
+	Kind      string `json:"kind"`
+
+	Success bool `json:"success"`
+
+	// If the attempt was stopped because of a lockout
+	Blocked bool `json:"blocked"`
+}
+
+// Failed attempts for an email or IP address. Key is the kind followed by
+// the email or IP address, and is also the name of the datastore key.
+type LoginLockout struct {
+	Base
+
+	Key string `json:"key"`
+
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"firstfailure"`
+
+	// Every lockout makes the next one longer
+	Lockouts    int       `json:"lockouts"`
+	LockedUntil time.Time `json:"lockeduntil"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (la *LoginAttempt) Create(c context.Context, r *http.Request) (*LoginAttempt, error) {
+	la.Created = time.Now()
+
+	_, err := la.Save(c)
+	return la, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new login attempt into App Engine
+func (la *LoginAttempt) Save(c context.Context) (*LoginAttempt, error) {
+	la.Updated = time.Now()
+	k, err := nds.Put(c, la.BaseKey(c, "LoginAttempt"), la)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	la.Id = k.IntID()
+	return la, nil
+}
+
+// Lockouts are keyed by their key so they can be updated in a transaction
+func (ll *LoginLockout) LockoutKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, "LoginLockout", ll.Key, 0, nil)
+}
+
+// Function to save a new login lockout into App Engine
+func (ll *LoginLockout) Save(c context.Context) (*LoginLockout, error) {
+	if ll.Created.IsZero() {
+		ll.Created = time.Now()
+	}
+	ll.Updated = time.Now()
+	_, err := nds.Put(c, ll.LockoutKey(c), ll)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return ll, nil
+}
+
+/*
+* Action methods
+ */
+
+func (ll *LoginLockout) IsLocked() bool {
+	return time.Now().Before(ll.LockedUntil)
+}
@@ -1,8 +1,86 @@
 package models
 
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// A plan that users can subscribe to. Plans that are not stored use the
+// defaults in the billing package.
 type Plan struct {
-	Name     string
-	StripeId string
+	Base
+
+	// The Stripe id of the monthly plan. This is what is stored on the
+	// billing of a user.
+	PlanId string `json:"planid"`
+	Name   string `json:"name"`
+
+	// Other Stripe ids of the plan, like legacy ones
+	StripePlanIds []string `json:"stripeplanids"`
+
+	MonthlyPrice float64 `json:"monthlyprice"`
+	YearlyPrice  float64 `json:"yearlyprice"`
+
+	EmailAccounts      int `json:"emailaccounts"`
+	DailyEmailsAllowed int `json:"dailyemailsallowed"`
+	SocialAccounts     int `json:"socialaccounts"`
+	TeamMembers        int `json:"teammembers"`
+
+	// Retired plans can't be chosen anymore. Users that are on them keep
+	// their limits.
+	Retired bool `json:"retired"`
+}
+
+/*
+* Public methods
+ */
+
+// If the plan is the one with this Stripe id or name
+func (p *Plan) Matches(planId string) bool {
+	planId = strings.ToLower(planId)
+	if strings.ToLower(p.PlanId) == planId || strings.ToLower(p.Name) == planId {
+		return true
+	}
+
+	for i := 0; i < len(p.StripePlanIds); i++ {
+		if strings.ToLower(p.StripePlanIds[i]) == planId {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+* Create methods
+ */
+
+func (p *Plan) Create(c context.Context, r *http.Request, currentUser User) (*Plan, error) {
+	p.CreatedBy = currentUser.Id
+	p.Created = time.Now()
+
+	_, err := p.Save(c)
+	return p, err
+}
+
+/*
+* Update methods
+ */
 
-	Active bool
+// Function to save a new plan into App Engine
+func (p *Plan) Save(c context.Context) (*Plan, error) {
+	p.Updated = time.Now()
+	k, err := nds.Put(c, p.BaseKey(c, "Plan"), p)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	p.Id = k.IntID()
+	return p, nil
 }
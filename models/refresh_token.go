@@ -0,0 +1,69 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+type RefreshToken struct {
+	Base
+
+	UserId int64 `json:"userid" apiModel:"User"`
+
+	// Only a hash of the token is stored
+	TokenHash string `json:"-"`
+
+	Expires time.Time `json:"expires"`
+
+	// Refresh tokens can only be used once. When they are used they are
+	// revoked and replaced by a new one.
+	Revoked    bool  `json:"revoked"`
+	ReplacedBy int64 `json:"replacedby"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (rt *RefreshToken) Create(c context.Context, r *http.Request, currentUser User) (*RefreshToken, error) {
+	rt.CreatedBy = currentUser.Id
+	rt.Created = time.Now()
+	rt.Revoked = false
+
+	_, err := rt.Save(c)
+	return rt, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new refresh token into App Engine
+func (rt *RefreshToken) Save(c context.Context) (*RefreshToken, error) {
+	rt.Updated = time.Now()
+	k, err := nds.Put(c, rt.BaseKey(c, "RefreshToken"), rt)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	rt.Id = k.IntID()
+	return rt, nil
+}
+
+/*
+* Action methods
+ */
+
+func (rt *RefreshToken) IsValid() bool {
+	return !rt.Revoked && time.Now().Before(rt.Expires)
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SCIM 2.0 (RFC 7643 and RFC 7644) resources. SCIM users are users and
+// SCIM groups are teams.
+const (
+	SCIMSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type SCIMName struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary"`
+}
+
+// A member of a group or a group of a user
+type SCIMReference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type SCIMUser struct {
+	Schemas    []string `json:"schemas"`
+	Id         string   `json:"id,omitempty"`
+	ExternalId string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+
+	Name   SCIMName    `json:"name"`
+	Emails []SCIMEmail `json:"emails,omitempty"`
+
+	// Users are active when they are not sent
+	Active *bool `json:"active,omitempty"`
+
+	Groups []SCIMReference `json:"groups,omitempty"`
+
+	Meta *SCIMMeta `json:"meta,omitempty"`
+}
+
+type SCIMGroup struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id,omitempty"`
+	ExternalId  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+
+	Members []SCIMReference `json:"members"`
+
+	Meta *SCIMMeta `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type SCIMPatch struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+/*
+* Public methods
+ */
+
+func NewSCIMError(status int, scimType string, detail string) SCIMError {
+	return SCIMError{
+		Schemas:  []string{SCIMSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+func (e SCIMError) Error() string {
+	return e.Detail
+}
+
+func (e SCIMError) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	return status
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// A Stripe event that has been handled. Key is the id of the event at
+// Stripe, and is also the name of the datastore key, so an event that
+// Stripe sends again is only handled once.
+type StripeEvent struct {
+	Base
+
+	Key  string `json:"key"`
+	Type string `json:"type"`
+
+	// When Stripe created the event
+	EventCreated time.Time `json:"eventcreated"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Update methods
+ */
+
+// Events are keyed by their key so they can be claimed in a transaction
+func (se *StripeEvent) EventKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, "StripeEvent", se.Key, 0, nil)
+}
+
+// Function to save a new Stripe event into App Engine
+func (se *StripeEvent) Save(c context.Context) (*StripeEvent, error) {
+	if se.Created.IsZero() {
+		se.Created = time.Now()
+	}
+	se.Updated = time.Now()
+	_, err := nds.Put(c, se.EventKey(c), se)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return se, nil
+}
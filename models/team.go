@@ -11,6 +11,10 @@ import (
 	"github.com/qedus/nds"
 )
 
+type TeamMember struct {
+	UserId int64 `json:"userid"`
+}
+
 type Team struct {
 	Base
 
@@ -18,10 +22,21 @@ type Team struct {
 
 	AgencyId int64 `json:"agencyid" apiModel:"Agency"`
 
+	// Agency that the team asked to join. The team is only part of the
+	// agency once an administrator of the agency approves it.
+	RequestedAgencyId int64 `json:"requestedagencyid" apiModel:"Agency"`
+
 	MaxMembers int `json:"maxmembers" apiModel:"User"`
 
 	Members []int64 `json:"members" apiModel:"User"`
 	Admins  []int64 `json:"admins" apiModel:"User"`
+
+	// Members that can only see the resources of the team
+	ReadOnlyMembers []int64 `json:"readonlymembers" apiModel:"User"`
+
+	// Id of the group in the directory of the agency when the team is
+	// provisioned through SCIM
+	SCIMExternalId string `json:"-"`
 }
 
 /*
@@ -59,3 +74,92 @@ func (t *Team) Save(c context.Context) (*Team, error) {
 	t.Id = k.IntID()
 	return t, nil
 }
+
+// Function to delete a team from App Engine
+func (t *Team) Delete(c context.Context) (*Team, error) {
+	err := nds.Delete(c, t.BaseKey(c, "Team"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+/*
+* Action methods
+ */
+
+func (t *Team) IsMember(userId int64) bool {
+	for i := 0; i < len(t.Members); i++ {
+		if t.Members[i] == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Team) IsAdmin(userId int64) bool {
+	for i := 0; i < len(t.Admins); i++ {
+		if t.Admins[i] == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Team) IsReadOnly(userId int64) bool {
+	for i := 0; i < len(t.ReadOnlyMembers); i++ {
+		if t.ReadOnlyMembers[i] == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Team) AddMember(userId int64) {
+	if !t.IsMember(userId) {
+		t.Members = append(t.Members, userId)
+	}
+}
+
+// Removing a member also removes them as an admin of the team
+func (t *Team) RemoveMember(userId int64) {
+	for i := 0; i < len(t.Members); i++ {
+		if t.Members[i] == userId {
+			t.Members = append(t.Members[:i], t.Members[i+1:]...)
+			break
+		}
+	}
+	t.RemoveAdmin(userId)
+	t.RemoveReadOnly(userId)
+}
+
+func (t *Team) AddAdmin(userId int64) {
+	if !t.IsAdmin(userId) {
+		t.Admins = append(t.Admins, userId)
+	}
+}
+
+func (t *Team) RemoveAdmin(userId int64) {
+	for i := 0; i < len(t.Admins); i++ {
+		if t.Admins[i] == userId {
+			t.Admins = append(t.Admins[:i], t.Admins[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *Team) AddReadOnly(userId int64) {
+	if !t.IsReadOnly(userId) {
+		t.ReadOnlyMembers = append(t.ReadOnlyMembers, userId)
+	}
+}
+
+func (t *Team) RemoveReadOnly(userId int64) {
+	for i := 0; i < len(t.ReadOnlyMembers); i++ {
+		if t.ReadOnlyMembers[i] == userId {
+			t.ReadOnlyMembers = append(t.ReadOnlyMembers[:i], t.ReadOnlyMembers[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// What usage is metered. Social accounts are what clients track now
+// rather than a count, so they are not metered with counters.
+const (
+	UsageEmailsSent        = "emails-sent"
+	UsageEnrichmentCredits = "enrichment-credits"
+	UsageSocialAccounts    = "social-accounts"
+	UsageMediaSearches     = "media-searches"
+)
+
+// Usage is counted for each day and month
+const (
+	UsagePeriodDay   = "day"
+	UsagePeriodMonth = "month"
+)
+
+// How much of a metric a user, team or agency has used in a day or a
+// month. The key is the owner, metric and period, like
+// "user:1:emails-sent:day:2017-01-31".
+type UsageCounter struct {
+	Base
+
+	Key string `json:"key" datastore:"-"`
+
+	OwnerType string `json:"ownertype"`
+	OwnerId   int64  `json:"ownerid"`
+
+	Metric      string    `json:"metric"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodstart"`
+
+	Count int `json:"count"`
+}
+
+// Usage of a metric and its limits. Limits below zero are unlimited.
+type UsageMetric struct {
+	Metric string `json:"metric"`
+
+	Today      int `json:"today"`
+	DailyLimit int `json:"dailylimit"`
+
+	ThisMonth    int `json:"thismonth"`
+	MonthlyLimit int `json:"monthlylimit"`
+
+	// Limits that are shared by everyone with a seat on an agency plan
+	Pooled bool `json:"pooled"`
+
+	// Gauges are what is used now instead of in a period, so today and
+	// this month are the same
+	Gauge bool `json:"gauge"`
+}
+
+type UserUsage struct {
+	UserId   int64  `json:"userid"`
+	PlanName string `json:"planname"`
+
+	Metrics []UsageMetric `json:"metrics"`
+
+	// Usage of everyone on the team of the user
+	TeamId      int64         `json:"teamid"`
+	TeamMetrics []UsageMetric `json:"teammetrics"`
+}
+
+// Usage that other services report, like the emails that they send
+type UsageReport struct {
+	Metric string `json:"metric"`
+	Amount int    `json:"amount"`
+}
+
+/*
+* Public methods
+ */
+
+func (uc *UsageCounter) CounterKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, "UsageCounter", uc.Key, 0, nil)
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a usage counter into App Engine
+func (uc *UsageCounter) Save(c context.Context) (*UsageCounter, error) {
+	if uc.Created.IsZero() {
+		uc.Created = time.Now()
+	}
+	uc.Updated = time.Now()
+	_, err := nds.Put(c, uc.CounterKey(c), uc)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return uc, nil
+}
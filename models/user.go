@@ -7,9 +7,12 @@ import (
 
 	"golang.org/x/net/context"
 
+	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 
 	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/encryption"
 )
 
 type UserFeedback struct {
@@ -26,6 +29,14 @@ type UserPlan struct {
 	EmailsSentToday int `json:"emailssenttoday"`
 
 	OnTrial bool `json:"ontrial"`
+
+	// Users with a seat on the plan of their agency share its daily emails
+	AgencyPlan bool `json:"agencyplan"`
+
+	// Shown when a payment has failed and the account will be locked
+	// when the grace period ends
+	ShowPaymentBanner bool      `json:"showpaymentbanner"`
+	GracePeriodEnds   time.Time `json:"graceperiodends"`
 }
 
 type UserNewPlan struct {
@@ -34,6 +45,17 @@ type UserNewPlan struct {
 	Coupon   string `json:"coupon"`
 }
 
+type UserTwoFactor struct {
+	Enabled     bool     `json:"enabled"`
+	Secret      string   `json:"secret,omitempty"`
+	OTPAuthURL  string   `json:"otpauthurl,omitempty"`
+	BackupCodes []string `json:"backupcodes,omitempty"`
+}
+
+type UserTwoFactorCode struct {
+	Code string `json:"code"`
+}
+
 type UserLiveToken struct {
 	Token   string    `json:"token"`
 	Expires time.Time `json:"expires"`
@@ -57,7 +79,12 @@ type User struct {
 	Password []byte `json:"-"`
 	ApiKey   string `json:"-"`
 
-	Employers []int64 `json:"employers" apiModel:"Agency"`
+	Employers     []int64 `json:"employers" apiModel:"Agency"`
+	PastEmployers []int64 `json:"pastemployers" apiModel:"Agency"`
+
+	// The agency whose single sign-on the user has opted into. Existing
+	// users can only log in through the single sign-on of this agency.
+	SSOAgencyId int64 `json:"ssoagencyid" apiModel:"Agency"`
 
 	ResetPasswordCode      string `json:"-"`
 	ConfirmationCode       string `json:"-"`
@@ -65,7 +92,8 @@ type User struct {
 
 	LastLoggedIn time.Time `json:"-"`
 
-	// Social network settings
+	// Social network settings. LinkedinAuthKey is a copy of the token on
+	// the user's linked account, kept until everything reads linked accounts.
 	LinkedinId      string `json:"-"`
 	LinkedinAuthKey string `json:"-"`
 
@@ -86,6 +114,9 @@ type User struct {
 	EmailSignature  string   `json:"emailsignature" datastore:",noindex"`
 	EmailSignatures []string `json:"emailsignatures" datastore:",noindex"`
 
+	// Copies of the OAuth tokens on the user's linked accounts. Sending
+	// still reads them from here, so they are kept in sync until it reads
+	// linked accounts.
 	Gmail           bool      `json:"gmail"`
 	AccessToken     string    `json:"-"`
 	GoogleCode      string    `json:"-"`
@@ -115,6 +146,22 @@ type User struct {
 
 	PromoCode string `json:"-"`
 
+	// Two-factor authentication. Backup codes are stored hashed.
+	TwoFactorEnabled       bool     `json:"twofactorenabled"`
+	TwoFactorSecret        string   `json:"-" datastore:",noindex"`
+	TwoFactorPendingSecret string   `json:"-" datastore:",noindex"`
+	TwoFactorBackupCodes   []string `json:"-" datastore:",noindex"`
+	TwoFactorLastStep      int64    `json:"-" datastore:",noindex"`
+
+	// Id of the user in the directory of their agency when they are
+	// provisioned through SCIM
+	SCIMExternalId string `json:"-"`
+
+	// Agency whose directory manages the user, and if the directory has
+	// deactivated them. Deactivated users don't take a seat.
+	SCIMAgencyId    int64 `json:"-"`
+	SCIMDeactivated bool  `json:"-"`
+
 	IsAdmin bool `json:"-"`
 
 	TabulaeV2 bool `json:"tabulaev2"`
@@ -129,12 +176,84 @@ type User struct {
 	Profile int64  `json:"-"`
 
 	EnhanceCredits int `json:"-"`
+
+	// If a stored secret is not encrypted with the current key
+	secretsNeedEncryption bool
+}
+
+/*
+* Private methods
+ */
+
+// OAuth tokens and other secrets that are encrypted at rest
+func (u *User) secretFields() []*string {
+	return []*string{
+		&u.AccessToken,
+		&u.RefreshToken,
+		&u.OutlookAccessToken,
+		&u.OutlookRefreshToken,
+		&u.LinkedinAuthKey,
+		&u.TwoFactorSecret,
+		&u.TwoFactorPendingSecret,
+	}
+}
+
+func (u *User) encryptSecrets() error {
+	fields := u.secretFields()
+	for i := 0; i < len(fields); i++ {
+		encrypted, err := encryption.EncryptString(*fields[i])
+		if err != nil {
+			return err
+		}
+		*fields[i] = encrypted
+	}
+
+	smtpPassword, err := encryption.EncryptBytes(u.SMTPPassword)
+	if err != nil {
+		return err
+	}
+	u.SMTPPassword = smtpPassword
+	return nil
+}
+
+// Secrets that can't be decrypted are kept encrypted so saving the user
+// doesn't lose them
+func (u *User) decryptSecrets() {
+	u.secretsNeedEncryption = encryption.NeedsEncryption(string(u.SMTPPassword))
+
+	fields := u.secretFields()
+	for i := 0; i < len(fields); i++ {
+		if encryption.NeedsEncryption(*fields[i]) {
+			u.secretsNeedEncryption = true
+		}
+		*fields[i], _ = encryption.DecryptString(*fields[i])
+	}
+
+	u.SMTPPassword, _ = encryption.DecryptBytes(u.SMTPPassword)
 }
 
 /*
 * Public methods
  */
 
+// Secrets are decrypted when a user is loaded
+func (u *User) Format(key *datastore.Key, modelType string) {
+	u.Base.Format(key, modelType)
+	u.decryptSecrets()
+}
+
+// Users with secrets that are not encrypted with the current key have to
+// be saved again
+func (u *User) NeedsEncryption() bool {
+	return u.secretsNeedEncryption
+}
+
+// Banned users and users that the directory of their agency deactivated
+// can't log in or use api keys and tokens
+func (u *User) IsLoginDisabled() bool {
+	return u.IsBanned || u.SCIMDeactivated
+}
+
 /*
 * Create methods
  */
@@ -166,7 +285,15 @@ func (u *User) Create(c context.Context, r *http.Request) (*User, error) {
 func (u *User) Save(c context.Context) (*User, error) {
 	u.Updated = time.Now()
 
-	k, err := nds.Put(c, u.BaseKey(c, "User"), u)
+	// Secrets are only encrypted in the copy of the user that is stored
+	storedUser := *u
+	err := storedUser.encryptSecrets()
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+
+	k, err := nds.Put(c, u.BaseKey(c, "User"), &storedUser)
 	if err != nil {
 		log.Errorf(c, "%v", err)
 		return nil, err
@@ -175,6 +302,30 @@ func (u *User) Save(c context.Context) (*User, error) {
 	return u, nil
 }
 
+// Saves users that already exist in one batch
+func SaveUsers(c context.Context, users []User) error {
+	keys := make([]*datastore.Key, len(users))
+	storedUsers := make([]User, len(users))
+	for i := 0; i < len(users); i++ {
+		users[i].Updated = time.Now()
+		keys[i] = users[i].BaseKey(c, "User")
+
+		storedUsers[i] = users[i]
+		err := storedUsers[i].encryptSecrets()
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			return err
+		}
+	}
+
+	_, err := nds.PutMulti(c, keys, storedUsers)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
+
 func (u *User) ConfirmEmail(c context.Context) (*User, error) {
 	u.EmailConfirmed = true
 	u.ConfirmationCode = ""
@@ -14,6 +14,7 @@ import (
 type Invite struct {
 	Email        string `json:"email"`
 	PersonalNote string `json:"personalnote"`
+	TeamId       int64  `json:"teamid"`
 }
 
 type UserInviteCode struct {
@@ -22,6 +23,9 @@ type UserInviteCode struct {
 	InviteCode string `json:"invitecode"`
 	Email      string `json:"email"`
 	IsUsed     bool   `json:"isused"`
+
+	// If the invite is to join a particular team
+	TeamId int64 `json:"teamid"`
 }
 
 /*
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// A browser session of a user. The id is kept in the gaesessions session
+// so a session can be revoked from anywhere.
+type UserSession struct {
+	Base
+
+	UserId int64 `json:"userid" apiModel:"User"`
+
+	Device    string `json:"device"`
+	UserAgent string `json:"useragent" datastore:",noindex"`
+	IP        string `json:"ip"`
+
+	LastSeen time.Time `json:"lastseen"`
+
+	Revoked   bool      `json:"revoked"`
+	RevokedAt time.Time `json:"revokedat"`
+
+	// If this is the session the request was made with
+	Current bool `json:"current" datastore:"-"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (us *UserSession) Create(c context.Context, r *http.Request, currentUser User) (*UserSession, error) {
+	us.CreatedBy = currentUser.Id
+	us.Created = time.Now()
+	us.LastSeen = us.Created
+	us.Revoked = false
+
+	_, err := us.Save(c)
+	return us, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new user session into App Engine
+func (us *UserSession) Save(c context.Context) (*UserSession, error) {
+	us.Updated = time.Now()
+	k, err := nds.Put(c, us.BaseKey(c, "UserSession"), us)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	us.Id = k.IntID()
+	return us, nil
+}
+
+func (us *UserSession) Revoke(c context.Context) (*UserSession, error) {
+	us.Revoked = true
+	us.RevokedAt = time.Now()
+	return us.Save(c)
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+)
+
+// A run of a task that goes through every user in batches. Batches are
+// added to it as they finish.
+type UserSweepRun struct {
+	Base
+
+	Sweep string `json:"sweep"`
+
+	// Every batch has been queued once the last page of users is reached
+	BatchesQueued bool `json:"batchesqueued"`
+	Batches       int  `json:"batches"`
+	BatchesDone   int  `json:"batchesdone"`
+
+	// Pages of users whose batch has been queued or is done, so tasks
+	// that are retried aren't counted twice
+	QueuedPages []int `json:"-" datastore:",noindex"`
+	DonePages   []int `json:"-" datastore:",noindex"`
+
+	UsersProcessed int `json:"usersprocessed"`
+	UsersUpdated   int `json:"usersupdated"`
+	UsersFailed    int `json:"usersfailed"`
+
+	Finished time.Time `json:"finished"`
+}
+
+/*
+* Public methods
+ */
+
+/*
+* Create methods
+ */
+
+func (usr *UserSweepRun) Create(c context.Context, r *http.Request) (*UserSweepRun, error) {
+	usr.Created = time.Now()
+
+	_, err := usr.Save(c)
+	return usr, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new user sweep run into App Engine
+func (usr *UserSweepRun) Save(c context.Context) (*UserSweepRun, error) {
+	usr.Updated = time.Now()
+	k, err := nds.Put(c, usr.BaseKey(c, "UserSweepRun"), usr)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	usr.Id = k.IntID()
+	return usr, nil
+}
+
+/*
+* Action methods
+ */
+
+func (usr *UserSweepRun) IsFinished() bool {
+	return usr.BatchesQueued && usr.BatchesDone >= usr.Batches
+}
+
+func (usr *UserSweepRun) IsPageQueued(page int) bool {
+	for i := 0; i < len(usr.QueuedPages); i++ {
+		if usr.QueuedPages[i] == page {
+			return true
+		}
+	}
+	return false
+}
+
+func (usr *UserSweepRun) IsPageDone(page int) bool {
+	for i := 0; i < len(usr.DonePages); i++ {
+		if usr.DonePages[i] == page {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/encryption"
+)
+
+// Account lifecycle events that webhooks can subscribe to
+const (
+	WebhookEventUserRegistered     = "user.registered"
+	WebhookEventUserEmailConfirmed = "user.email_confirmed"
+	WebhookEventUserTrialStarted   = "user.trial_started"
+	WebhookEventUserPaid           = "user.paid"
+	WebhookEventUserCanceled       = "user.canceled"
+	WebhookEventUserBanned         = "user.banned"
+)
+
+var WebhookEvents = []string{
+	WebhookEventUserRegistered,
+	WebhookEventUserEmailConfirmed,
+	WebhookEventUserTrialStarted,
+	WebhookEventUserPaid,
+	WebhookEventUserCanceled,
+	WebhookEventUserBanned,
+}
+
+// States of a webhook delivery
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// An endpoint that is sent the events it subscribes to
+type WebhookSubscription struct {
+	Base
+
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+
+	Active bool `json:"active"`
+
+	// Payloads are signed with the secret. It is stored encrypted since
+	// we need it to sign, and is only returned when it is created.
+	Secret        string `json:"-" datastore:",noindex"`
+	SigningSecret string `json:"secret,omitempty" datastore:"-"`
+}
+
+// A delivery of an event to a subscription, with every attempt at it
+type WebhookDelivery struct {
+	Base
+
+	SubscriptionId int64  `json:"subscriptionid"`
+	Event          string `json:"event"`
+	Payload        string `json:"payload" datastore:",noindex"`
+
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+
+	LastAttempt    time.Time `json:"lastattempt"`
+	ResponseStatus int       `json:"responsestatus"`
+	ResponseBody   string    `json:"responsebody" datastore:",noindex"`
+	LastError      string    `json:"lasterror" datastore:",noindex"`
+
+	Delivered time.Time `json:"delivered"`
+}
+
+/*
+* Public methods
+ */
+
+// The secret is decrypted when a subscription is loaded
+func (ws *WebhookSubscription) Format(key *datastore.Key, modelType string) {
+	ws.Base.Format(key, modelType)
+	ws.Secret, _ = encryption.DecryptString(ws.Secret)
+}
+
+func (ws *WebhookSubscription) HasEvent(event string) bool {
+	for i := 0; i < len(ws.Events); i++ {
+		if ws.Events[i] == event {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+* Create methods
+ */
+
+func (ws *WebhookSubscription) Create(c context.Context, r *http.Request, currentUser User) (*WebhookSubscription, error) {
+	ws.CreatedBy = currentUser.Id
+	ws.Created = time.Now()
+
+	_, err := ws.Save(c)
+	return ws, err
+}
+
+func (wd *WebhookDelivery) Create(c context.Context, r *http.Request) (*WebhookDelivery, error) {
+	wd.Created = time.Now()
+	wd.Status = WebhookDeliveryPending
+
+	_, err := wd.Save(c)
+	return wd, err
+}
+
+/*
+* Update methods
+ */
+
+// Function to save a new webhook subscription into App Engine
+func (ws *WebhookSubscription) Save(c context.Context) (*WebhookSubscription, error) {
+	ws.Updated = time.Now()
+
+	// The secret is only encrypted in the copy that is stored
+	storedSubscription := *ws
+	secret, err := encryption.EncryptString(ws.Secret)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	storedSubscription.Secret = secret
+
+	k, err := nds.Put(c, ws.BaseKey(c, "WebhookSubscription"), &storedSubscription)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	ws.Id = k.IntID()
+	return ws, nil
+}
+
+// Function to save a new webhook delivery into App Engine
+func (wd *WebhookDelivery) Save(c context.Context) (*WebhookDelivery, error) {
+	wd.Updated = time.Now()
+	k, err := nds.Put(c, wd.BaseKey(c, "WebhookDelivery"), wd)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	wd.Id = k.IntID()
+	return wd, nil
+}
+
+/*
+* Delete methods
+ */
+
+func (ws *WebhookSubscription) Delete(c context.Context) (*WebhookSubscription, error) {
+	err := nds.Delete(c, ws.BaseKey(c, "WebhookSubscription"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return nil, err
+	}
+	return ws, nil
+}
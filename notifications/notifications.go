@@ -0,0 +1,108 @@
+// Package notifications sends emails to users about their account, like
+// when they have to connect their email again.
+package notifications
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/utils"
+)
+
+/*
+* Private methods
+ */
+
+func getSender() string {
+	sender := os.Getenv("NOTIFICATIONSENDER")
+	if sender == "" {
+		return "NewsAI <hello@newsai.co>"
+	}
+	return sender
+}
+
+func getName(user models.User) string {
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return "there"
+}
+
+/*
+* Public methods
+ */
+
+func SendEmail(c context.Context, user models.User, subject string, body string) error {
+	msg := &mail.Message{
+		Sender:  getSender(),
+		To:      []string{user.Email},
+		Subject: subject,
+		Body:    body,
+	}
+
+	err := mail.Send(c, msg)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+	return nil
+}
+
+// Tells a user that we can't send emails from Gmail or Outlook for them
+// anymore until they connect it again
+func ReconnectEmailProvider(c context.Context, user models.User, provider string) error {
+	body := []string{
+		"Hi " + getName(user) + ",",
+		"",
+		"We could not refresh your connection to " + provider + ", so emails will no longer be sent from it.",
+		"Please connect " + provider + " again from your settings: https://tabulae.newsai.co/settings",
+		"",
+		"The NewsAI team",
+	}
+	return SendEmail(c, user, "Please reconnect "+provider+" to NewsAI", strings.Join(body, "\n"))
+}
+
+func TrialWillEnd(c context.Context, user models.User, trialEnd time.Time) error {
+	body := []string{
+		"Hi " + getName(user) + ",",
+		"",
+		"Your NewsAI trial ends on " + trialEnd.Format("January 2, 2006") + ".",
+		"To keep using NewsAI after that, choose a plan: " + utils.APIURL + "/billing/plans",
+		"",
+		"The NewsAI team",
+	}
+	return SendEmail(c, user, "Your NewsAI trial is ending soon", strings.Join(body, "\n"))
+}
+
+// Reminds a user that their payment failed and when their account will
+// be locked if it is not fixed
+func PaymentFailed(c context.Context, user models.User, gracePeriodEnds time.Time) error {
+	body := []string{
+		"Hi " + getName(user) + ",",
+		"",
+		"We could not charge your card for your NewsAI subscription.",
+		"Please update your payment details before " + gracePeriodEnds.Format("January 2, 2006") + " to keep your account: " + utils.APIURL + "/billing",
+		"",
+		"The NewsAI team",
+	}
+	return SendEmail(c, user, "Your NewsAI payment failed", strings.Join(body, "\n"))
+}
+
+func AccountLocked(c context.Context, user models.User) error {
+	body := []string{
+		"Hi " + getName(user) + ",",
+		"",
+		"We could not charge your card for your NewsAI subscription, so your account has been locked.",
+		"Update your payment details to unlock it: " + utils.APIURL + "/billing",
+		"",
+		"The NewsAI team",
+	}
+	return SendEmail(c, user, "Your NewsAI account has been locked", strings.Join(body, "\n"))
+}
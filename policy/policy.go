@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+
+	"github.com/news-ai/api/models"
+)
+
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReadOnly
+	RoleMember
+	RoleTeamAdmin
+	RoleAgencyAdmin
+	RolePlatformAdmin
+)
+
+type Action string
+
+const (
+	ActionRead    Action = "read"
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionManage  Action = "manage"
+	ActionBilling Action = "billing"
+)
+
+// A whole collection of resources. For example every user on the platform.
+type Collection string
+
+var ErrForbidden = errors.New("Forbidden")
+
+// The minimum role a user needs on a resource to perform an action on it.
+// Actions that are not in here are only allowed for platform admins.
+var rules = map[string]map[Action]Role{
+	"User": {
+		ActionRead:    RoleReadOnly,
+		ActionUpdate:  RoleMember,
+		ActionBilling: RoleMember,
+		ActionManage:  RolePlatformAdmin,
+	},
+	"Team": {
+		ActionRead:   RoleReadOnly,
+		ActionUpdate: RoleTeamAdmin,
+		ActionManage: RoleTeamAdmin,
+		ActionDelete: RoleTeamAdmin,
+	},
+	"Agency": {
+		ActionRead:   RoleMember,
+		ActionUpdate: RoleAgencyAdmin,
+		ActionManage: RoleAgencyAdmin,
+	},
+	"Client": {
+		ActionRead:   RoleReadOnly,
+		ActionCreate: RoleMember,
+		ActionUpdate: RoleMember,
+		ActionDelete: RoleMember,
+		ActionManage: RoleTeamAdmin,
+	},
+	"UserInviteCode": {
+		ActionRead:   RoleMember,
+		ActionCreate: RoleMember,
+		ActionUpdate: RoleMember,
+		ActionDelete: RoleMember,
+		ActionManage: RoleTeamAdmin,
+	},
+	"UserEmailCode": {
+		ActionRead:   RoleMember,
+		ActionUpdate: RoleMember,
+	},
+}
+
+func resourceKind(resource interface{}) string {
+	switch resource.(type) {
+	case models.User:
+		return "User"
+	case models.Team:
+		return "Team"
+	case models.Agency:
+		return "Agency"
+	case models.Client:
+		return "Client"
+	case models.UserInviteCode:
+		return "UserInviteCode"
+	case models.UserEmailCode:
+		return "UserEmailCode"
+	}
+	return ""
+}
+
+/*
+* Public methods
+ */
+
+// Can checks if a user can perform an action on a resource
+func Can(c context.Context, user models.User, action Action, resource interface{}) bool {
+	role := RoleFor(c, user, resource)
+	if role == RolePlatformAdmin {
+		return true
+	}
+
+	actions, ok := rules[resourceKind(resource)]
+	if !ok {
+		return false
+	}
+
+	minimumRole, ok := actions[action]
+	if !ok {
+		return false
+	}
+
+	return role >= minimumRole
+}
+
+// Authorize is the same as Can but returns an error that can be
+// returned by the controllers
+func Authorize(c context.Context, user models.User, action Action, resource interface{}) error {
+	if !Can(c, user, action, resource) {
+		return ErrForbidden
+	}
+	return nil
+}
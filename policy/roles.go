@@ -0,0 +1,211 @@
+package policy
+
+import (
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+)
+
+/*
+* Private methods
+ */
+
+/*
+* Get methods
+ */
+
+func getTeam(c context.Context, id int64) (models.Team, error) {
+	var team models.Team
+	teamId := datastore.NewKey(c, "Team", "", id, nil)
+	err := nds.Get(c, teamId, &team)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Team{}, err
+	}
+	team.Format(teamId, "teams")
+	return team, nil
+}
+
+func getAgency(c context.Context, id int64) (models.Agency, error) {
+	var agency models.Agency
+	agencyId := datastore.NewKey(c, "Agency", "", id, nil)
+	err := nds.Get(c, agencyId, &agency)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.Agency{}, err
+	}
+	agency.Format(agencyId, "agencies")
+	return agency, nil
+}
+
+/*
+* Role methods
+ */
+
+func maxRole(a Role, b Role) Role {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func worksAtAgency(user models.User, agencyId int64) bool {
+	for i := 0; i < len(user.Employers); i++ {
+		if user.Employers[i] == agencyId {
+			return true
+		}
+	}
+	return false
+}
+
+// If the user is an administrator of any of the agencies
+func administersAnyAgency(c context.Context, user models.User, agencyIds []int64) bool {
+	for i := 0; i < len(agencyIds); i++ {
+		agency, err := getAgency(c, agencyIds[i])
+		if err == nil && agency.IsAdministrator(user.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Users have full access to themselves. Their teammates and the
+// administrators of their agencies can see them.
+func roleForUser(c context.Context, user models.User, target models.User) Role {
+	if user.Id == target.Id {
+		return RoleMember
+	}
+
+	if target.TeamId != 0 && target.TeamId == user.TeamId {
+		return RoleReadOnly
+	}
+
+	if administersAnyAgency(c, user, target.Employers) {
+		return RoleReadOnly
+	}
+
+	return RoleNone
+}
+
+func roleForTeam(c context.Context, user models.User, team models.Team) Role {
+	role := RoleNone
+	if team.IsAdmin(user.Id) {
+		role = RoleTeamAdmin
+	} else if team.IsMember(user.Id) {
+		role = RoleMember
+		if team.IsReadOnly(user.Id) {
+			role = RoleReadOnly
+		}
+	}
+
+	if team.AgencyId != 0 && administersAnyAgency(c, user, []int64{team.AgencyId}) {
+		role = maxRole(role, RoleAgencyAdmin)
+	}
+
+	return role
+}
+
+func roleForAgency(user models.User, agency models.Agency) Role {
+	if agency.IsAdministrator(user.Id) {
+		return RoleAgencyAdmin
+	}
+
+	if worksAtAgency(user, agency.Id) {
+		return RoleMember
+	}
+
+	return RoleNone
+}
+
+// Clients belong to a team. If they are not on a team then they belong to
+// the person who created them.
+func roleForClient(c context.Context, user models.User, client models.Client) Role {
+	role := RoleNone
+	if client.TeamId != 0 {
+		team, err := getTeam(c, client.TeamId)
+		if err == nil {
+			role = roleForTeam(c, user, team)
+		}
+	} else if client.CreatedBy == user.Id {
+		role = RoleMember
+	}
+
+	// Agencies the client is attached to
+	ks, err := datastore.NewQuery("Agency").Filter("Clients =", client.Id).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return role
+	}
+
+	agencyIds := []int64{}
+	for i := 0; i < len(ks); i++ {
+		agencyIds = append(agencyIds, ks[i].IntID())
+	}
+
+	if administersAnyAgency(c, user, agencyIds) {
+		role = maxRole(role, RoleAgencyAdmin)
+	}
+
+	return role
+}
+
+// Anyone can invite people to the platform. Inviting them to a team
+// needs the invite to be managed, which is for the admins of the team.
+func roleForInvite(c context.Context, user models.User, invite models.UserInviteCode) Role {
+	role := RoleNone
+	if invite.CreatedBy == user.Id {
+		role = RoleMember
+	}
+
+	if invite.TeamId != 0 {
+		team, err := getTeam(c, invite.TeamId)
+		if err == nil && roleForTeam(c, user, team) >= RoleTeamAdmin {
+			role = maxRole(role, RoleTeamAdmin)
+		}
+	}
+
+	return role
+}
+
+// Codes that confirm an email address are only for the user who asked
+// for them
+func roleForEmailCode(user models.User, code models.UserEmailCode) Role {
+	if code.CreatedBy == user.Id {
+		return RoleMember
+	}
+	return RoleNone
+}
+
+/*
+* Public methods
+ */
+
+// RoleFor gets the role a user has on a resource
+func RoleFor(c context.Context, user models.User, resource interface{}) Role {
+	if user.IsAdmin {
+		return RolePlatformAdmin
+	}
+
+	switch v := resource.(type) {
+	case models.User:
+		return roleForUser(c, user, v)
+	case models.Team:
+		return roleForTeam(c, user, v)
+	case models.Agency:
+		return roleForAgency(user, v)
+	case models.Client:
+		return roleForClient(c, user, v)
+	case models.UserInviteCode:
+		return roleForInvite(c, user, v)
+	case models.UserEmailCode:
+		return roleForEmailCode(user, v)
+	}
+
+	// Collections and anything else are only for platform admins
+	return RoleNone
+}
@@ -17,10 +17,60 @@ import (
 	nError "github.com/news-ai/web/errors"
 )
 
+func handleAgencyActions(c context.Context, r *http.Request, id string, action string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		switch action {
+		case "employees":
+			val, included, count, total, err := controllers.GetAgencyEmployees(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "domain-verification":
+			return api.BaseSingleResponseHandler(controllers.GetAgencyDomainVerification(c, r, id))
+		case "billing":
+			return api.BaseSingleResponseHandler(controllers.GetAgencyPlan(c, r, id))
+		case "seats-preview":
+			return api.BaseSingleResponseHandler(controllers.PreviewAgencySeats(c, r, id))
+		case "team-requests":
+			val, included, count, total, err := controllers.GetAgencyTeamRequests(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		}
+	case "POST":
+		switch action {
+		case "remove-employee":
+			return api.BaseSingleResponseHandler(controllers.RemoveEmployeeFromAgency(c, r, id))
+		case "add-client":
+			return api.BaseSingleResponseHandler(controllers.AddClientToAgency(c, r, id))
+		case "merge":
+			return api.BaseSingleResponseHandler(controllers.MergeAgencies(c, r, id))
+		case "approve-team":
+			return api.BaseSingleResponseHandler(controllers.ApproveAgencyTeam(c, r, id))
+		case "reject-team":
+			return api.BaseSingleResponseHandler(controllers.RejectAgencyTeam(c, r, id))
+		case "sso":
+			return api.BaseSingleResponseHandler(controllers.UpdateAgencySSO(c, r, id))
+		case "sso-opt-in":
+			return api.BaseSingleResponseHandler(controllers.OptIntoAgencySSO(c, r, id))
+		case "verify-domain":
+			return api.BaseSingleResponseHandler(controllers.VerifyAgencyDomain(c, r, id))
+		case "scim-token":
+			return api.BaseSingleResponseHandler(controllers.CreateAgencySCIMToken(c, r, id))
+		case "revoke-scim-token":
+			return api.BaseSingleResponseHandler(controllers.RevokeAgencySCIMToken(c, r, id))
+		case "billing":
+			return api.BaseSingleResponseHandler(controllers.AddPlanToAgency(c, r, id))
+		case "seats":
+			return api.BaseSingleResponseHandler(controllers.UpdateAgencySeats(c, r, id))
+		}
+	}
+	return nil, errors.New("method not implemented")
+}
+
 func handleAgency(c context.Context, r *http.Request, id string) (interface{}, error) {
 	switch r.Method {
 	case "GET":
-		return api.BaseSingleResponseHandler(controllers.GetAgency(c, id))
+		return api.BaseSingleResponseHandler(controllers.GetAgency(c, r, id))
+	case "PATCH":
+		return api.BaseSingleResponseHandler(controllers.UpdateAgency(c, r, id))
 	}
 	return nil, errors.New("method not implemented")
 }
@@ -30,6 +80,8 @@ func handleAgencies(c context.Context, r *http.Request) (interface{}, error) {
 	case "GET":
 		val, included, count, total, err := controllers.GetAgencies(c, r)
 		return api.BaseResponseHandler(val, included, count, total, err, r)
+	case "POST":
+		return api.BaseSingleResponseHandler(controllers.CreateAgency(c, r))
 	}
 	return nil, errors.New("method not implemented")
 }
@@ -66,3 +118,21 @@ func AgencyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	}
 	return
 }
+
+// Handler for when the user wants to perform an action on the agencies
+func AgencyActionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	action := ps.ByName("action")
+
+	val, err := handleAgencyActions(c, r, id, action)
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Agency handling error", err.Error())
+	}
+	return
+}
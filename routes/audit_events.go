@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/controllers"
+
+	"github.com/news-ai/web/api"
+	nError "github.com/news-ai/web/errors"
+)
+
+func handleAuditEvents(c context.Context, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		val, included, count, total, err := controllers.GetAuditEvents(c, r)
+		return api.BaseResponseHandler(val, included, count, total, err, r)
+	}
+	return nil, errors.New("method not implemented")
+}
+
+// Handler for when platform admins query the audit log.
+func AuditEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	val, err := handleAuditEvents(c, r)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Audit event handling error", err.Error())
+	}
+	return
+}
@@ -17,10 +17,32 @@ import (
 	nError "github.com/news-ai/web/errors"
 )
 
+func handleClientActions(c context.Context, r *http.Request, id string, action string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		switch action {
+		case "tweets":
+			val, included, count, total, err := controllers.GetTweetsForClient(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "instagrams":
+			val, included, count, total, err := controllers.GetInstagramPostsForClient(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "headlines":
+			val, included, count, total, err := controllers.GetHeadlinesForClient(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		}
+	}
+	return nil, errors.New("method not implemented")
+}
+
 func handleClient(c context.Context, r *http.Request, id string) (interface{}, error) {
 	switch r.Method {
 	case "GET":
-		return api.BaseSingleResponseHandler(controllers.GetClient(c, id))
+		return api.BaseSingleResponseHandler(controllers.GetClient(c, r, id))
+	case "PATCH":
+		return api.BaseSingleResponseHandler(controllers.UpdateClient(c, r, id))
+	case "DELETE":
+		return api.BaseSingleResponseHandler(controllers.DeleteClient(c, r, id))
 	}
 	return nil, errors.New("method not implemented")
 }
@@ -30,11 +52,13 @@ func handleClients(c context.Context, r *http.Request) (interface{}, error) {
 	case "GET":
 		val, included, count, total, err := controllers.GetClients(c, r)
 		return api.BaseResponseHandler(val, included, count, total, err, r)
+	case "POST":
+		return api.BaseSingleResponseHandler(controllers.CreateClient(c, r))
 	}
 	return nil, errors.New("method not implemented")
 }
 
-// Handler for when the user wants all the agencies.
+// Handler for when the user wants all the clients.
 func ClientsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	c := appengine.NewContext(r)
@@ -66,3 +90,21 @@ func ClientHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	}
 	return
 }
+
+// Handler for when the user wants to perform an action on the clients
+func ClientActionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	action := ps.ByName("action")
+
+	val, err := handleClientActions(c, r, id, action)
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Client handling error", err.Error())
+	}
+	return
+}
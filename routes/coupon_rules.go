@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/controllers"
+
+	"github.com/news-ai/web/api"
+	nError "github.com/news-ai/web/errors"
+)
+
+func handleCouponRule(c context.Context, r *http.Request, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return api.BaseSingleResponseHandler(controllers.GetCouponRule(c, r, id))
+	case "PATCH":
+		return api.BaseSingleResponseHandler(controllers.UpdateCouponRule(c, r, id))
+	}
+	return nil, errors.New("method not implemented")
+}
+
+func handleCouponRules(c context.Context, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		val, included, count, total, err := controllers.GetCouponRules(c, r)
+		return api.BaseResponseHandler(val, included, count, total, err, r)
+	case "POST":
+		return api.BaseSingleResponseHandler(controllers.CreateCouponRule(c, r))
+	}
+	return nil, errors.New("method not implemented")
+}
+
+// Handler for when platform admins list or create coupon rules.
+func CouponRulesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	val, err := handleCouponRules(c, r)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Coupon rule handling error", err.Error())
+	}
+	return
+}
+
+// Handler for when there is a key present after /coupon-rules/<id> route.
+func CouponRuleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	val, err := handleCouponRule(c, r, id)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Coupon rule handling error", err.Error())
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/controllers"
+
+	"github.com/news-ai/web/api"
+	nError "github.com/news-ai/web/errors"
+)
+
+func handleInvoice(c context.Context, r *http.Request, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return api.BaseSingleResponseHandler(controllers.GetInvoice(c, r, id))
+	}
+	return nil, errors.New("method not implemented")
+}
+
+func handleInvoices(c context.Context, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		val, included, count, total, err := controllers.GetInvoices(c, r)
+		return api.BaseResponseHandler(val, included, count, total, err, r)
+	}
+	return nil, errors.New("method not implemented")
+}
+
+// Handler for when the user wants all their invoices.
+func InvoicesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	val, err := handleInvoices(c, r)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Invoice handling error", err.Error())
+	}
+	return
+}
+
+// Handler for when there is a key present after /invoices/<id> route.
+func InvoiceHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	val, err := handleInvoice(c, r, id)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Invoice handling error", err.Error())
+	}
+	return
+}
+
+// Handler for when the user downloads the receipt of an invoice.
+func InvoiceReceiptHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	receipt, err := controllers.GetInvoiceReceipt(c, r, id)
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		nError.ReturnError(w, http.StatusInternalServerError, "Invoice handling error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"receipt-"+id+".pdf\"")
+	w.Write(receipt)
+	return
+}
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/controllers"
+	"github.com/news-ai/api/models"
+)
+
+func handleSCIMUsers(c context.Context, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return controllers.GetSCIMUsers(c, r)
+	case "POST":
+		return controllers.CreateSCIMUser(c, r)
+	}
+	return nil, models.NewSCIMError(http.StatusMethodNotAllowed, "", "method not implemented")
+}
+
+func handleSCIMUser(c context.Context, r *http.Request, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return controllers.GetSCIMUser(c, r, id)
+	case "PUT":
+		return controllers.ReplaceSCIMUser(c, r, id)
+	case "PATCH":
+		return controllers.PatchSCIMUser(c, r, id)
+	case "DELETE":
+		return nil, controllers.DeactivateSCIMUser(c, r, id)
+	}
+	return nil, models.NewSCIMError(http.StatusMethodNotAllowed, "", "method not implemented")
+}
+
+func handleSCIMGroups(c context.Context, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return controllers.GetSCIMGroups(c, r)
+	case "POST":
+		return controllers.CreateSCIMGroup(c, r)
+	}
+	return nil, models.NewSCIMError(http.StatusMethodNotAllowed, "", "method not implemented")
+}
+
+func handleSCIMGroup(c context.Context, r *http.Request, id string) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return controllers.GetSCIMGroup(c, r, id)
+	case "PATCH":
+		return controllers.PatchSCIMGroup(c, r, id)
+	case "DELETE":
+		return nil, controllers.DeleteSCIMGroup(c, r, id)
+	}
+	return nil, models.NewSCIMError(http.StatusMethodNotAllowed, "", "method not implemented")
+}
+
+// SCIM clients expect SCIM errors and the status codes from RFC 7644
+// instead of our usual responses
+func writeSCIMResponse(w http.ResponseWriter, r *http.Request, val interface{}, err error) {
+	w.Header().Set("Content-Type", "application/scim+json")
+
+	if err != nil {
+		scimError, ok := err.(models.SCIMError)
+		if !ok {
+			scimError = models.NewSCIMError(http.StatusInternalServerError, "", err.Error())
+		}
+
+		w.WriteHeader(scimError.StatusCode())
+		ffjson.NewEncoder(w).Encode(scimError)
+		return
+	}
+
+	if val == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	ffjson.NewEncoder(w).Encode(val)
+}
+
+// Handler for when the directory of an agency lists or creates users.
+func SCIMUsersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
+	val, err := handleSCIMUsers(c, r)
+	writeSCIMResponse(w, r, val, err)
+}
+
+// Handler for when there is a key present after /scim/v2/Users/<id> route.
+func SCIMUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	val, err := handleSCIMUser(c, r, id)
+	writeSCIMResponse(w, r, val, err)
+}
+
+// Handler for when the directory of an agency lists or creates groups.
+func SCIMGroupsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := appengine.NewContext(r)
+	val, err := handleSCIMGroups(c, r)
+	writeSCIMResponse(w, r, val, err)
+}
+
+// Handler for when there is a key present after /scim/v2/Groups/<id> route.
+func SCIMGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	val, err := handleSCIMGroup(c, r, id)
+	writeSCIMResponse(w, r, val, err)
+}
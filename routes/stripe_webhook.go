@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/controllers"
+
+	nError "github.com/news-ai/web/errors"
+)
+
+// Handler for the events Stripe sends us. Stripe retries events that
+// don't get a 2xx response.
+func StripeWebhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 65536))
+	if err != nil {
+		nError.ReturnError(w, http.StatusBadRequest, "Stripe webhook error", err.Error())
+		return
+	}
+
+	event, err := billing.ConstructStripeEvent(payload, r.Header.Get("Stripe-Signature"))
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		nError.ReturnError(w, http.StatusBadRequest, "Stripe webhook error", err.Error())
+		return
+	}
+
+	err = controllers.HandleStripeEvent(c, r, event)
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Stripe webhook error", err.Error())
+		return
+	}
+
+	ffjson.NewEncoder(w).Encode(map[string]bool{"received": true})
+}
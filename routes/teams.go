@@ -18,13 +18,32 @@ import (
 )
 
 func handleTeamActions(c context.Context, r *http.Request, id string, action string) (interface{}, error) {
+	switch r.Method {
+	case "POST":
+		switch action {
+		case "add-member":
+			return api.BaseSingleResponseHandler(controllers.AddMemberToTeam(c, r, id))
+		case "remove-member":
+			return api.BaseSingleResponseHandler(controllers.RemoveMemberFromTeam(c, r, id))
+		case "promote-admin":
+			return api.BaseSingleResponseHandler(controllers.PromoteTeamAdmin(c, r, id))
+		case "demote-admin":
+			return api.BaseSingleResponseHandler(controllers.DemoteTeamAdmin(c, r, id))
+		case "transfer-ownership":
+			return api.BaseSingleResponseHandler(controllers.TransferTeamOwnership(c, r, id))
+		case "make-read-only":
+			return api.BaseSingleResponseHandler(controllers.MakeTeamMemberReadOnly(c, r, id))
+		case "remove-read-only":
+			return api.BaseSingleResponseHandler(controllers.RemoveTeamMemberReadOnly(c, r, id))
+		}
+	}
 	return nil, errors.New("method not implemented")
 }
 
 func handleTeam(c context.Context, r *http.Request, id string) (interface{}, error) {
 	switch r.Method {
 	case "GET":
-		return api.BaseSingleResponseHandler(controllers.GetTeam(c, id))
+		return api.BaseSingleResponseHandler(controllers.GetTeam(c, r, id))
 	}
 	return nil, errors.New("method not implemented")
 }
@@ -31,6 +31,8 @@ func handleUserActions(c context.Context, r *http.Request, id string, action str
 			return api.BaseSingleResponseHandler(controllers.ConfirmAddEmailToUser(c, r, id))
 		case "plan-details":
 			return api.BaseSingleResponseHandler(controllers.GetUserPlanDetails(c, r, id))
+		case "usage":
+			return api.BaseSingleResponseHandler(controllers.GetUserUsage(c, r, id))
 		case "campaigns":
 			val, included, count, total, err := tabulaeControllers.GetEmailCampaignsForUser(c, r, id)
 			return api.BaseResponseHandler(val, included, count, total, err, r)
@@ -38,6 +40,18 @@ func handleUserActions(c context.Context, r *http.Request, id string, action str
 			return api.BaseSingleResponseHandler(pitchControllers.GetUserProfile(c, r, id))
 		case "ban":
 			return api.BaseSingleResponseHandler(controllers.BanUser(c, r, id))
+		case "api-keys":
+			val, included, count, total, err := controllers.GetApiKeysForUser(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "sessions":
+			val, included, count, total, err := controllers.GetSessionsForUser(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "linked-accounts":
+			val, included, count, total, err := controllers.GetLinkedAccountsForUser(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
+		case "activity":
+			val, included, count, total, err := controllers.GetAccountActivityForUser(c, r, id)
+			return api.BaseResponseHandler(val, included, count, total, err, r)
 		}
 	case "POST":
 		switch action {
@@ -53,6 +67,20 @@ func handleUserActions(c context.Context, r *http.Request, id string, action str
 			return api.BaseSingleResponseHandler(pitchControllers.CreateUserProfile(c, r, id))
 		case "change-email":
 			return api.BaseSingleResponseHandler(controllers.UpdateUserEmail(c, r, id))
+		case "api-keys":
+			return api.BaseSingleResponseHandler(controllers.CreateApiKeyForUser(c, r, id))
+		case "two-factor-enroll":
+			return api.BaseSingleResponseHandler(controllers.EnrollTwoFactorForUser(c, r, id))
+		case "two-factor-confirm":
+			return api.BaseSingleResponseHandler(controllers.ConfirmTwoFactorForUser(c, r, id))
+		case "two-factor-disable":
+			return api.BaseSingleResponseHandler(controllers.DisableTwoFactorForUser(c, r, id))
+		case "two-factor-reset":
+			return api.BaseSingleResponseHandler(controllers.ResetTwoFactorForUser(c, r, id))
+		case "usage":
+			return api.BaseSingleResponseHandler(controllers.ReportUserUsage(c, r, id))
+		case "unlock":
+			return api.BaseSingleResponseHandler(controllers.UnlockUser(c, r, id))
 		}
 	case "PATCH":
 		switch action {
@@ -63,6 +91,24 @@ func handleUserActions(c context.Context, r *http.Request, id string, action str
 	return nil, errors.New("method not implemented")
 }
 
+func handleUserApiKey(c context.Context, r *http.Request, id string, keyId string) (interface{}, error) {
+	switch r.Method {
+	case "PATCH":
+		return api.BaseSingleResponseHandler(controllers.UpdateApiKeyForUser(c, r, id, keyId))
+	case "DELETE":
+		return api.BaseSingleResponseHandler(controllers.RevokeApiKeyForUser(c, r, id, keyId))
+	}
+	return nil, errors.New("method not implemented")
+}
+
+func handleUserSession(c context.Context, r *http.Request, id string, sessionId string) (interface{}, error) {
+	switch r.Method {
+	case "DELETE":
+		return api.BaseSingleResponseHandler(controllers.RevokeSessionForUser(c, r, id, sessionId))
+	}
+	return nil, errors.New("method not implemented")
+}
+
 func handleUser(c context.Context, r *http.Request, id string) (interface{}, error) {
 	switch r.Method {
 	case "GET":
@@ -137,3 +183,39 @@ func UserActionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 	}
 	return
 }
+
+// Handler for updating or revoking one of the api keys of a user
+func UserApiKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	keyId := ps.ByName("keyid")
+	val, err := handleUserApiKey(c, r, id, keyId)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "API key handling error", err.Error())
+	}
+	return
+}
+
+// Handler for revoking one of the sessions of a user
+func UserSessionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	c := appengine.NewContext(r)
+	id := ps.ByName("id")
+	sessionId := ps.ByName("sessionid")
+	val, err := handleUserSession(c, r, id, sessionId)
+
+	if err == nil {
+		err = ffjson.NewEncoder(w).Encode(val)
+	}
+
+	if err != nil {
+		nError.ReturnError(w, http.StatusInternalServerError, "Session handling error", err.Error())
+	}
+	return
+}
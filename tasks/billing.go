@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+
+	"github.com/news-ai/api/billing"
+	"github.com/news-ai/api/controllers"
+
+	"github.com/news-ai/web/errors"
+)
+
+// Sends reminders to users with a failed payment and locks their account
+// once the grace period is over
+func ProcessDunning(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	billings, err := controllers.GetPastDueBillingsUnauthorized(c)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not get past due billings", err.Error())
+		return
+	}
+
+	for i := 0; i < len(billings); i++ {
+		// Members of an agency lose their seats when its grace period is over
+		if billings[i].IsAgency {
+			if billings[i].IsGracePeriodOver() && billings[i].LockedAt.IsZero() {
+				billings[i].LockedAt = time.Now()
+				_, err = billings[i].Save(c)
+				if err != nil {
+					log.Errorf(c, "%v", err)
+					continue
+				}
+
+				err = controllers.UpdateAgencySeatAccess(c, r, billings[i])
+				if err != nil {
+					log.Errorf(c, "%v", err)
+				}
+			}
+			continue
+		}
+
+		user, err := controllers.GetUserByBillingUnauthorized(c, billings[i])
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			continue
+		}
+
+		if !billing.ProcessDunning(r, &user, &billings[i]) {
+			continue
+		}
+
+		_, err = billings[i].Save(c)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			continue
+		}
+
+		_, err = controllers.SaveUser(c, r, &user)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+}
+
+// Keeps the seats of agencies in line with the members of their teams,
+// for changes that don't go through the team actions
+func SyncAgencySeats(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	err := controllers.SyncAllAgencySeats(c, r)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not sync agency seats", err.Error())
+		return
+	}
+}
@@ -0,0 +1,297 @@
+package tasks
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"github.com/news-ai/api/auth"
+	"github.com/news-ai/api/controllers"
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/tabulae/sync"
+
+	"github.com/news-ai/web/errors"
+)
+
+// Users are walked with datastore cursors. Every page of users is handled
+// by its own task on the user-sweeps push queue.
+const (
+	userSweepBatchSize = 100
+	userSweepQueue     = "user-sweeps"
+)
+
+// A task that goes through every user
+type userSweep struct {
+	// Changes a user. Returns if the user has to be saved.
+	Process func(c context.Context, r *http.Request, user *models.User) (bool, error)
+
+	// Saves what else changed with the users of a batch, after the users
+	// that were changed have been saved. The batch fails and is retried
+	// when it returns an error.
+	SaveRelated func(c context.Context, r *http.Request, users []models.User, updatedUsers []models.User) error
+
+	// Runs after the users of a batch that were changed have been saved
+	AfterSave func(r *http.Request, users []models.User, updatedUsers []models.User)
+}
+
+var userSweeps = map[string]userSweep{
+	"refreshUserLiveTokens": {
+		Process:   refreshUserLiveToken,
+		AfterSave: syncAllUsers,
+	},
+	"refreshEmailTokens": {
+		Process:   refreshUserEmailTokens,
+		AfterSave: syncUpdatedUsers,
+	},
+	"makeUsersInactive": {
+		Process:     makeUserInactive,
+		SaveRelated: endExpiredTrials,
+		AfterSave:   syncUpdatedUsers,
+	},
+	"migrateApiKeys": {
+		Process: controllers.MigrateLegacyApiKeyUnauthorized,
+	},
+	"backfillLinkedAccounts": {
+		Process: auth.BackfillLinkedAccounts,
+	},
+	"setLegacyPlanLimits": {
+		Process:     keepUser,
+		SaveRelated: setLegacyPlanLimits,
+	},
+	"reencryptSecrets": {
+		Process:     reencryptUserSecrets,
+		SaveRelated: reencryptLinkedAccounts,
+	},
+}
+
+/*
+* Private methods
+ */
+
+// Tasks are named after their run and page so a page that is retried
+// doesn't queue its tasks twice
+func addUserSweepTask(c context.Context, path string, name string, values url.Values) error {
+	t := taskqueue.NewPOSTTask(path, values)
+	t.Name = name
+
+	_, err := taskqueue.Add(c, t, userSweepQueue)
+	if err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+		return err
+	}
+	return nil
+}
+
+func addUserSweepPageTask(c context.Context, runId int64, sweep string, page int, cursor string) error {
+	values := url.Values{
+		"run":    {strconv.FormatInt(runId, 10)},
+		"sweep":  {sweep},
+		"page":   {strconv.Itoa(page)},
+		"cursor": {cursor},
+	}
+	name := "usersweep-" + strconv.FormatInt(runId, 10) + "-page-" + strconv.Itoa(page)
+	return addUserSweepTask(c, "/tasks/userSweepPage", name, values)
+}
+
+func addUserSweepBatchTask(c context.Context, runId int64, sweep string, page int, cursor string) error {
+	values := url.Values{
+		"run":    {strconv.FormatInt(runId, 10)},
+		"sweep":  {sweep},
+		"page":   {strconv.Itoa(page)},
+		"cursor": {cursor},
+	}
+	name := "usersweep-" + strconv.FormatInt(runId, 10) + "-batch-" + strconv.Itoa(page)
+	return addUserSweepTask(c, "/tasks/userSweepBatch", name, values)
+}
+
+func logUserSweepRun(c context.Context, userSweepRun models.UserSweepRun) {
+	if userSweepRun.IsFinished() {
+		log.Infof(c, "User sweep %v (%v) finished in %v: %v users processed, %v updated, %v failed", userSweepRun.Id, userSweepRun.Sweep, userSweepRun.Finished.Sub(userSweepRun.Created), userSweepRun.UsersProcessed, userSweepRun.UsersUpdated, userSweepRun.UsersFailed)
+		return
+	}
+	log.Infof(c, "User sweep %v (%v): %v of %v batches done", userSweepRun.Id, userSweepRun.Sweep, userSweepRun.BatchesDone, userSweepRun.Batches)
+}
+
+// Starts a run from cron by queueing its first page
+func startUserSweep(w http.ResponseWriter, r *http.Request, sweep string) {
+	c := appengine.NewContext(r)
+
+	userSweepRun := models.UserSweepRun{}
+	userSweepRun.Sweep = sweep
+	_, err := userSweepRun.Create(c, r)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not start user sweep", err.Error())
+		return
+	}
+
+	err = addUserSweepPageTask(c, userSweepRun.Id, sweep, 0, "")
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not queue user sweep", err.Error())
+		return
+	}
+
+	ffjson.NewEncoder(w).Encode(userSweepRun)
+}
+
+func syncAllUsers(r *http.Request, users []models.User, updatedUsers []models.User) {
+	userIds := []int64{}
+	for i := 0; i < len(users); i++ {
+		userIds = append(userIds, users[i].Id)
+	}
+	sync.UserResourceBulkSync(r, userIds)
+}
+
+func syncUpdatedUsers(r *http.Request, users []models.User, updatedUsers []models.User) {
+	for i := 0; i < len(updatedUsers); i++ {
+		sync.ResourceSync(r, updatedUsers[i].Id, "User", "create")
+	}
+}
+
+/*
+* Public methods
+ */
+
+// Queues the batch for a page of users and then the next page
+func UserSweepPage(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	runId, err := strconv.ParseInt(r.FormValue("run"), 10, 64)
+	if err != nil {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid user sweep run", err.Error())
+		return
+	}
+	page, err := strconv.Atoi(r.FormValue("page"))
+	if err != nil {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid user sweep page", err.Error())
+		return
+	}
+	sweep := r.FormValue("sweep")
+	cursor := r.FormValue("cursor")
+
+	nextCursor, hasMore, err := controllers.GetNextUsersCursor(c, cursor, userSweepBatchSize)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not get users", err.Error())
+		return
+	}
+
+	err = addUserSweepBatchTask(c, runId, sweep, page, cursor)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not queue user sweep batch", err.Error())
+		return
+	}
+
+	if hasMore {
+		err = addUserSweepPageTask(c, runId, sweep, page+1, nextCursor)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			errors.ReturnError(w, http.StatusInternalServerError, "Could not queue user sweep page", err.Error())
+			return
+		}
+	}
+
+	userSweepRun, err := controllers.AddBatchToUserSweepRun(c, runId, page, !hasMore)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not update user sweep", err.Error())
+		return
+	}
+	logUserSweepRun(c, userSweepRun)
+}
+
+// Runs a sweep on a page of users and saves the ones that changed
+func UserSweepBatch(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	runId, err := strconv.ParseInt(r.FormValue("run"), 10, 64)
+	if err != nil {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid user sweep run", err.Error())
+		return
+	}
+
+	page, err := strconv.Atoi(r.FormValue("page"))
+	if err != nil {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid user sweep page", err.Error())
+		return
+	}
+
+	sweep, ok := userSweeps[r.FormValue("sweep")]
+	if !ok {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid user sweep", r.FormValue("sweep"))
+		return
+	}
+
+	users, err := controllers.GetUsersPageUnauthorized(c, r.FormValue("cursor"), userSweepBatchSize)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not get users", err.Error())
+		return
+	}
+
+	failed := 0
+	updatedUsers := []models.User{}
+	for i := 0; i < len(users); i++ {
+		updated, err := sweep.Process(c, r, &users[i])
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			failed++
+			continue
+		}
+
+		if updated {
+			updatedUsers = append(updatedUsers, users[i])
+		}
+	}
+
+	if len(updatedUsers) > 0 {
+		err = models.SaveUsers(c, updatedUsers)
+		if err != nil {
+			errors.ReturnError(w, http.StatusInternalServerError, "Could not save users", err.Error())
+			return
+		}
+	}
+
+	if sweep.SaveRelated != nil {
+		err = sweep.SaveRelated(c, r, users, updatedUsers)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			errors.ReturnError(w, http.StatusInternalServerError, "Could not save user sweep changes", err.Error())
+			return
+		}
+	}
+
+	if sweep.AfterSave != nil {
+		sweep.AfterSave(r, users, updatedUsers)
+	}
+
+	userSweepRun, err := controllers.FinishUserSweepBatch(c, runId, page, len(users), len(updatedUsers), failed)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not update user sweep", err.Error())
+		return
+	}
+	logUserSweepRun(c, userSweepRun)
+}
+
+// Summaries of the latest runs
+func UserSweepRuns(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	userSweepRuns, err := controllers.GetUserSweepRuns(c)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not get user sweeps", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	ffjson.NewEncoder(w).Encode(userSweepRuns)
+}
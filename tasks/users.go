@@ -5,75 +5,170 @@ import (
 	"strconv"
 	"time"
 
-	"google.golang.org/appengine"
+	"golang.org/x/net/context"
+
 	"google.golang.org/appengine/log"
 
+	"github.com/news-ai/api/auth"
 	"github.com/news-ai/api/controllers"
+	"github.com/news-ai/api/models"
+	"github.com/news-ai/api/notifications"
+	"github.com/news-ai/api/webhooks"
 
-	"github.com/news-ai/tabulae/sync"
-
-	"github.com/news-ai/web/errors"
 	"github.com/news-ai/web/utilities"
 )
 
-func RefreshUserLiveTokens(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+/*
+* Private methods
+ */
 
-	// Get users who's live tokens are going to expire in the next 10 minutes
-	users, err := controllers.GetUsersUnauthorized(c, r)
-	if err != nil {
-		log.Errorf(c, "%v", err)
-		errors.ReturnError(w, http.StatusInternalServerError, "Could not get users", err.Error())
-		return
+func refreshUserLiveToken(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	if !user.LiveAccessTokenExpire.Before(time.Now()) {
+		return false, nil
 	}
 
-	userIds := []int64{}
-	for i := 0; i < len(users); i++ {
-		if users[i].LiveAccessTokenExpire.Before(time.Now()) {
-			randomString := strconv.FormatInt(users[i].Id, 10)
-			randomString = randomString + utilities.RandToken()
-			users[i].LiveAccessToken = randomString
-			users[i].LiveAccessTokenExpire = time.Now().Local().Add(time.Hour*time.Duration(6) +
-				time.Minute*time.Duration(0) +
-				time.Second*time.Duration(0))
-			users[i].Save(c)
-		}
+	randomString := strconv.FormatInt(user.Id, 10)
+	randomString = randomString + utilities.RandToken()
+	user.LiveAccessToken = randomString
+	user.LiveAccessTokenExpire = time.Now().Local().Add(time.Hour*time.Duration(6) +
+		time.Minute*time.Duration(0) +
+		time.Second*time.Duration(0))
+	return true, nil
+}
 
-		userIds = append(userIds, users[i].Id)
+// Users are told when they have to connect Gmail or Outlook again
+func refreshUserEmailTokens(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	if !user.Gmail && !user.Outlook {
+		return false, nil
 	}
 
-	sync.UserResourceBulkSync(r, userIds)
+	updated, disconnected := auth.RefreshEmailTokens(c, r, user)
+	for i := 0; i < len(disconnected); i++ {
+		err := notifications.ReconnectEmailProvider(c, *user, disconnected[i])
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+	return updated, nil
 }
 
-func MakeUsersInactive(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+// For now only consider when they are on trial
+func isTrialExpired(billing models.Billing) bool {
+	return billing.IsOnTrial && billing.Expires.Before(time.Now())
+}
+
+// Users are saved before their billing, so a batch that fails in between
+// makes the same users inactive again when it is retried
+func makeUserInactive(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	if user.BillingId == 0 {
+		return false, nil
+	}
 
-	users, err := controllers.GetUsersUnauthorized(c, r)
+	billing, err := controllers.GetUserBilling(c, r, *user)
 	if err != nil {
-		log.Errorf(c, "%v", err)
-		errors.ReturnError(w, http.StatusInternalServerError, "Could not get users", err.Error())
-		return
+		return false, err
+	}
+
+	if !isTrialExpired(billing) {
+		return false, nil
 	}
 
-	for i := 0; i < len(users); i++ {
-		billing, err := controllers.GetUserBilling(c, r, users[i])
+	user.IsActive = false
+	return true, nil
+}
+
+// Webhooks are only triggered once the billings are saved, so a batch
+// that is retried doesn't trigger them twice
+func endExpiredTrials(c context.Context, r *http.Request, users []models.User, updatedUsers []models.User) error {
+	billings := []models.Billing{}
+	canceledUsers := []models.User{}
+	for i := 0; i < len(updatedUsers); i++ {
+		billing, err := controllers.GetUserBilling(c, r, updatedUsers[i])
 		if err != nil {
-			log.Errorf(c, "%v", users[i])
-			log.Errorf(c, "%v", err)
-			continue
+			return err
 		}
 
-		// For now only consider when they are on trial
-		if billing.IsOnTrial {
-			if billing.Expires.Before(time.Now()) {
-				users[i].IsActive = false
-				users[i].Save(c)
-				sync.ResourceSync(r, users[i].Id, "User", "create")
-
-				billing.IsOnTrial = false
-				billing.IsCancel = true
-				billing.Save(c)
-			}
+		if isTrialExpired(billing) {
+			billing.IsOnTrial = false
+			billing.IsCancel = true
+			billings = append(billings, billing)
+			canceledUsers = append(canceledUsers, updatedUsers[i])
 		}
 	}
+
+	if len(billings) == 0 {
+		return nil
+	}
+
+	err := models.SaveBillings(c, billings)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(billings); i++ {
+		webhooks.Trigger(r, models.WebhookEventUserCanceled, canceledUsers[i], map[string]interface{}{
+			"plan":    billings[i].StripePlanId,
+			"expires": billings[i].Expires,
+			"reason":  "trial_expired",
+		})
+	}
+	return nil
+}
+
+// Users are encrypted when they are saved
+func reencryptUserSecrets(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	return user.NeedsEncryption(), nil
+}
+
+func reencryptLinkedAccounts(c context.Context, r *http.Request, users []models.User, updatedUsers []models.User) error {
+	return controllers.ReencryptLinkedAccountsUnauthorized(c, users)
+}
+
+// Only the billings of the users change
+func keepUser(c context.Context, r *http.Request, user *models.User) (bool, error) {
+	return false, nil
+}
+
+func setLegacyPlanLimits(c context.Context, r *http.Request, users []models.User, updatedUsers []models.User) error {
+	return controllers.SetLegacyPlanLimitsUnauthorized(c, r, users)
+}
+
+/*
+* Public methods
+ */
+
+func RefreshUserLiveTokens(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "refreshUserLiveTokens")
+}
+
+// Refreshes Gmail and Outlook tokens before they expire so sending emails
+// doesn't fail later
+func RefreshEmailTokens(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "refreshEmailTokens")
+}
+
+func MakeUsersInactive(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "makeUsersInactive")
+}
+
+// Copies the OAuth tokens that are stored on users to linked accounts
+func BackfillLinkedAccounts(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "backfillLinkedAccounts")
+}
+
+// Moves the api keys that are stored on users to hashed api keys
+func MigrateApiKeys(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "migrateApiKeys")
+}
+
+// Keeps the limits that users had before the limits of their plan came
+// from the plan catalog. Runs once before the catalog limits are enforced.
+func SetLegacyPlanLimits(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "setLegacyPlanLimits")
+}
+
+// Encrypts secrets that were stored before encryption was added, or with
+// a key that has since been rotated out of the front of ENCRYPTIONKEYS
+func ReencryptSecrets(w http.ResponseWriter, r *http.Request) {
+	startUserSweep(w, r, "reencryptSecrets")
 }
@@ -0,0 +1,30 @@
+package tasks
+
+import (
+	"net/http"
+	"strconv"
+
+	"google.golang.org/appengine"
+
+	"github.com/news-ai/api/webhooks"
+
+	"github.com/news-ai/web/errors"
+)
+
+// Makes an attempt at a webhook delivery. Failing makes the queue retry
+// the delivery with backoff.
+func DeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	deliveryId, err := strconv.ParseInt(r.FormValue("delivery"), 10, 64)
+	if err != nil {
+		errors.ReturnError(w, http.StatusBadRequest, "Invalid webhook delivery", err.Error())
+		return
+	}
+
+	err = webhooks.Deliver(c, deliveryId)
+	if err != nil {
+		errors.ReturnError(w, http.StatusInternalServerError, "Could not deliver webhook", err.Error())
+		return
+	}
+}
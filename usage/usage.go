@@ -0,0 +1,323 @@
+// Package usage meters what users, teams and agencies use each day and
+// month, like emails sent and media database searches, and checks it
+// against the limits of their plan.
+package usage
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+)
+
+// Limits that are never reached
+const Unlimited = -1
+
+// Who usage is counted for
+const (
+	OwnerUser   = "user"
+	OwnerTeam   = "team"
+	OwnerAgency = "agency"
+)
+
+type Owner struct {
+	Type string
+	Id   int64
+
+	// Users whose usage counts towards a team or an agency. Only used to
+	// start the counters of teams and agencies.
+	MemberIds []int64
+}
+
+// The limits of a metric and who they are checked against
+type Limits struct {
+	Owner Owner
+
+	Daily   int
+	Monthly int
+}
+
+type LimitError struct {
+	Metric string
+	Period string
+	Limit  int
+}
+
+var metricNames = map[string]string{
+	models.UsageEmailsSent:        "emails sent",
+	models.UsageEnrichmentCredits: "enrichment credits",
+	models.UsageMediaSearches:     "media database searches",
+}
+
+/*
+* Private methods
+ */
+
+func periodStart(period string, t time.Time) time.Time {
+	if period == models.UsagePeriodMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func periodEnd(period string, start time.Time) time.Time {
+	if period == models.UsagePeriodMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func counterKey(owner Owner, metric string, period string, start time.Time) string {
+	date := start.Format("2006-01-02")
+	if period == models.UsagePeriodMonth {
+		date = start.Format("2006-01")
+	}
+	return owner.Type + ":" + strconv.FormatInt(owner.Id, 10) + ":" + metric + ":" + period + ":" + date
+}
+
+func newCounter(owner Owner, metric string, period string, t time.Time) models.UsageCounter {
+	start := periodStart(period, t)
+	return models.UsageCounter{
+		Key:         counterKey(owner, metric, period, start),
+		OwnerType:   owner.Type,
+		OwnerId:     owner.Id,
+		Metric:      metric,
+		Period:      period,
+		PeriodStart: start,
+	}
+}
+
+// Emails were counted from the emails that were sent before there were
+// counters. Counters that are missing start from there.
+func countSentEmails(c context.Context, userId int64, start time.Time, end time.Time) (int, error) {
+	ks, err := datastore.NewQuery("Email").Filter("CreatedBy =", userId).Filter("IsSent =", true).Filter("Cancel =", false).Filter("Created <", end).Filter("Created >=", start).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(ks), nil
+}
+
+func initialCount(c context.Context, owner Owner, metric string, period string, t time.Time) (int, error) {
+	if metric != models.UsageEmailsSent {
+		return 0, nil
+	}
+
+	start := periodStart(period, t)
+	if owner.Type == OwnerUser {
+		return countSentEmails(c, owner.Id, start, periodEnd(period, start))
+	}
+
+	count := 0
+	for i := 0; i < len(owner.MemberIds); i++ {
+		memberCounter, err := getCounter(c, Owner{Type: OwnerUser, Id: owner.MemberIds[i]}, metric, period, t)
+		if err != nil {
+			return 0, err
+		}
+		count += memberCounter.Count
+	}
+	return count, nil
+}
+
+// Gets a counter and creates it when it is missing
+func getCounter(c context.Context, owner Owner, metric string, period string, t time.Time) (models.UsageCounter, error) {
+	counter := newCounter(owner, metric, period, t)
+	counterKey := counter.CounterKey(c)
+
+	err := nds.Get(c, counterKey, &counter)
+	if err == nil {
+		counter.Format(counterKey, "usagecounters")
+		counter.Key = counterKey.StringID()
+		return counter, nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		log.Errorf(c, "%v", err)
+		return models.UsageCounter{}, err
+	}
+
+	count, err := initialCount(c, owner, metric, period, t)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UsageCounter{}, err
+	}
+
+	// Something else could have created the counter in the meantime
+	err = nds.RunInTransaction(c, func(tc context.Context) error {
+		err := nds.Get(tc, counterKey, &counter)
+		if err == nil {
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		counter.Count = count
+		_, err = counter.Save(tc)
+		return err
+	}, nil)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return models.UsageCounter{}, err
+	}
+
+	counter.Format(counterKey, "usagecounters")
+	counter.Key = counterKey.StringID()
+	return counter, nil
+}
+
+func isSameOwner(a Owner, b Owner) bool {
+	return a.Type == b.Type && a.Id == b.Id
+}
+
+// Checks if an amount of a metric can be added to the usage of the owner
+// of the limits. Negative amounts give usage back and are not checked.
+func checkLimits(metric string, amount int, limits Limits, dayCount int, monthCount int) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	if limits.Daily != Unlimited && dayCount+amount > limits.Daily {
+		return LimitError{Metric: metric, Period: models.UsagePeriodDay, Limit: limits.Daily}
+	}
+	if limits.Monthly != Unlimited && monthCount+amount > limits.Monthly {
+		return LimitError{Metric: metric, Period: models.UsagePeriodMonth, Limit: limits.Monthly}
+	}
+	return nil
+}
+
+/*
+* Public methods
+ */
+
+func User(user models.User) Owner {
+	return Owner{
+		Type: OwnerUser,
+		Id:   user.Id,
+	}
+}
+
+func Team(team models.Team) Owner {
+	memberIds := []int64{}
+	memberIds = append(memberIds, team.Members...)
+	memberIds = append(memberIds, team.ReadOnlyMembers...)
+	return Owner{
+		Type:      OwnerTeam,
+		Id:        team.Id,
+		MemberIds: memberIds,
+	}
+}
+
+// Agencies on an agency plan share usage between everyone with a seat
+func Agency(agencyBilling models.Billing) Owner {
+	return Owner{
+		Type:      OwnerAgency,
+		Id:        agencyBilling.AgencyId,
+		MemberIds: agencyBilling.SeatUserIds,
+	}
+}
+
+func (e LimitError) Error() string {
+	limitPeriod := "daily"
+	if e.Period == models.UsagePeriodMonth {
+		limitPeriod = "monthly"
+	}
+	return "You have reached the " + limitPeriod + " limit of " + strconv.Itoa(e.Limit) + " " + metricNames[e.Metric] + " on your plan"
+}
+
+/*
+* Get methods
+ */
+
+// Usage of a metric today and this month
+func Get(c context.Context, owner Owner, metric string) (int, int, error) {
+	t := time.Now()
+
+	dayCounter, err := getCounter(c, owner, metric, models.UsagePeriodDay, t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	monthCounter, err := getCounter(c, owner, metric, models.UsagePeriodMonth, t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return dayCounter.Count, monthCounter.Count, nil
+}
+
+/*
+* Action methods
+ */
+
+// Checks that an amount of a metric is within the limits and counts it
+// for the owner of the limits and every other owner. Nothing is counted
+// when a limit would be passed. Negative amounts give usage back and are
+// not checked.
+func Consume(c context.Context, metric string, amount int, limits Limits, owners ...Owner) error {
+	if _, ok := metricNames[metric]; !ok {
+		return errors.New("Unknown usage metric")
+	}
+
+	t := time.Now()
+	periods := []string{models.UsagePeriodDay, models.UsagePeriodMonth}
+
+	allOwners := []Owner{limits.Owner}
+	for i := 0; i < len(owners); i++ {
+		if !isSameOwner(owners[i], limits.Owner) {
+			allOwners = append(allOwners, owners[i])
+		}
+	}
+
+	// Counters are created before the transaction since missing ones
+	// start from a query
+	counterKeys := []*datastore.Key{}
+	for i := 0; i < len(allOwners); i++ {
+		for x := 0; x < len(periods); x++ {
+			counter, err := getCounter(c, allOwners[i], metric, periods[x], t)
+			if err != nil {
+				return err
+			}
+			counterKeys = append(counterKeys, counter.CounterKey(c))
+		}
+	}
+
+	err := nds.RunInTransaction(c, func(tc context.Context) error {
+		counters := make([]models.UsageCounter, len(counterKeys))
+		err := nds.GetMulti(tc, counterKeys, counters)
+		if err != nil {
+			return err
+		}
+
+		// The counters of the owner of the limits are first
+		err = checkLimits(metric, amount, limits, counters[0].Count, counters[1].Count)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < len(counters); i++ {
+			counters[i].Count += amount
+			if counters[i].Count < 0 {
+				counters[i].Count = 0
+			}
+			counters[i].Updated = time.Now()
+		}
+
+		_, err = nds.PutMulti(tc, counterKeys, counters)
+		return err
+	}, &datastore.TransactionOptions{XG: true, Attempts: 10})
+
+	if err != nil {
+		if _, ok := err.(LimitError); !ok {
+			log.Errorf(c, "%v", err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/news-ai/api/models"
+)
+
+func TestCheckLimits(t *testing.T) {
+	limits := Limits{Daily: 10, Monthly: 100}
+
+	tests := []struct {
+		name       string
+		amount     int
+		limits     Limits
+		dayCount   int
+		monthCount int
+		period     string
+	}{
+		{name: "within the limits", amount: 1, limits: limits, dayCount: 5, monthCount: 50},
+		{name: "up to the daily limit", amount: 5, limits: limits, dayCount: 5, monthCount: 50},
+		{name: "past the daily limit", amount: 6, limits: limits, dayCount: 5, monthCount: 50, period: models.UsagePeriodDay},
+		{name: "at the daily limit", amount: 1, limits: limits, dayCount: 10, monthCount: 50, period: models.UsagePeriodDay},
+		{name: "up to the monthly limit", amount: 1, limits: limits, dayCount: 0, monthCount: 99},
+		{name: "past the monthly limit", amount: 2, limits: limits, dayCount: 0, monthCount: 99, period: models.UsagePeriodMonth},
+		{name: "past both limits", amount: 1, limits: limits, dayCount: 10, monthCount: 100, period: models.UsagePeriodDay},
+		{name: "unlimited daily", amount: 50, limits: Limits{Daily: Unlimited, Monthly: 100}, dayCount: 40, monthCount: 40},
+		{name: "unlimited daily past the monthly limit", amount: 50, limits: Limits{Daily: Unlimited, Monthly: 100}, dayCount: 60, monthCount: 60, period: models.UsagePeriodMonth},
+		{name: "unlimited", amount: 1000, limits: Limits{Daily: Unlimited, Monthly: Unlimited}, dayCount: 1000, monthCount: 1000},
+		{name: "limit of zero", amount: 1, limits: Limits{Daily: 0, Monthly: 0}, period: models.UsagePeriodDay},
+		{name: "giving usage back past the limits", amount: -1, limits: limits, dayCount: 20, monthCount: 200},
+		{name: "nothing past the limits", amount: 0, limits: limits, dayCount: 20, monthCount: 200},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		err := checkLimits(models.UsageEmailsSent, tests[i].amount, tests[i].limits, tests[i].dayCount, tests[i].monthCount)
+		if tests[i].period == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tests[i].name, err)
+			}
+			continue
+		}
+
+		limitError, ok := err.(LimitError)
+		if !ok {
+			t.Errorf("%s: got %v, want a LimitError", tests[i].name, err)
+			continue
+		}
+		if limitError.Period != tests[i].period || limitError.Metric != models.UsageEmailsSent {
+			t.Errorf("%s: got a %s limit of %s, want a %s limit", tests[i].name, limitError.Period, limitError.Metric, tests[i].period)
+		}
+	}
+}
+
+func TestLimitErrorMessage(t *testing.T) {
+	err := LimitError{Metric: models.UsageEmailsSent, Period: models.UsagePeriodDay, Limit: 2000}
+	if err.Error() != "You have reached the daily limit of 2000 emails sent on your plan" {
+		t.Errorf("daily limit message is %q", err.Error())
+	}
+
+	err = LimitError{Metric: models.UsageMediaSearches, Period: models.UsagePeriodMonth, Limit: 50}
+	if err.Error() != "You have reached the monthly limit of 50 media database searches on your plan" {
+		t.Errorf("monthly limit message is %q", err.Error())
+	}
+}
+
+func TestCounterPeriods(t *testing.T) {
+	at := time.Date(2017, time.January, 31, 18, 30, 0, 0, time.Local)
+	owner := Owner{Type: OwnerTeam, Id: 42}
+
+	dayStart := periodStart(models.UsagePeriodDay, at)
+	if !dayStart.Equal(time.Date(2017, time.January, 31, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("day starts at %v", dayStart)
+	}
+	if !periodEnd(models.UsagePeriodDay, dayStart).Equal(time.Date(2017, time.February, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("day ends at %v", periodEnd(models.UsagePeriodDay, dayStart))
+	}
+
+	monthStart := periodStart(models.UsagePeriodMonth, at)
+	if !monthStart.Equal(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("month starts at %v", monthStart)
+	}
+	if !periodEnd(models.UsagePeriodMonth, monthStart).Equal(time.Date(2017, time.February, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("month ends at %v", periodEnd(models.UsagePeriodMonth, monthStart))
+	}
+
+	if key := counterKey(owner, models.UsageEmailsSent, models.UsagePeriodDay, dayStart); key != "team:42:emails-sent:day:2017-01-31" {
+		t.Errorf("day counter key is %q", key)
+	}
+	if key := counterKey(owner, models.UsageEmailsSent, models.UsagePeriodMonth, monthStart); key != "team:42:emails-sent:month:2017-01" {
+		t.Errorf("month counter key is %q", key)
+	}
+}
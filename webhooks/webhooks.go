@@ -0,0 +1,278 @@
+// Package webhooks sends account lifecycle events to the endpoints that
+// subscribe to them.
+//
+// Every event is delivered by a task on the webhooks push queue, which
+// retries failed deliveries with backoff. Payloads are signed with the
+// secret of the subscription in the X-NewsAI-Signature header:
+//
+//	t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<payload>">
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+
+	"github.com/qedus/nds"
+
+	"github.com/news-ai/api/models"
+
+	"github.com/news-ai/web/utilities"
+)
+
+// Deliveries are given up on after this many attempts. The queue in
+// queue.yaml retries at least this many times.
+const maxDeliveryAttempts = 8
+
+const webhookQueue = "webhooks"
+
+// The body that is sent to a subscription
+type Payload struct {
+	// The same for every subscription the event is sent to
+	Id      string                 `json:"id"`
+	Event   string                 `json:"event"`
+	Created time.Time              `json:"created"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// The parts of a user that are sent in payloads
+type payloadUser struct {
+	Id        int64  `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	IsActive  bool   `json:"isactive"`
+	IsBanned  bool   `json:"isbanned"`
+}
+
+/*
+* Private methods
+ */
+
+func getSubscriptionsForEvent(c context.Context, event string) ([]models.WebhookSubscription, error) {
+	ks, err := datastore.NewQuery("WebhookSubscription").Filter("Events =", event).Filter("Active =", true).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return []models.WebhookSubscription{}, err
+	}
+
+	subscriptions := make([]models.WebhookSubscription, len(ks))
+	err = nds.GetMulti(c, ks, subscriptions)
+	if err != nil {
+		return []models.WebhookSubscription{}, err
+	}
+
+	for i := 0; i < len(subscriptions); i++ {
+		subscriptions[i].Format(ks[i], "webhooks")
+	}
+	return subscriptions, nil
+}
+
+func getDelivery(c context.Context, id int64) (models.WebhookDelivery, error) {
+	deliveryId := datastore.NewKey(c, "WebhookDelivery", "", id, nil)
+
+	var delivery models.WebhookDelivery
+	err := nds.Get(c, deliveryId, &delivery)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	delivery.Format(deliveryId, "webhookdeliveries")
+	return delivery, nil
+}
+
+func getSubscription(c context.Context, id int64) (models.WebhookSubscription, error) {
+	subscriptionId := datastore.NewKey(c, "WebhookSubscription", "", id, nil)
+
+	var subscription models.WebhookSubscription
+	err := nds.Get(c, subscriptionId, &subscription)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	subscription.Format(subscriptionId, "webhooks")
+	return subscription, nil
+}
+
+func addDeliveryTask(c context.Context, delivery models.WebhookDelivery) error {
+	t := taskqueue.NewPOSTTask("/tasks/deliverWebhook", url.Values{
+		"delivery": {strconv.FormatInt(delivery.Id, 10)},
+	})
+	_, err := taskqueue.Add(c, t, webhookQueue)
+	return err
+}
+
+// Sends the payload of a delivery. Returns the response status and the
+// start of the body.
+func post(c context.Context, subscription models.WebhookSubscription, delivery models.WebhookDelivery) (int, string, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", subscription.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NewsAI-Webhooks")
+	req.Header.Set("X-NewsAI-Event", delivery.Event)
+	req.Header.Set("X-NewsAI-Delivery", strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set("X-NewsAI-Signature", "t="+timestamp+",v1="+Sign(subscription.Secret, timestamp, delivery.Payload))
+
+	contextWithTimeout, _ := context.WithTimeout(c, time.Second*10)
+	client := urlfetch.Client(contextWithTimeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: 1024})
+	return resp.StatusCode, string(body), nil
+}
+
+/*
+* Public methods
+ */
+
+func Sign(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sends an event about a user to every subscription to it. Data is added
+// to the payload next to the user.
+func Trigger(r *http.Request, event string, user models.User, data map[string]interface{}) {
+	c := appengine.NewContext(r)
+
+	subscriptions, err := getSubscriptionsForEvent(c, event)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return
+	}
+
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	payloadData := map[string]interface{}{}
+	for key, value := range data {
+		payloadData[key] = value
+	}
+	payloadData["user"] = payloadUser{
+		Id:        user.Id,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		IsActive:  user.IsActive,
+		IsBanned:  user.IsBanned,
+	}
+
+	payload, err := json.Marshal(Payload{
+		Id:      utilities.RandToken(),
+		Event:   event,
+		Created: time.Now(),
+		Data:    payloadData,
+	})
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return
+	}
+
+	for i := 0; i < len(subscriptions); i++ {
+		delivery := models.WebhookDelivery{}
+		delivery.SubscriptionId = subscriptions[i].Id
+		delivery.Event = event
+		delivery.Payload = string(payload)
+
+		_, err = delivery.Create(c, r)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+			continue
+		}
+
+		err = addDeliveryTask(c, delivery)
+		if err != nil {
+			log.Errorf(c, "%v", err)
+		}
+	}
+}
+
+// Makes an attempt at a delivery. Returns an error when the delivery
+// should be retried.
+func Deliver(c context.Context, id int64) error {
+	delivery, err := getDelivery(c, id)
+	if err != nil {
+		log.Errorf(c, "%v", err)
+		return err
+	}
+
+	if delivery.Status != models.WebhookDeliveryPending {
+		return nil
+	}
+
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+
+	subscription, err := getSubscription(c, delivery.SubscriptionId)
+	if err != nil || !subscription.Active {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.LastError = "The subscription has been removed or deactivated"
+		delivery.Save(c)
+		return nil
+	}
+
+	status, body, err := post(c, subscription, delivery)
+	delivery.ResponseStatus = status
+	delivery.ResponseBody = body
+	delivery.LastError = ""
+	if err != nil {
+		delivery.LastError = err.Error()
+	} else if status < 200 || status > 299 {
+		err = errors.New("The endpoint responded with " + strconv.Itoa(status))
+		delivery.LastError = err.Error()
+	}
+
+	if err == nil {
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.Delivered = time.Now()
+	} else if delivery.Attempts >= maxDeliveryAttempts {
+		delivery.Status = models.WebhookDeliveryFailed
+	}
+
+	_, saveErr := delivery.Save(c)
+	if saveErr != nil {
+		return saveErr
+	}
+
+	if delivery.Status == models.WebhookDeliveryPending {
+		return err
+	}
+	return nil
+}
+
+// Sends a delivery again, like after an endpoint was fixed
+func Redeliver(c context.Context, delivery models.WebhookDelivery) error {
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	_, err := delivery.Save(c)
+	if err != nil {
+		return err
+	}
+	return addDeliveryTask(c, delivery)
+}